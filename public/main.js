@@ -3,11 +3,77 @@ class Main extends React.Component {
         super(props);
         this.state = {
             history: HISTORY,
-            running_process_ids: [],
+            running_processes: [],
             viewing_process_ids: [],
             finished_jobs: [],
             manual_scroll: false,
+            // output is a list of segments {stream, html, time}, consecutive frames of the same stream share a segment
+            output: [],
+            output_length: 0,
+            errors_only: false,
+            // form is the command waiting for its params, see runCommand
+            form: null,
+            status_loaded_at: Date.now(),
+            now: Date.now(),
         }
+        this.logSocket = new LogSocket(frame => this.appendOutput(frame));
+    }
+
+    // listenEvents reloads the status whenever a process is queued, started, finished or removed,
+    // EventSource reconnects by itself and resumes from the last event id
+    listenEvents() {
+        let events = new EventSource('events?include_log=false');
+        events.onopen = () => this.loadStatus();
+        for(let type of ['process-queued', 'process-started', 'process-finished', 'process-removed', 'reload'])
+            events.addEventListener(type, () => this.loadStatus());
+    }
+
+    appendOutput(frame) {
+        if(frame.type === 'error') {
+            console.log(frame.error);
+            return;
+        }
+        // the server renders the output as html, see LogSocket
+        let html = frame.html || '';
+        let stream = frame.stream || 'output';
+        if(frame.type === 'skipped') {
+            html = '\n... ' + frame.skipped + ' bytes skipped ...\n';
+            stream = 'system';
+        }
+        let time = Date.parse(frame.timestamp);
+        let output = this.state.output.slice();
+        let last = output[output.length - 1];
+        // segments stay small so the oldest ones can be dropped whole, cutting html in the middle would break it
+        if(last && last.stream === stream && last.html.length < 10000)
+            output[output.length - 1] = Object.assign({}, last, {html: last.html + html});
+        else
+            output.push({stream, html, time});
+        let length = this.state.output_length + html.length;
+        while(length > 500000 && output.length > 1)
+            length -= output.shift().html.length;
+        this.setState({output, output_length: length});
+    }
+
+    // renderOutput colours stderr and the messages of the server, the title of a segment is its time
+    // relative to the first segment shown
+    renderOutput() {
+        let output = this.state.output;
+        if(this.state.errors_only)
+            output = output.filter(segment => segment.stream === 'stderr');
+        if(output.length === 0)
+            return null;
+        let start = output[0].time;
+        let colors = {stderr: 'red', system: 'grey'};
+        return output.map((segment, k) =>
+            <span key={k} style={{color: colors[segment.stream]}}
+                  title={segment.stream + ' +' + ((segment.time - start) / 1000).toFixed(3) + 's'}
+                  dangerouslySetInnerHTML={{__html: segment.html}} />
+        );
+    }
+
+    watch(ids) {
+        this.setState({viewing_process_ids: ids, output: [], output_length: 0}, () => this.resizeTerminal());
+        this.logSocket.watch(ids);
     }
 
     post(uri, body) {
@@ -55,7 +121,7 @@ class Main extends React.Component {
         //         continue;
         //     }
         // }
-        this.setState({running_process_ids: res.running_process_ids, finished_jobs: res.finished_jobs});
+        this.setState({running_processes: res.running_processes, finished_jobs: res.finished_jobs, status_loaded_at: Date.now()});
     }
 
     componentDidMount() {
@@ -64,8 +130,8 @@ class Main extends React.Component {
             if(that.state.manual_scroll)
                 return;
             // scroll the output to bottom
-            let output = $('#output').contents();
-            output.scrollTop(output.height());
+            let output = document.querySelector('#output');
+            output.scrollTop = output.scrollHeight;
         }, 200);
 
         $("body").height(window.innerHeight);
@@ -79,18 +145,109 @@ class Main extends React.Component {
             }
         });
 
-        // get server status intervally
-        setInterval(() => this.loadStatus(), 1000);
+        this.listenEvents();
+        window.addEventListener('resize', () => this.resizeTerminal());
+        // durations of running jobs are counted on the client between two status loads
+        setInterval(() => this.setState({now: Date.now()}), 1000);
     }
 
+    // runCommand shows the form of a command declaring params when none are typed after "command:"
     async runCommand(command) {
+        if(command.indexOf(':') < 0) {
+            let description = null;
+            try {
+                description = JSON.parse(await this.get('describe?command=' + encodeURIComponent(command)));
+            } catch(e) {
+                // an unknown command is still sent, the server records why it failed
+            }
+            if(description !== null && description.params.length > 0) {
+                let values = {};
+                for(let param of description.params)
+                    values[param.name] = param.type === 'bool' ? param.default === 'true' : (param.default || '');
+                this.setState({form: {description, values, error: ''}});
+                return;
+            }
+        }
         await this.post('run', 'command=' + encodeURIComponent(command));
+        this.afterRun(command);
+    }
+
+    afterRun(command) {
         let history = this.state.history;
         history.unshift(command);
-        this.setState({text: '', history});
+        this.setState({text: '', history, form: null});
         this.loadStatus();
     }
 
+    // submitForm sends the values as name="value" pairs, the server checks them against the declared params
+    async submitForm() {
+        let form = this.state.form;
+        let pairs = [];
+        for(let param of form.description.params) {
+            let value = form.values[param.name];
+            if(param.type === 'bool')
+                value = value ? 'true' : 'false';
+            if(value === '')
+                continue;
+            pairs.push(param.name + '="' + String(value).replace(/[\\"]/g, c => '\\' + c) + '"');
+        }
+        let param = pairs.join(' ');
+        try {
+            await this.post('run', 'command=' + encodeURIComponent(form.description.command) + '&param=' + encodeURIComponent(param));
+        } catch(e) {
+            this.setState({form: Object.assign({}, form, {error: e})});
+            return;
+        }
+        this.afterRun(form.description.command + (param === '' ? '' : ': ' + param));
+    }
+
+    setFormValue(name, value) {
+        let form = this.state.form;
+        this.setState({form: Object.assign({}, form, {values: Object.assign({}, form.values, {[name]: value})})});
+    }
+
+    renderFormField(param) {
+        let value = this.state.form.values[param.name];
+        if(param.type === 'bool')
+            return <input type="checkbox" checked={value} onChange={e => this.setFormValue(param.name, e.target.checked)} />;
+        if(param.type === 'enum')
+            return (
+                <select value={value} onChange={e => this.setFormValue(param.name, e.target.value)}>
+                    <option value="" />
+                    {(param.choices || []).map(choice => <option key={choice} value={choice}>{choice}</option>)}
+                </select>
+            );
+        let list = param.choices && param.choices.length > 0 ? 'choices-' + param.name : undefined;
+        return (
+            <span>
+                <input type={param.type === 'int' ? 'number' : 'text'} list={list} value={value} style={{width: '60%'}}
+                       placeholder={param.type === 'file path' ? '/path/to/file' : ''}
+                       onChange={e => this.setFormValue(param.name, e.target.value)}
+                       onKeyDown={e => e.keyCode === 13 && this.submitForm()} />
+                {list && <datalist id={list}>{param.choices.map(choice => <option key={choice} value={choice} />)}</datalist>}
+            </span>
+        );
+    }
+
+    renderForm() {
+        let form = this.state.form;
+        return (
+            <div style={{flex: 1, padding: '0.5%', backgroundColor: '#eeeeee'}}>
+                <b>{form.description.command}</b>
+                {form.description.params.map(param =>
+                    <div key={param.name} style={{margin: '4px 0'}} title={param.description}>
+                        <span style={{display: 'inline-block', width: '20%'}}>{param.name}{param.required ? ' *' : ''}</span>
+                        {this.renderFormField(param)}
+                        {param.choices_error && <span style={{color: 'red'}}> {param.choices_error}</span>}
+                    </div>
+                )}
+                {form.error && <div style={{color: 'red'}}>{form.error}</div>}
+                <button onClick={() => this.submitForm()}>run</button>
+                <button onClick={() => this.setState({form: null})}>cancel</button>
+            </div>
+        );
+    }
+
     handleKeyDownOnSearchInput(e) {
         console.log(e);
         if(e.keyCode === 13) {
@@ -103,6 +260,32 @@ class Main extends React.Component {
         }
     }
 
+    // the input box only shows up while a single process is watched
+    handleKeyDownOnProcessInput(e) {
+        let process_id = this.state.viewing_process_ids[0];
+        if(e.keyCode === 13) {
+            this.logSocket.send({action: 'input', process_id, text: (this.state.input || '') + '\n'});
+            this.setState({input: ''});
+        } else if(e.ctrlKey && e.key === 'd') {
+            e.preventDefault();
+            this.logSocket.send({action: 'input', process_id, text: this.state.input || '', close: true});
+            this.setState({input: ''});
+        }
+    }
+
+    // resizeTerminal tells a process running in a pseudo terminal how many rows and columns the output can show
+    resizeTerminal() {
+        if(this.state.viewing_process_ids.length !== 1)
+            return;
+        let process = this.state.running_processes.find(item => item.process_id === this.state.viewing_process_ids[0]);
+        if(!process || !process.pty)
+            return;
+        let output = document.querySelector('#output');
+        let rows = Math.floor(output.clientHeight / 16);
+        let cols = Math.floor(output.clientWidth / 8);
+        this.logSocket.send({action: 'resize', process_id: process.process_id, rows, cols});
+    }
+
     async closeProcess(process_id) {
         await this.post('close-process', 'process_id=' + process_id);
         this.loadStatus();
@@ -120,11 +303,21 @@ class Main extends React.Component {
                                placeholder="what do you want ?" value={this.state.text}>
                         </input>
                     </div>
+                    {this.state.form && this.renderForm()}
                     <div style={{flex: 1}}>
                         <input type="checkbox" title="manual scroll" onChange={() => this.setState({manual_scroll: !this.state.manual_scroll})} />
                         <span>manual scroll</span>
+                        <input type="checkbox" title="errors only" onChange={() => this.setState({errors_only: !this.state.errors_only})} />
+                        <span>errors only</span>
                     </div>
-                    <iframe id="output" src={"/log?process_id=" + this.state.viewing_process_ids.join(',')} style={{width: '100%', flex: 100, backgroundColor:'white', color: 'black'}} />
+                    <pre id="output" style={{width: '100%', flex: 100, margin: 0, overflowY: 'scroll', whiteSpace: 'pre-wrap', backgroundColor:'white', color: 'black'}}>{this.renderOutput()}</pre>
+                    {this.state.viewing_process_ids.length === 1 &&
+                        <input style={{padding: '0.5%', width: '99%', fontFamily: 'monospace'}} type="text"
+                               placeholder="input for the process, enter sends a line, ctrl-d closes the input"
+                               value={this.state.input || ''}
+                               onChange={e => this.setState({input: e.target.value})}
+                               onKeyDown={e => this.handleKeyDownOnProcessInput(e)} />
+                    }
                 </div>
                 <div style={{flex: 1}} />
                 <div style={{flex: 30, height: '100%', float: 'right', overflowY: 'scroll', backgroundColor: 'black', color: 'white'}}>
@@ -132,16 +325,16 @@ class Main extends React.Component {
                         key={1}
                         processes={this.state.finished_jobs}
                         viewingProcessIds={this.state.viewing_process_ids}
-                        onWatchChange={ids => this.setState({viewing_process_ids: ids})}
+                        onWatchChange={ids => this.watch(ids)}
                         willClose={process_id => this.closeProcess(process_id)}
                         backgroundColor='#444444'
-                        disableTimer={true}
                     />
                     <JobCollection
                         key={2}
-                        processes={this.state.running_process_ids}
+                        processes={this.state.running_processes}
+                        elapsedMs={Math.max(0, this.state.now - this.state.status_loaded_at)}
                         viewingProcessIds={this.state.viewing_process_ids}
-                        onWatchChange={ids => this.setState({viewing_process_ids: ids})}
+                        onWatchChange={ids => this.watch(ids)}
                         willClose={process_id => this.closeProcess(process_id)}
                     />
                     <History history={this.state.history}
@@ -183,20 +376,42 @@ class JobCollection extends React.Component {
             return self.indexOf(value) === index;
         });
     }
+    // nested puts the nodes of a workflow right after it, with their depth
+    nested() {
+        let processes = this.props.processes || [];
+        let ids = processes.map(item => item.process_id);
+        let output = [];
+        let add = (item, depth) => {
+            output.push({item, depth});
+            processes.filter(child => child.parent_id === item.process_id)
+                .forEach(child => add(child, depth + 1));
+        };
+        processes.filter(item => !item.parent_id || ids.indexOf(item.parent_id) < 0)
+            .forEach(item => add(item, 0));
+        return output;
+    }
     render() {
         console.log('render JobCollection', this.props.processes, this.props.viewingProcessIds);
         return (
             <div style={{padding: '5%', backgroundColor: this.props.backgroundColor || '#222222'}} className="job-container">
                 {
-                    this.props.processes && this.props.processes.map(item => {
+                    this.nested().map(({item, depth}) => {
                         let process_id = item.process_id;
                         let command = item.command;
                         return (
                             <Job key={process_id}
                                  isWatching={this.props.viewingProcessIds.indexOf(process_id) >= 0}
                                  command={command}
+                                 param={item.param}
+                                 state={item.state}
+                                 exitCode={item.exit_code}
+                                 error={item.error}
+                                 durationMs={item.duration_ms + (item.state === 'running' || item.state === 'stopping' ? this.props.elapsedMs || 0 : 0)}
+                                 queuePosition={item.queue_position}
                                  processId={process_id}
-                                 disableTimer={this.props.disableTimer}
+                                 parentId={item.parent_id}
+                                 schedule={item.schedule}
+                                 depth={depth}
                                  startWatch={() => {
                                      let ids = this.props.viewingProcessIds;
                                      ids.push(process_id);
@@ -226,23 +441,25 @@ class Job extends React.Component {
     constructor(props) {
         super(props);
         this.state = {
-            time: 0,
-            timeDisplay: '',
             isClosing: false,
         };
     }
-    componentDidMount() {
-        if(this.props.disableTimer)
-            return;
-        setInterval(() => {
-            let time = this.state.time + 1;
-            let min = Math.floor(time / 60);
-            let sec = time % 60;
-            if(min === 0)
-                this.setState({time, timeDisplay: sec.toString() + "s"});
-            else
-                this.setState({time, timeDisplay: min.toString() + "m" + sec.toString() + "s"});
-        }, 1000);
+    timeDisplay() {
+        let time = Math.floor((this.props.durationMs || 0) / 1000);
+        let min = Math.floor(time / 60);
+        let sec = time % 60;
+        if(min === 0)
+            return sec.toString() + "s";
+        return min.toString() + "m" + sec.toString() + "s";
+    }
+    stateDisplay() {
+        if(this.props.state === 'running')
+            return '';
+        if(this.props.state === 'queued')
+            return 'queued #' + this.props.queuePosition + ' ';
+        if(this.props.exitCode !== null && this.props.exitCode !== undefined && this.props.exitCode !== 0)
+            return this.props.state + ' (' + this.props.exitCode + ') ';
+        return this.props.state + ' ';
     }
     click(e) {
         if(this.props.isWatching)
@@ -252,15 +469,21 @@ class Job extends React.Component {
     }
     render() {
         let ICON_EYE = '👁 ';
+        let colors = {succeeded: '#8bc34a', failed: '#f44336', cancelled: '#9e9e9e'};
         return (
-            <p style={{cursor: 'pointer', textDecoration: this.state.isClosing ? 'line-through': ''}} onClick={e => this.click(e)}>
+            <p style={{cursor: 'pointer', textDecoration: this.state.isClosing ? 'line-through': '', paddingLeft: (this.props.depth || 0) * 16}} onClick={e => this.click(e)} title={this.props.error || ''}>
                 <span onClick={() => {
                     this.props.willClose();
                     this.setState({isClosing: true})
                 }}>(×) </span>
-                <span style={{fontSize: 9}}>{this.state.timeDisplay} {this.props.isWatching ? ICON_EYE : ''}</span>
+                <span style={{fontSize: 9}}>{this.timeDisplay()} {this.props.isWatching ? ICON_EYE : ''}</span>
                 &nbsp;&nbsp;
-                <span>{this.props.command}</span>
+                <span style={{fontSize: 9, color: colors[this.props.state] || 'white'}}>{this.stateDisplay()}</span>
+                <span>{this.props.command}{this.props.param ? ':' + this.props.param : ''}</span>
+                {this.props.schedule ? <span style={{fontSize: 9}} title={'scheduled by ' + this.props.schedule}> ⏰</span> : null}
+                {this.props.parentId && !this.props.depth ? <span style={{fontSize: 9}}> (node of #{this.props.parentId})</span> : null}
+                <a href={'download-log?process_id=' + this.props.processId} title="download log"
+                   onClick={e => e.stopPropagation()} style={{color: 'inherit', textDecoration: 'none'}}> ⇩</a>
             </p>
         )
     }
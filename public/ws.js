@@ -1,50 +1,53 @@
-// WebSocket objects - created when window is loaded.
-var sockEcho = null;
-var sockTime = null;
-
-// Websocket server address.
-var wsServerAddress = "ws://127.0.0.1:4050";
-
-window.onload = function() {
-    // Connect the WebSocket to the server and register callbacks on it.
-    sockEcho = new WebSocket(wsServerAddress + "/wsecho");
-
-    sockEcho.onopen = function() {
-        console.log("connected");
+// LogSocket keeps a websocket to /ws/log open, asking for the output rendered as html, after a reconnect it subscribes again
+// with the last received offset of every process so no output is lost or duplicated
+class LogSocket {
+    constructor(onFrame) {
+        this.onFrame = onFrame;
+        this.processIds = [];
+        this.offsets = {};
+        this.socket = null;
+        this.connect();
     }
 
-    sockEcho.onclose = function(e) {
-        console.log("connection closed (" + e.code + ")");
+    connect() {
+        let protocol = window.location.protocol === 'https:' ? 'wss://' : 'ws://';
+        this.socket = new WebSocket(protocol + window.location.host + '/ws/log?format=html');
+        this.socket.onopen = () => this.subscribe(true);
+        this.socket.onmessage = e => {
+            let frame = JSON.parse(e.data);
+            if(frame.type === 'log' || frame.type === 'skipped')
+                this.offsets[frame.process_id] = frame.next_offset;
+            this.onFrame(frame);
+        };
+        this.socket.onclose = e => {
+            console.log("log socket closed (" + e.code + "), reconnecting...");
+            setTimeout(() => this.connect(), 1000);
+        };
     }
 
-    sockEcho.onmessage = function(e) {
-        var msg = JSON.parse(e.data);
-        var coordMsg = "Coordinates: (" + msg.x + "," + msg.y + ")";
-        document.getElementById("output").innerHTML = coordMsg;
+    send(message) {
+        if(this.socket !== null && this.socket.readyState === WebSocket.OPEN)
+            this.socket.send(JSON.stringify(message));
     }
 
-    // This is a pure push notification from the server: register onmessage
-    // to update the time when the server sends new timestamps.
-    sockTime = new WebSocket(wsServerAddress + "/wstime");
-    sockTime.onmessage = function(e) {
-        document.getElementById("timeticker").innerHTML = e.data;
+    subscribe(resume) {
+        let offsets = {};
+        if(resume) {
+            for(let id in this.offsets)
+                if(this.processIds.length === 0 || this.processIds.indexOf(parseInt(id)) >= 0)
+                    offsets[id] = this.offsets[id];
+        }
+        if(this.processIds.length === 0)
+            this.send({action: 'subscribe', all: true, offsets});
+        else
+            this.send({action: 'subscribe', process_ids: this.processIds, offsets});
     }
-};
 
-// Send the msg object, encoded with JSON, on the websocket if it's open.
-function socketSend(msg) {
-    if (sockEcho != null && sockEcho.readyState == WebSocket.OPEN) {
-        sockEcho.send(JSON.stringify(msg));
-    } else {
-        console.log("Socket isn't OPEN");
+    // watch replaces the watched processes, an empty list means every process
+    watch(processIds) {
+        this.send({action: 'unsubscribe', all: true, process_ids: this.processIds});
+        this.processIds = processIds.slice();
+        this.offsets = {};
+        this.subscribe(false);
     }
 }
-
-function onMouseMoved(e) {
-    // When a "mouse moved" event is invoked, send it on the socket.
-    socketSend({x: e.clientX, y: e.clientY});
-}
-
-function onMouseOut() {
-    document.getElementById("output").innerHTML = "";
-}
@@ -6,7 +6,9 @@ import (
 	"fmt"
 	"handler"
 	"net/http"
+	"repository"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -21,46 +23,89 @@ func main() {
 	common.PanicOnError(err)
 	fuzzySearch, err := handler.GetFuzzySearch(commandCenter)
 	common.PanicOnError(err)
-	logWatcherChannels := map[int]chan handler.LogItem{}
-	runningProcceses := map[int]string{}
-	finishedProcesses := map[int]string{}
-	forceStopChannels := map[int]chan bool{}
-	processAutoIncrementId := 0
-	logWatcherAutoIncrementId := 0
-	storedLogs := map[int]string{}
+	maxStoredLogCharacters, err := config.GetIntByKey("maximum stdout characters to be stored for a process")
 	handler.PanicOnError(err)
-	reloadFn := func() {
-		fmt.Println("reloading...")
+	logDirectory, err := config.GetStringByKey("log directory")
+	handler.PanicOnError(err)
+	logRetentionDays, err := config.GetIntByKey("log retention in days")
+	handler.PanicOnError(err)
+	maxLogDirectorySize, err := config.GetIntByKey("maximum total size of log directory in MB")
+	handler.PanicOnError(err)
+	logStore, err := core.NewLogStore(logDirectory, time.Hour*24*time.Duration(logRetentionDays), int64(maxLogDirectorySize)*1024*1024)
+	handler.PanicOnError(err)
+	processRegistry := core.NewProcessRegistry(maxStoredLogCharacters, logStore)
+	historyFile, err := config.GetStringByKey("run history file")
+	handler.PanicOnError(err)
+	runHistory, err := repository.NewRunHistory(historyFile)
+	handler.PanicOnError(err)
+	err = runHistory.ImportLegacy("history.txt")
+	handler.PanicOnError(err)
+	core.RecordRunHistory(processRegistry, runHistory)
+	scheduler := core.NewScheduler(processRegistry, commandCenter, config)
+	schedule := core.NewSchedule(scheduler)
+	if err := schedule.Reload(); err != nil {
+		fmt.Println(err)
+	}
+	schedule.Start()
+	notifier := core.NewNotifier(processRegistry, commandCenter, config)
+	if err := notifier.Reload("config/notification.yml"); err != nil {
+		fmt.Println(err)
+	}
+	notifier.Start()
+	triggers := core.NewTriggers(config)
+	if err := triggers.Reload(); err != nil {
+		fmt.Println(err)
+	}
+	if gracePeriod, err := config.GetIntByKey("grace period in seconds before killing a stopped process"); err == nil {
+		common.StopGracePeriod = time.Second * time.Duration(gracePeriod)
+	}
+	// reload reads every config file again, the file watcher, the interval below and the api may run it at the same time
+	var reloadMutex sync.Mutex
+	reload := func() error {
+		reloadMutex.Lock()
+		defer reloadMutex.Unlock()
 		newConfig, err := core.GetConfig()
 		if err != nil {
-			fmt.Println(err)
-			return
+			return err
 		}
-		*config = *newConfig
+		// the shared objects take the new values under their own locks, requests and runs keep reading them meanwhile
+		config.Replace(newConfig)
 		newCurl, err := handler.GetYamlCurl(config)
 		if err != nil {
-			fmt.Println(err)
-			return
+			return err
 		}
-		*curl = *newCurl
+		curl.Replace(newCurl)
 		newIntegrationTest, err := handler.GetIntegrationTest(config, curl)
 		if err != nil {
-			fmt.Println(err)
-			return
+			return err
 		}
-		*integrationTest = *newIntegrationTest
-		newCommandCenter, err := handler.GetCommandCenter(config, curl, integrationTest)
-		if err != nil {
-			fmt.Println(err)
-			return
+		integrationTest.Replace(newIntegrationTest)
+		if err := commandCenter.Reload(); err != nil {
+			return err
 		}
-		*commandCenter = *newCommandCenter
 		newFuzzySearch, err := handler.GetFuzzySearch(commandCenter)
 		if err != nil {
+			return err
+		}
+		fuzzySearch.Replace(newFuzzySearch)
+		if err := schedule.Reload(); err != nil {
+			return err
+		}
+		if err := notifier.Reload("config/notification.yml"); err != nil {
+			return err
+		}
+		if err := triggers.Reload(); err != nil {
+			return err
+		}
+		processRegistry.PublishReload()
+		return nil
+	}
+	reloadFn := func() {
+		fmt.Println("reloading...")
+		if err := reload(); err != nil {
 			fmt.Println(err)
 			return
 		}
-		*fuzzySearch = *newFuzzySearch
 		fmt.Println("reloaded successfully !!!")
 	}
 	handler.StartWatcher(reloadFn)
@@ -74,16 +119,37 @@ func main() {
 			reloadFn()
 		}
 	}()
+	// clean up old log files continuously
+	go func() {
+		for {
+			if err := logStore.Clean(); err != nil {
+				fmt.Println(err)
+			}
+			time.Sleep(time.Hour)
+		}
+	}()
 	http.HandleFunc("/public/", func(w http.ResponseWriter, r *http.Request) {
 		http.ServeFile(w, r, strings.TrimLeft(r.RequestURI, "/"))
 	})
 	http.HandleFunc("/search", handler.Search(fuzzySearch))
-	http.HandleFunc("/run", handler.RunCommand(&processAutoIncrementId, commandCenter, &finishedProcesses, &logWatcherChannels, &runningProcceses, &storedLogs, config, &forceStopChannels))
-	http.HandleFunc("/close-process", handler.CloseProcess(&runningProcceses, &finishedProcesses, &storedLogs, &forceStopChannels))
-	http.HandleFunc("/log", handler.Log(&runningProcceses, &finishedProcesses, &storedLogs, &logWatcherAutoIncrementId, &logWatcherChannels))
-	http.HandleFunc("/status", handler.Status(&runningProcceses, &finishedProcesses))
+	http.HandleFunc("/run", handler.RunCommand(scheduler))
+	http.HandleFunc("/describe", handler.Describe(commandCenter))
+	http.HandleFunc("/close-process", handler.CloseProcess(scheduler))
+	http.HandleFunc("/stdin", handler.Stdin(processRegistry))
+	http.HandleFunc("/log", handler.Log(processRegistry))
+	http.HandleFunc("/ws/log", handler.LogSocket(processRegistry))
+	http.HandleFunc("/events", handler.Events(processRegistry))
+	http.HandleFunc("/download-log", handler.DownloadLog(processRegistry, logStore))
+	http.HandleFunc("/status", handler.Status(processRegistry))
 	http.HandleFunc("/p/", handler.Extension(config))
-	http.HandleFunc("/", handler.All)
+	http.HandleFunc("/history", handler.History(runHistory))
+	http.HandleFunc("/history/stats", handler.HistoryStats(runHistory))
+	http.HandleFunc("/schedule", handler.Schedule(schedule))
+	http.HandleFunc("/schedule/enable", handler.EnableSchedule(schedule, true))
+	http.HandleFunc("/schedule/disable", handler.EnableSchedule(schedule, false))
+	http.HandleFunc("/trigger/", handler.Trigger(triggers, commandCenter, scheduler, processRegistry))
+	http.HandleFunc("/api/v1/", handler.Api(commandCenter, scheduler, processRegistry, reload))
+	http.HandleFunc("/", handler.All(runHistory))
 	err = http.ListenAndServe(":1234", nil)
 	if err != nil {
 		panic(err)
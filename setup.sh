@@ -5,5 +5,7 @@ go get -u github.com/PuerkitoBio/goquery
 go get -u github.com/fsnotify/fsnotify
 go get -u github.com/tealeg/xlsx
 go get -u github.com/yudai/gojsondiff
+go get -u github.com/gorilla/websocket
+go get -u github.com/creack/pty
 touch history.txt
 echo "no history, please search and run some commands" >> history.txt
\ No newline at end of file
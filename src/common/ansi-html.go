@@ -0,0 +1,405 @@
+package common
+
+import (
+	"fmt"
+	"html"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// the 16 basic terminal colours, picked to stay readable on a white background
+var ansiColors = []string{
+	"#000000", "#c91b00", "#00a600", "#a5a000", "#0225c7", "#c930c7", "#00a5b2", "#808080",
+	"#686868", "#ff6e67", "#2fb800", "#c7c400", "#6871ff", "#ff77ff", "#00c5c7", "#e0e0e0",
+}
+
+var urlPattern = regexp.MustCompile("https?://[^\\s<>\"'`\\\\]+")
+
+// an absolute path has at least two parts, it may be followed by :line:column as compilers print it
+var filePathPattern = regexp.MustCompile(`(?:^|[\s'"(=])(/[\w.@+-]+(?:/[\w.@+-]+)+)(?::\d+)*`)
+
+// AnsiHtmlRenderer turns terminal output into html: SGR escape codes become styled spans, a line rewritten
+// with carriage returns or backspaces only shows its final state, urls and absolute file paths become links.
+// It keeps the state of one output between calls, e.g. a colour set in one chunk and reset in the next
+type AnsiHtmlRenderer struct {
+	style ansiStyle
+	// pending is an escape sequence or a character cut at the end of the last chunk
+	pending string
+	line    []ansiCell
+	cursor  int
+	// emitted is how many cells of the line have been rendered already
+	emitted int
+	// a line being rewritten is held until it ends, overwritten tells that a part already rendered has changed
+	rewriting   bool
+	overwritten bool
+}
+
+type ansiStyle struct {
+	bold       bool
+	dim        bool
+	italic     bool
+	underline  bool
+	inverse    bool
+	color      string
+	background string
+}
+
+type ansiCell struct {
+	char  rune
+	style ansiStyle
+}
+
+func NewAnsiHtmlRenderer() *AnsiHtmlRenderer {
+	return &AnsiHtmlRenderer{}
+}
+
+// Render returns the html for the next chunk of output, a line still being rewritten is left for a later call
+func (this *AnsiHtmlRenderer) Render(text string) string {
+	text = this.pending + text
+	this.pending = ""
+	var output strings.Builder
+	for i := 0; i < len(text); {
+		switch c := text[i]; {
+		case c == 0x1b:
+			length, complete := this.escape(text[i:])
+			if !complete {
+				// a sequence this long is broken rather than cut, its escape character is dropped
+				if len(text)-i > 256 {
+					i++
+					continue
+				}
+				this.pending = text[i:]
+				i = len(text)
+				continue
+			}
+			i += length
+		case c == '\n':
+			output.WriteString(this.endLine())
+			i++
+		case c == '\r':
+			this.cursor = 0
+			this.rewriting = true
+			i++
+		case c == '\b':
+			if this.cursor > 0 {
+				this.cursor--
+			}
+			this.rewriting = true
+			i++
+		case c < 0x20 && c != '\t':
+			i++
+		default:
+			if !utf8.FullRuneInString(text[i:]) {
+				this.pending = text[i:]
+				i = len(text)
+				continue
+			}
+			char, size := utf8.DecodeRuneInString(text[i:])
+			this.put(char)
+			i += size
+		}
+	}
+	if !this.rewriting {
+		output.WriteString(this.emit())
+	}
+	return output.String()
+}
+
+func (this *AnsiHtmlRenderer) put(char rune) {
+	cell := ansiCell{char: char, style: this.style}
+	if this.cursor < len(this.line) {
+		if this.cursor < this.emitted {
+			this.overwritten = true
+		}
+		this.line[this.cursor] = cell
+	} else {
+		this.line = append(this.line, cell)
+	}
+	this.cursor++
+}
+
+// emit renders the cells added since the last call, or the whole line again on a new line when a rendered part has changed
+func (this *AnsiHtmlRenderer) emit() string {
+	output := ""
+	if this.overwritten {
+		output = "\n" + renderAnsiCells(this.line)
+		this.overwritten = false
+	} else if this.emitted < len(this.line) {
+		output = renderAnsiCells(this.line[this.emitted:])
+	}
+	this.emitted = len(this.line)
+	return output
+}
+
+func (this *AnsiHtmlRenderer) endLine() string {
+	output := this.emit() + "\n"
+	this.line = nil
+	this.cursor = 0
+	this.emitted = 0
+	this.rewriting = false
+	return output
+}
+
+// escape handles the escape sequence at the start of s and returns its length,
+// only colours and erasing the line matter here, everything else is dropped
+func (this *AnsiHtmlRenderer) escape(s string) (int, bool) {
+	if len(s) < 2 {
+		return 0, false
+	}
+	switch {
+	case s[1] == '[':
+		for j := 2; j < len(s); j++ {
+			if s[j] >= 0x40 && s[j] <= 0x7e {
+				switch s[j] {
+				case 'm':
+					this.applySgr(s[2:j])
+				case 'K':
+					this.eraseLine(s[2:j])
+				}
+				return j + 1, true
+			}
+		}
+		return 0, false
+	case s[1] == ']':
+		// an operating system command, e.g. a window title, ends with BEL or ESC \
+		for j := 2; j < len(s); j++ {
+			if s[j] == 0x07 {
+				return j + 1, true
+			}
+			if s[j] == 0x1b && j+1 < len(s) && s[j+1] == '\\' {
+				return j + 2, true
+			}
+		}
+		return 0, false
+	case s[1] >= 0x20 && s[1] <= 0x2f:
+		// e.g. ESC ( B choosing a character set
+		if len(s) < 3 {
+			return 0, false
+		}
+		return 3, true
+	}
+	return 2, true
+}
+
+// eraseLine handles ESC [ K, which erases the line from the cursor (0), up to the cursor (1) or entirely (2)
+func (this *AnsiHtmlRenderer) eraseLine(params string) {
+	from := this.cursor
+	switch params {
+	case "1", "2":
+		from = 0
+	}
+	if from >= len(this.line) {
+		return
+	}
+	if params == "1" && this.cursor+1 < len(this.line) {
+		for k := 0; k <= this.cursor; k++ {
+			this.line[k] = ansiCell{char: ' '}
+		}
+	} else {
+		this.line = this.line[:from]
+		for len(this.line) < this.cursor {
+			this.line = append(this.line, ansiCell{char: ' '})
+		}
+	}
+	if from < this.emitted {
+		this.overwritten = true
+		this.rewriting = true
+		if this.emitted > len(this.line) {
+			this.emitted = len(this.line)
+		}
+	}
+}
+
+func (this *AnsiHtmlRenderer) applySgr(params string) {
+	codes := strings.Split(strings.Replace(params, ":", ";", -1), ";")
+	for k := 0; k < len(codes); k++ {
+		code, err := strconv.Atoi(codes[k])
+		if err != nil {
+			code = 0
+		}
+		switch {
+		case code == 0:
+			this.style = ansiStyle{}
+		case code == 1:
+			this.style.bold = true
+		case code == 2:
+			this.style.dim = true
+		case code == 3:
+			this.style.italic = true
+		case code == 4:
+			this.style.underline = true
+		case code == 7:
+			this.style.inverse = true
+		case code == 22:
+			this.style.bold = false
+			this.style.dim = false
+		case code == 23:
+			this.style.italic = false
+		case code == 24:
+			this.style.underline = false
+		case code == 27:
+			this.style.inverse = false
+		case code >= 30 && code <= 37:
+			this.style.color = ansiColors[code-30]
+		case code == 38:
+			this.style.color, k = extendedAnsiColor(codes, k)
+		case code == 39:
+			this.style.color = ""
+		case code >= 40 && code <= 47:
+			this.style.background = ansiColors[code-40]
+		case code == 48:
+			this.style.background, k = extendedAnsiColor(codes, k)
+		case code == 49:
+			this.style.background = ""
+		case code >= 90 && code <= 97:
+			this.style.color = ansiColors[code-90+8]
+		case code >= 100 && code <= 107:
+			this.style.background = ansiColors[code-100+8]
+		}
+	}
+}
+
+// extendedAnsiColor reads "5;n" or "2;r;g;b" after the 38 or 48 at codes[k], it returns the index of the last code used
+func extendedAnsiColor(codes []string, k int) (string, int) {
+	number := func(index int) int {
+		if index >= len(codes) {
+			return 0
+		}
+		n, _ := strconv.Atoi(codes[index])
+		if n < 0 || n > 255 {
+			return 0
+		}
+		return n
+	}
+	switch {
+	case k+2 < len(codes) && codes[k+1] == "5":
+		return ansi256Color(number(k + 2)), k + 2
+	case k+4 < len(codes) && codes[k+1] == "2":
+		return fmt.Sprintf("#%02x%02x%02x", number(k+2), number(k+3), number(k+4)), k + 4
+	}
+	return "", len(codes)
+}
+
+func ansi256Color(n int) string {
+	if n < 16 {
+		return ansiColors[n]
+	}
+	if n >= 232 {
+		gray := 8 + 10*(n-232)
+		return fmt.Sprintf("#%02x%02x%02x", gray, gray, gray)
+	}
+	levels := []int{0, 95, 135, 175, 215, 255}
+	n -= 16
+	return fmt.Sprintf("#%02x%02x%02x", levels[n/36], levels[n/6%6], levels[n%6])
+}
+
+func (this ansiStyle) css() string {
+	color, background := this.color, this.background
+	if this.inverse {
+		color, background = background, color
+		if color == "" {
+			color = "white"
+		}
+		if background == "" {
+			background = "black"
+		}
+	}
+	var rules []string
+	if color != "" {
+		rules = append(rules, "color:"+color)
+	}
+	if background != "" {
+		rules = append(rules, "background-color:"+background)
+	}
+	if this.bold {
+		rules = append(rules, "font-weight:bold")
+	}
+	if this.dim {
+		rules = append(rules, "opacity:0.7")
+	}
+	if this.italic {
+		rules = append(rules, "font-style:italic")
+	}
+	if this.underline {
+		rules = append(rules, "text-decoration:underline")
+	}
+	return strings.Join(rules, ";")
+}
+
+// renderAnsiCells renders cells as escaped text, one span per run of the same style and one link per url or file path
+func renderAnsiCells(cells []ansiCell) string {
+	var text strings.Builder
+	// cellAt maps a byte position of text to the cell it belongs to
+	cellAt := make([]int, 0, len(cells))
+	for k, cell := range cells {
+		size := utf8.RuneLen(cell.char)
+		if size < 0 {
+			size = 1
+			cell.char = utf8.RuneError
+		}
+		for n := 0; n < size; n++ {
+			cellAt = append(cellAt, k)
+		}
+		text.WriteRune(cell.char)
+	}
+	cellAt = append(cellAt, len(cells))
+
+	links := make([]int, len(cells))
+	for k := range links {
+		links[k] = -1
+	}
+	var hrefs []string
+	mark := func(start int, end int, href string) {
+		for k := cellAt[start]; k < cellAt[end]; k++ {
+			if links[k] >= 0 {
+				return
+			}
+		}
+		for k := cellAt[start]; k < cellAt[end]; k++ {
+			links[k] = len(hrefs)
+		}
+		hrefs = append(hrefs, href)
+	}
+	plain := text.String()
+	for _, match := range urlPattern.FindAllStringIndex(plain, -1) {
+		url := strings.TrimRight(plain[match[0]:match[1]], ".,;:!?)]}")
+		mark(match[0], match[0]+len(url), url)
+	}
+	for _, match := range filePathPattern.FindAllStringSubmatchIndex(plain, -1) {
+		mark(match[2], match[1], "file://"+plain[match[2]:match[3]])
+	}
+
+	var output strings.Builder
+	current := -1
+	for start := 0; start < len(cells); {
+		end := start + 1
+		for end < len(cells) && cells[end].style == cells[start].style && links[end] == links[start] {
+			end++
+		}
+		if links[start] != current {
+			if current >= 0 {
+				output.WriteString("</a>")
+			}
+			if links[start] >= 0 {
+				output.WriteString(`<a href="` + html.EscapeString(hrefs[links[start]]) + `" target="_blank">`)
+			}
+			current = links[start]
+		}
+		var run strings.Builder
+		for _, cell := range cells[start:end] {
+			run.WriteRune(cell.char)
+		}
+		if css := cells[start].style.css(); css != "" {
+			output.WriteString(`<span style="` + css + `">` + html.EscapeString(run.String()) + "</span>")
+		} else {
+			output.WriteString(html.EscapeString(run.String()))
+		}
+		start = end
+	}
+	if current >= 0 {
+		output.WriteString("</a>")
+	}
+	return output.String()
+}
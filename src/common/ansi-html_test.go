@@ -0,0 +1,135 @@
+package common
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestAnsiHtmlRenderer(t *testing.T) {
+	tests := []struct {
+		name   string
+		chunks []string
+		want   string
+	}{
+		{
+			name:   "plain text is escaped",
+			chunks: []string{"a <b> & \"c\"\n"},
+			want:   "a &lt;b&gt; &amp; &#34;c&#34;\n",
+		},
+		{
+			name:   "a colour until it is reset",
+			chunks: []string{"\x1b[31mred\x1b[0m plain\n"},
+			want:   `<span style="color:#c91b00">red</span> plain` + "\n",
+		},
+		{
+			name:   "codes of one sequence add up",
+			chunks: []string{"\x1b[1;4;32mok\n"},
+			want:   `<span style="color:#00a600;font-weight:bold;text-decoration:underline">ok</span>` + "\n",
+		},
+		{
+			name:   "codes reset one attribute each",
+			chunks: []string{"\x1b[1;3;33ma\x1b[22mb\x1b[23mc\x1b[39md\n"},
+			want: `<span style="color:#a5a000;font-weight:bold;font-style:italic">a</span>` +
+				`<span style="color:#a5a000;font-style:italic">b</span>` +
+				`<span style="color:#a5a000">c</span>d` + "\n",
+		},
+		{
+			name:   "bright, 256 and true colours",
+			chunks: []string{"\x1b[91ma\x1b[38;5;196mb\x1b[38;5;244mc\x1b[0;48;2;1;2;3md\x1b[0m\n"},
+			want: `<span style="color:#ff6e67">a</span><span style="color:#ff0000">b</span>` +
+				`<span style="color:#808080">c</span><span style="background-color:#010203">d</span>` + "\n",
+		},
+		{
+			name:   "inverse swaps the colours",
+			chunks: []string{"\x1b[7mx\x1b[27;34;47my\n"},
+			want:   `<span style="color:white;background-color:black">x</span><span style="color:#0225c7;background-color:#808080">y</span>` + "\n",
+		},
+		{
+			name:   "the style goes on in the next chunk",
+			chunks: []string{"\x1b[34mblue", " still\x1b[0m done\n"},
+			want:   `<span style="color:#0225c7">blue</span><span style="color:#0225c7"> still</span> done` + "\n",
+		},
+		{
+			name:   "an escape sequence cut between chunks",
+			chunks: []string{"a\x1b[3", "1mb\n"},
+			want:   `a<span style="color:#c91b00">b</span>` + "\n",
+		},
+		{
+			name:   "a character cut between chunks",
+			chunks: []string{"caf\xc3", "\xa9\n"},
+			want:   "café\n",
+		},
+		{
+			name:   "other sequences and titles are dropped",
+			chunks: []string{"\x1b]0;title\x07\x1b(B\x1b[2Jtext\x1b[?25l\n"},
+			want:   "text\n",
+		},
+		{
+			name:   "a progress line only shows its last state",
+			chunks: []string{"10%\r50%\r100%\n"},
+			want:   "100%\n",
+		},
+		{
+			name:   "a rewrite keeps the end of a longer line",
+			chunks: []string{"abcdef\rxy\n"},
+			want:   "xycdef\n",
+		},
+		{
+			name:   "a carriage return before a line feed",
+			chunks: []string{"one\r\ntwo\r\n"},
+			want:   "one\ntwo\n",
+		},
+		{
+			name:   "erasing the line after a carriage return",
+			chunks: []string{"abcdef\r\x1b[Kxy\n"},
+			want:   "xy\n",
+		},
+		{
+			name:   "backspaces overwrite",
+			chunks: []string{"ab\bc\n"},
+			want:   "ac\n",
+		},
+		{
+			name:   "a line rewritten after it has been shown is shown again whole",
+			chunks: []string{"10%", "\r50%", "\r100%\n"},
+			want:   "10%\n100%\n",
+		},
+		{
+			name:   "a line being rewritten waits for its end",
+			chunks: []string{"10%\r", "20%", "\n"},
+			want:   "20%\n",
+		},
+		{
+			name:   "urls become links without the punctuation after them",
+			chunks: []string{"see https://example.com/a?b=1&c=2.\n"},
+			want:   `see <a href="https://example.com/a?b=1&amp;c=2" target="_blank">https://example.com/a?b=1&amp;c=2</a>.` + "\n",
+		},
+		{
+			name:   "file paths become links with their line and column",
+			chunks: []string{"error in /src/main.go:12:3: oops\n"},
+			want:   `error in <a href="file:///src/main.go" target="_blank">/src/main.go:12:3</a>: oops` + "\n",
+		},
+		{
+			name:   "a link keeps its colours",
+			chunks: []string{"\x1b[32mhttp://a.io\x1b[0m/x\n"},
+			want:   `<a href="http://a.io/x" target="_blank"><span style="color:#00a600">http://a.io</span>/x</a>` + "\n",
+		},
+		{
+			name:   "a single word is not a path",
+			chunks: []string{"/tmp and a/b/c\n"},
+			want:   "/tmp and a/b/c\n",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			renderer := NewAnsiHtmlRenderer()
+			var got strings.Builder
+			for _, chunk := range test.chunks {
+				got.WriteString(renderer.Render(chunk))
+			}
+			if got.String() != test.want {
+				t.Errorf("got\n%q\nwant\n%q", got.String(), test.want)
+			}
+		})
+	}
+}
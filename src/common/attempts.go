@@ -0,0 +1,50 @@
+package common
+
+import (
+	"sync"
+	"time"
+)
+
+// Attempt is one try of something run with a retry policy, e.g. a formula step, Error is empty when it succeeded
+type Attempt struct {
+	What       string    `json:"what"`
+	Number     int       `json:"number"`
+	Of         int       `json:"of"`
+	Error      string    `json:"error,omitempty"`
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+}
+
+// Attempts are the attempts made by the retry policies of a run,
+// every session derived from the session of the run shares them
+type Attempts struct {
+	mutex sync.Mutex
+	list  []Attempt
+}
+
+func NewAttempts() *Attempts {
+	return &Attempts{}
+}
+
+// Add keeps an attempt, a nil list keeps nothing
+func (this *Attempts) Add(attempt Attempt) {
+	if this == nil {
+		return
+	}
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	this.list = append(this.list, attempt)
+}
+
+// All returns a copy of the attempts, nil when there are none
+func (this *Attempts) All() []Attempt {
+	if this == nil {
+		return nil
+	}
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	if len(this.list) == 0 {
+		return nil
+	}
+	return append([]Attempt{}, this.list...)
+}
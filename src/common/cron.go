@@ -0,0 +1,174 @@
+package common
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var cronMacros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+var cronMonthNames = []string{"jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}
+
+var cronWeekdayNames = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+
+// CronExpression is a standard 5 field cron expression: minute, hour, day of month, month and day of week.
+// Fields accept *, lists, ranges and steps like "*/15" or "1-5", months and weekdays accept names like "jan" or "mon",
+// 7 is sunday as well as 0. When both days are restricted a time matches either of them, as in cron, a day field
+// starting with * like "*/2" does not restrict and the time has to match both
+type CronExpression struct {
+	text        string
+	minutes     uint64
+	hours       uint64
+	days        uint64
+	months      uint64
+	weekdays    uint64
+	dayStar     bool
+	weekdayStar bool
+}
+
+// ParseCron reads a cron expression, the macros @hourly, @daily, @weekly, @monthly and @yearly are accepted too
+func ParseCron(text string) (*CronExpression, error) {
+	expanded := strings.TrimSpace(text)
+	if macro, ok := cronMacros[strings.ToLower(expanded)]; ok {
+		expanded = macro
+	}
+	fields := strings.Fields(expanded)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("cron expression %s should have 5 fields: minute hour day month weekday", strconv.Quote(text))
+	}
+	expression := &CronExpression{text: text}
+	var err error
+	if expression.minutes, err = parseCronField(fields[0], 0, 59, nil); err != nil {
+		return nil, fmt.Errorf("minute of %s: %w", strconv.Quote(text), err)
+	}
+	if expression.hours, err = parseCronField(fields[1], 0, 23, nil); err != nil {
+		return nil, fmt.Errorf("hour of %s: %w", strconv.Quote(text), err)
+	}
+	if expression.days, err = parseCronField(fields[2], 1, 31, nil); err != nil {
+		return nil, fmt.Errorf("day of %s: %w", strconv.Quote(text), err)
+	}
+	if expression.months, err = parseCronField(fields[3], 1, 12, cronMonthNames); err != nil {
+		return nil, fmt.Errorf("month of %s: %w", strconv.Quote(text), err)
+	}
+	if expression.weekdays, err = parseCronField(fields[4], 0, 7, cronWeekdayNames); err != nil {
+		return nil, fmt.Errorf("weekday of %s: %w", strconv.Quote(text), err)
+	}
+	if expression.weekdays&(1<<7) != 0 {
+		expression.weekdays |= 1
+	}
+	expression.dayStar = strings.HasPrefix(fields[2], "*") || fields[2] == "?"
+	expression.weekdayStar = strings.HasPrefix(fields[4], "*") || fields[4] == "?"
+	return expression, nil
+}
+
+// parseCronField returns the allowed values of a field as bits, names are the values from min on
+func parseCronField(field string, min int, max int, names []string) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		step, stepped := 1, false
+		if k := strings.Index(part, "/"); k >= 0 {
+			stepped = true
+			var err error
+			step, err = strconv.Atoi(part[k+1:])
+			if err != nil || step <= 0 {
+				return 0, fmt.Errorf("invalid step in %s", part)
+			}
+			part = part[:k]
+		}
+		from, to := min, max
+		switch {
+		case part == "*" || part == "?":
+		case strings.Contains(part, "-"):
+			k := strings.Index(part, "-")
+			var err error
+			if from, err = parseCronValue(part[:k], min, max, names); err != nil {
+				return 0, err
+			}
+			if to, err = parseCronValue(part[k+1:], min, max, names); err != nil {
+				return 0, err
+			}
+			if from > to {
+				return 0, fmt.Errorf("range %s goes backwards", part)
+			}
+		default:
+			value, err := parseCronValue(part, min, max, names)
+			if err != nil {
+				return 0, err
+			}
+			// "5/10" means every 10 from 5 on
+			from = value
+			if !stepped {
+				to = value
+			}
+		}
+		for value := from; value <= to; value += step {
+			bits |= 1 << uint(value)
+		}
+	}
+	return bits, nil
+}
+
+func parseCronValue(text string, min int, max int, names []string) (int, error) {
+	for k, name := range names {
+		if strings.EqualFold(text, name) {
+			return min + k, nil
+		}
+	}
+	value, err := strconv.Atoi(text)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value %s", text)
+	}
+	if value < min || value > max {
+		return 0, fmt.Errorf("value %d is not between %d and %d", value, min, max)
+	}
+	return value, nil
+}
+
+func (this *CronExpression) String() string {
+	return this.text
+}
+
+func (this *CronExpression) matchesDay(t time.Time) bool {
+	day := this.days&(1<<uint(t.Day())) != 0
+	weekday := this.weekdays&(1<<uint(t.Weekday())) != 0
+	if this.dayStar || this.weekdayStar {
+		return day && weekday
+	}
+	return day || weekday
+}
+
+// Next returns the first matching minute strictly after after, the zero time when there is none within 5 years
+func (this *CronExpression) Next(after time.Time) time.Time {
+	t := after.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		if this.months&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !this.matchesDay(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if this.hours&(1<<uint(t.Hour())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			continue
+		}
+		if this.minutes&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
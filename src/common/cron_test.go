@@ -0,0 +1,120 @@
+package common
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCronFields(t *testing.T) {
+	tests := []struct {
+		field string
+		min   int
+		max   int
+		names []string
+		want  []int
+	}{
+		{field: "*", min: 0, max: 5, want: []int{0, 1, 2, 3, 4, 5}},
+		{field: "?", min: 1, max: 3, want: []int{1, 2, 3}},
+		{field: "3", min: 0, max: 59, want: []int{3}},
+		{field: "1,3,5", min: 0, max: 59, want: []int{1, 3, 5}},
+		{field: "2-4", min: 0, max: 59, want: []int{2, 3, 4}},
+		{field: "*/15", min: 0, max: 59, want: []int{0, 15, 30, 45}},
+		{field: "*/2", min: 1, max: 7, want: []int{1, 3, 5, 7}},
+		{field: "1-10/3", min: 0, max: 59, want: []int{1, 4, 7, 10}},
+		{field: "50/4", min: 0, max: 59, want: []int{50, 54, 58}},
+		{field: "0-1,58-59", min: 0, max: 59, want: []int{0, 1, 58, 59}},
+		{field: "mon-wed,FRI", min: 0, max: 7, names: cronWeekdayNames, want: []int{1, 2, 3, 5}},
+		{field: "nov-dec", min: 1, max: 12, names: cronMonthNames, want: []int{11, 12}},
+	}
+	for _, test := range tests {
+		bits, err := parseCronField(test.field, test.min, test.max, test.names)
+		if err != nil {
+			t.Errorf("%s: %s", test.field, err)
+			continue
+		}
+		var want uint64
+		for _, value := range test.want {
+			want |= 1 << uint(value)
+		}
+		if bits != want {
+			t.Errorf("%s gives %b, want %b", test.field, bits, want)
+		}
+	}
+}
+
+func TestParseCronErrors(t *testing.T) {
+	for _, text := range []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * 32 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"5-1 * * * *",
+		"*/0 * * * *",
+		"*/x * * * *",
+		"a * * * *",
+		"* * * smarch *",
+		"1- * * * *",
+		"@sometimes",
+	} {
+		if _, err := ParseCron(text); err == nil {
+			t.Errorf("%q has been accepted", text)
+		}
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	// 2024-03-09 is a saturday
+	at := func(text string) time.Time {
+		value, err := time.Parse("2006-01-02 15:04:05", text)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return value
+	}
+	tests := []struct {
+		name       string
+		expression string
+		after      string
+		want       string
+	}{
+		{name: "every quarter of an hour", expression: "*/15 * * * *", after: "2024-03-09 10:07:30", want: "2024-03-09 10:15:00"},
+		{name: "strictly after", expression: "30 10 * * *", after: "2024-03-09 10:30:00", want: "2024-03-10 10:30:00"},
+		{name: "seconds are dropped", expression: "30 10 * * *", after: "2024-03-09 10:29:59", want: "2024-03-09 10:30:00"},
+		{name: "every day at midnight", expression: "@daily", after: "2024-03-09 10:00:00", want: "2024-03-10 00:00:00"},
+		{name: "hour ranges and steps", expression: "0 8-18/5 * * *", after: "2024-03-09 13:01:00", want: "2024-03-09 18:00:00"},
+		{name: "working days skip the weekend", expression: "0 9 * * mon-fri", after: "2024-03-09 08:00:00", want: "2024-03-11 09:00:00"},
+		{name: "7 is sunday", expression: "0 12 * * 7", after: "2024-03-09 12:00:00", want: "2024-03-10 12:00:00"},
+		{name: "weekly is sunday at midnight", expression: "@weekly", after: "2024-03-09 12:00:00", want: "2024-03-10 00:00:00"},
+		{name: "months without the day are skipped", expression: "0 0 31 * *", after: "2024-03-31 01:00:00", want: "2024-05-31 00:00:00"},
+		{name: "leap days", expression: "0 0 29 feb *", after: "2024-03-01 00:00:00", want: "2028-02-29 00:00:00"},
+		{name: "month names and year end", expression: "0 0 1 jan *", after: "2024-03-09 00:00:00", want: "2025-01-01 00:00:00"},
+		{name: "both days restricted match either, the 13th first", expression: "0 0 13 * fri", after: "2024-03-09 00:00:00", want: "2024-03-13 00:00:00"},
+		{name: "both days restricted match either, a friday first", expression: "0 0 13 * fri", after: "2024-03-13 00:00:00", want: "2024-03-15 00:00:00"},
+		{name: "a day starting with * has to match the weekday too", expression: "0 0 */2 * mon", after: "2024-03-11 01:00:00", want: "2024-03-25 00:00:00"},
+		{name: "a weekday starting with * has to match the day too", expression: "0 0 10 * */3", after: "2024-03-10 00:00:00", want: "2024-04-10 00:00:00"},
+		{name: "a day that never comes", expression: "0 0 30 feb *", after: "2024-03-09 00:00:00", want: ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			expression, err := ParseCron(test.expression)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := expression.Next(at(test.after))
+			if test.want == "" {
+				if !got.IsZero() {
+					t.Errorf("got %s, want none", got)
+				}
+				return
+			}
+			if !got.Equal(at(test.want)) {
+				t.Errorf("got %s, want %s", got.Format("2006-01-02 15:04:05 Mon"), test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,41 @@
+package common
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+// ReadEnvFile reads NAME=value lines as written in a .env file, "export " may precede a name,
+// values may be quoted and lines starting with # are comments. It returns NAME=value entries in file order
+func ReadEnvFile(path string) ([]string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var env []string
+	for k, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+		i := strings.Index(line, "=")
+		if i <= 0 {
+			return nil, fmt.Errorf("%s line %d: expected NAME=value", path, k+1)
+		}
+		name := strings.TrimSpace(line[:i])
+		value := strings.TrimSpace(line[i+1:])
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			quote := value[0]
+			value = value[1 : len(value)-1]
+			if quote == '"' {
+				value = strings.NewReplacer(`\n`, "\n", `\"`, `"`, `\\`, `\`).Replace(value)
+			}
+		} else if j := strings.Index(value, " #"); j >= 0 {
+			value = strings.TrimSpace(value[:j])
+		}
+		env = append(env, name+"="+value)
+	}
+	return env, nil
+}
@@ -3,9 +3,12 @@ package common
 import (
 	"sort"
 	"strings"
+	"sync"
 )
 
+// FuzzySearch is shared by the requests, Replace swaps in the lines of a reloaded search while they read them
 type FuzzySearch struct {
+	mutex sync.RWMutex
 	Lines []string
 }
 
@@ -16,7 +19,20 @@ func NewFuzzySearch(input []string) *FuzzySearch {
 	return &FuzzySearch{Lines: input}
 }
 
+// Replace takes the lines of other, a search built again after a reload
+func (this *FuzzySearch) Replace(other *FuzzySearch) {
+	other.mutex.RLock()
+	lines := other.Lines
+	other.mutex.RUnlock()
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	this.Lines = lines
+}
+
 func (this *FuzzySearch) Find(input string, limit int) []string {
+	this.mutex.RLock()
+	lines := this.Lines
+	this.mutex.RUnlock()
 	input = strings.ToLower(input)
 	keywords := strings.Split(input, " ")
 	var res []string
@@ -26,7 +42,7 @@ func (this *FuzzySearch) Find(input string, limit int) []string {
 		}
 	}
 	var output []string
-	for k, command := range this.Lines {
+	for k, command := range lines {
 		pos := 0
 		command = strings.ToLower(command)
 		for _, userKeywordToken := range res {
@@ -37,7 +53,7 @@ func (this *FuzzySearch) Find(input string, limit int) []string {
 			command = command[(pos + len(userKeywordToken)):]
 		}
 		if pos != -1 {
-			output = append(output, this.Lines[k])
+			output = append(output, lines[k])
 			if len(output) >= limit {
 				break
 			}
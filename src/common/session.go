@@ -0,0 +1,377 @@
+package common
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/creack/pty"
+)
+
+var ErrInputClosed = fmt.Errorf("input of the process has been closed")
+
+var paramEnvNamePattern = regexp.MustCompile(`[^A-Za-z0-9_]`)
+
+// Session is handed to a command handler for one run, it lets the outside stop the run (ForceStop),
+// send input to whichever command of the run is currently running and resize its terminal in Pty mode.
+// When Output is set the commands of the run write their stdout and stderr to it as separate streams.
+// Params are the named parameters of the run, commands get them as PARAM_<NAME> environment variables.
+// Env entries (NAME=value) are added to the environment of the server, Dir is where commands run when they do not say.
+// Templates refer to variables as {{var.name}}, Variables are those of the whole run and Vars those of a part of it,
+// e.g. the item of a loop, which hide the ones of the run with the same name.
+// Attempts are those made by the retry policies of the run
+type Session struct {
+	ForceStop chan bool
+	Pty       bool
+	Output    IChunkWriter
+	Params    map[string]string
+	Env       []string
+	Dir       string
+	Variables *Variables
+	Vars      map[string]string
+	Attempts  *Attempts
+	input     *sessionInput
+}
+
+// sessionInput is shared by a session and the sessions derived from it with WithForceStop
+type sessionInput struct {
+	mutex    sync.Mutex
+	pending  []byte
+	closed   bool
+	ready    chan bool
+	terminal *os.File
+	size     *pty.Winsize
+}
+
+func NewSession(forceStop chan bool) *Session {
+	return &Session{
+		ForceStop: forceStop,
+		Variables: NewVariables(),
+		Attempts:  NewAttempts(),
+		input:     &sessionInput{ready: make(chan bool, 1)},
+	}
+}
+
+// WithForceStop returns a session sharing the input of this one but stopped by another channel, e.g. for a step timeout
+func (this *Session) WithForceStop(forceStop chan bool) *Session {
+	if this == nil {
+		return &Session{ForceStop: forceStop}
+	}
+	session := *this
+	session.ForceStop = forceStop
+	return &session
+}
+
+// WithEnv returns a session whose commands get env on top of the current one and run in dir unless it is empty,
+// e.g. for the "env" and "working directory" of a formula step
+func (this *Session) WithEnv(env []string, dir string) *Session {
+	session := &Session{}
+	if this != nil {
+		*session = *this
+	}
+	session.Env = append(append([]string{}, session.Env...), env...)
+	if dir != "" {
+		session.Dir = dir
+	}
+	return session
+}
+
+// WithVar returns a session whose templates see name as value, e.g. for the item of a loop
+func (this *Session) WithVar(name string, value string) *Session {
+	session := &Session{}
+	if this != nil {
+		*session = *this
+	}
+	session.Vars = map[string]string{}
+	if this != nil {
+		for k, v := range this.Vars {
+			session.Vars[k] = v
+		}
+	}
+	session.Vars[name] = value
+	return session
+}
+
+// GetVars returns the variables templates see, those of the run overridden by Vars
+func (this *Session) GetVars() map[string]string {
+	if this == nil {
+		return nil
+	}
+	vars := this.Variables.All()
+	if vars == nil && len(this.Vars) > 0 {
+		vars = map[string]string{}
+	}
+	for k, v := range this.Vars {
+		vars[k] = v
+	}
+	return vars
+}
+
+// WithCapture returns a session whose commands also write what they print on stdout to capture
+func (this *Session) WithCapture(capture IWriter) *Session {
+	session := &Session{}
+	if this != nil {
+		*session = *this
+	}
+	if output := session.Output; output != nil {
+		session.Output = func(chunk Chunk) {
+			output(chunk)
+			if chunk.Stream == StreamStdout || chunk.Stream == StreamOutput {
+				capture(chunk.Text)
+			}
+		}
+	}
+	return session
+}
+
+// WithTranscript returns a session whose commands also write everything they print, stderr included, to transcript
+func (this *Session) WithTranscript(transcript IWriter) *Session {
+	session := &Session{}
+	if this != nil {
+		*session = *this
+	}
+	if output := session.Output; output != nil {
+		session.Output = func(chunk Chunk) {
+			output(chunk)
+			transcript(chunk.Text)
+		}
+	}
+	return session
+}
+
+// ForCapture returns a session whose commands write to the writer they are given and never to a terminal,
+// e.g. to capture what they print
+func (this *Session) ForCapture() *Session {
+	session := &Session{}
+	if this != nil {
+		*session = *this
+	}
+	session.Output = nil
+	session.Pty = false
+	return session
+}
+
+// Stopped tells whether the run has been asked to stop
+func (this *Session) Stopped() bool {
+	select {
+	case <-this.stopChan():
+		return true
+	default:
+		return false
+	}
+}
+
+// environment returns what commands get on top of the environment of the server, params come last
+func (this *Session) environment() []string {
+	if this == nil {
+		return nil
+	}
+	return append(append([]string{}, this.Env...), this.paramEnv()...)
+}
+
+// paramEnv returns the params as environment variables, e.g. "PARAM_DRY_RUN=true" for the param "dry-run"
+func (this *Session) paramEnv() []string {
+	if this == nil {
+		return nil
+	}
+	var env []string
+	for name, value := range this.Params {
+		name = strings.ToUpper(paramEnvNamePattern.ReplaceAllString(name, "_"))
+		env = append(env, "PARAM_"+name+"="+value)
+	}
+	sort.Strings(env)
+	return env
+}
+
+// Outputs returns what a command should use as stdout and stderr, writer is only used when there is no Output
+func (this *Session) Outputs(writer IWriter) (io.Writer, io.Writer) {
+	if this == nil || this.Output == nil {
+		proxyWriter := NewProxyWriter(writer)
+		return proxyWriter, proxyWriter
+	}
+	return NewChunkProxyWriter(this.Output, StreamStdout), NewChunkProxyWriter(this.Output, StreamStderr)
+}
+
+func (this *Session) stopChan() chan bool {
+	if this == nil {
+		return nil
+	}
+	return this.ForceStop
+}
+
+func (this *Session) hasInput() bool {
+	return this != nil && this.input != nil
+}
+
+// WriteInput queues text for the standard input of the running command
+func (this *Session) WriteInput(text string) error {
+	if !this.hasInput() {
+		return fmt.Errorf("process does not accept input")
+	}
+	input := this.input
+	input.mutex.Lock()
+	defer input.mutex.Unlock()
+	if input.closed {
+		return ErrInputClosed
+	}
+	input.pending = append(input.pending, text...)
+	input.signal()
+	return nil
+}
+
+// CloseInput sends end of file once the queued input has been read, commands started afterwards get no input at all
+func (this *Session) CloseInput() {
+	if !this.hasInput() {
+		return
+	}
+	input := this.input
+	input.mutex.Lock()
+	defer input.mutex.Unlock()
+	input.closed = true
+	input.signal()
+}
+
+// Resize changes the terminal size of the running command and of the ones started later in Pty mode
+func (this *Session) Resize(rows uint16, cols uint16) error {
+	if !this.hasInput() {
+		return fmt.Errorf("process has no terminal")
+	}
+	input := this.input
+	input.mutex.Lock()
+	defer input.mutex.Unlock()
+	input.size = &pty.Winsize{Rows: rows, Cols: cols}
+	if input.terminal != nil {
+		return pty.Setsize(input.terminal, input.size)
+	}
+	return nil
+}
+
+func (this *sessionInput) signal() {
+	select {
+	case this.ready <- true:
+	default:
+	}
+}
+
+// feed copies the queued input to w until done is closed, on close it calls closeInput
+func (this *sessionInput) feed(w io.Writer, closeInput func(), done chan bool) {
+	for {
+		this.mutex.Lock()
+		data := this.pending
+		this.pending = nil
+		closed := this.closed
+		this.mutex.Unlock()
+		if len(data) > 0 {
+			if _, err := w.Write(data); err != nil {
+				return
+			}
+		}
+		if closed {
+			closeInput()
+			return
+		}
+		select {
+		case <-this.ready:
+		case <-done:
+			return
+		}
+	}
+}
+
+// startWithInput starts cmd with its standard input fed from the session
+func (this *Session) startWithInput(cmd *exec.Cmd, done chan bool) error {
+	if !this.hasInput() {
+		return cmd.Start()
+	}
+	this.input.mutex.Lock()
+	closed := this.input.closed
+	this.input.mutex.Unlock()
+	if closed {
+		return cmd.Start()
+	}
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	err = cmd.Start()
+	if err != nil {
+		return err
+	}
+	go this.input.feed(stdin, func() {
+		_ = stdin.Close()
+	}, done)
+	return nil
+}
+
+// runPty runs cmd in a new session with a pseudo terminal as its controlling terminal,
+// the terminal output goes to what was set as cmd.Stdout
+func (this *Session) runPty(cmd *exec.Cmd) error {
+	output := cmd.Stdout
+	terminal, tty, err := pty.Open()
+	if err != nil {
+		return err
+	}
+	defer terminal.Close()
+	this.input.mutex.Lock()
+	size := this.input.size
+	if size == nil {
+		size = &pty.Winsize{Rows: 24, Cols: 80}
+	}
+	_ = pty.Setsize(terminal, size)
+	this.input.mutex.Unlock()
+	cmd.Stdin = tty
+	cmd.Stdout = tty
+	cmd.Stderr = tty
+	// a new session makes the command the leader of its own process group as well
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true, Setctty: true}
+	err = cmd.Start()
+	_ = tty.Close()
+	if err != nil {
+		return err
+	}
+	this.input.mutex.Lock()
+	this.input.terminal = terminal
+	this.input.mutex.Unlock()
+	defer func() {
+		this.input.mutex.Lock()
+		this.input.terminal = nil
+		this.input.mutex.Unlock()
+	}()
+
+	done := make(chan bool)
+	defer close(done)
+	// closing the input of a terminal means typing ctrl-d
+	go this.input.feed(terminal, func() {
+		_, _ = terminal.Write([]byte{4})
+	}, done)
+	copied := make(chan bool)
+	go func() {
+		defer close(copied)
+		// reading fails with EIO once every process holding the terminal has exited
+		_, _ = io.Copy(output, terminal)
+	}()
+
+	finishChan := make(chan error, 1)
+	go func() {
+		finishChan <- cmd.Wait()
+	}()
+	select {
+	case err = <-finishChan:
+	case <-this.stopChan():
+		StopProcessGroup(cmd.Process.Pid, finishChan)
+		err = ErrForceStopped
+	}
+	select {
+	case <-copied:
+	case <-time.After(time.Second):
+		// a background process still holds the terminal, its output is not waited for
+	}
+	return err
+}
@@ -17,6 +17,9 @@ import (
 	"os/exec"
 	"strconv"
 	"strings"
+	"sync"
+	"syscall"
+	"time"
 	"github.com/tealeg/xlsx"
 )
 
@@ -61,9 +64,49 @@ func(this *ProxyWriter) Write(p []byte) (n int, err error) {
 	return len(p), nil
 }
 
+// IWriter receives plain text, which stream it belongs to is decided by whoever created it
 type IWriter func(str string)
 
-type CommandHandler func(w IWriter, param string, forceStop chan bool) error
+const (
+	StreamOutput = "output"
+	StreamStdout = "stdout"
+	StreamStderr = "stderr"
+	StreamSystem = "system"
+)
+
+// Chunk is a piece of output together with the stream it has been written to and when
+type Chunk struct {
+	Stream string
+	Time   time.Time
+	Text   string
+}
+
+// IChunkWriter receives output with its stream identity and time
+type IChunkWriter func(chunk Chunk)
+
+// Stream returns a plain writer tagging everything written to it with stream
+func (this IChunkWriter) Stream(stream string) IWriter {
+	return func(str string) {
+		this(Chunk{Stream: stream, Time: time.Now(), Text: str})
+	}
+}
+
+// ChunkProxyWriter is an io.Writer for one stream of a command, e.g. cmd.Stderr
+type ChunkProxyWriter struct {
+	realWriter IChunkWriter
+	stream     string
+}
+
+func NewChunkProxyWriter(writer IChunkWriter, stream string) *ChunkProxyWriter {
+	return &ChunkProxyWriter{realWriter: writer, stream: stream}
+}
+
+func (this *ChunkProxyWriter) Write(p []byte) (n int, err error) {
+	this.realWriter(Chunk{Stream: this.stream, Time: time.Now(), Text: string(p)})
+	return len(p), nil
+}
+
+type CommandHandler func(w IWriter, param string, session *Session) error
 
 
 const IsoDateFormat = StdLongYear + "-" + StdZeroMonth + "-" + StdZeroDay
@@ -172,6 +215,9 @@ func GetJsonValueFromKeyChain(body []byte, keyChain string) ([]byte, error) {
 			if err != nil {
 				return nil, err
 			}
+			if val < 0 || val >= len(tmp) {
+				return nil, fmt.Errorf("index %d is out of range in %s", val, keyChain)
+			}
 			item = tmp[val]
 		} else {
 			tmp := map[string]interface{}{}
@@ -257,55 +303,173 @@ func FetchAll(db *sql.DB, query string, args ...interface{}) ([]map[string]inter
 	return resRows, nil
 }
 
-func RunLinuxCommandByCsvWithDirectory(dir string, command string, writer IWriter, forceStop chan bool) error {
-	cmd := exec.Command("bash", "-c", command)
-	cmd.Dir = dir
-	proxyWriter := NewProxyWriter(writer)
-	cmd.Stdout = proxyWriter
-	cmd.Stderr = proxyWriter
-	err := cmd.Start()
+// StopGracePeriod is how long a stopped process group may take to exit after SIGTERM before it gets SIGKILL
+var StopGracePeriod = 5 * time.Second
+
+var ErrForceStopped = fmt.Errorf("process has been stopped")
+
+// RunCmd starts cmd in its own process group and waits for it, the session feeds its input,
+// closing session.ForceStop terminates the whole group instead of only the direct child
+func RunCmd(cmd *exec.Cmd, session *Session) error {
+	if env := session.environment(); len(env) > 0 {
+		if cmd.Env == nil {
+			cmd.Env = os.Environ()
+		}
+		// a later entry of the same name wins
+		cmd.Env = append(cmd.Env, env...)
+	}
+	if cmd.Dir == "" && session != nil {
+		cmd.Dir = session.Dir
+	}
+	if session.hasInput() && session.Pty {
+		return session.runPty(cmd)
+	}
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Setpgid = true
+	done := make(chan bool)
+	defer close(done)
+	err := session.startWithInput(cmd, done)
 	if err != nil {
 		return err
 	}
-	finishChan := make(chan error)
-	go func(cmd *exec.Cmd) {
-		err = cmd.Wait()
-		if err != nil {
-			finishChan <- err
+	finishChan := make(chan error, 1)
+	go func() {
+		finishChan <- cmd.Wait()
+	}()
+	select {
+	case err := <-finishChan:
+		return err
+	case <-session.stopChan():
+		StopProcessGroup(cmd.Process.Pid, finishChan)
+		return ErrForceStopped
+	}
+}
+
+// StopProcessGroup sends SIGTERM to the process group, then SIGKILL to whatever is left after StopGracePeriod.
+// The leader, often a bash that exits on SIGTERM at once, may be gone long before its children, e.g. docker-compose,
+// ssh or mysql, so the grace period is that of the whole group
+func StopProcessGroup(pgid int, finishChan chan error) {
+	_ = syscall.Kill(-pgid, syscall.SIGTERM)
+	gracePeriod := time.After(StopGracePeriod)
+	select {
+	case <-finishChan:
+		if waitForProcessGroup(pgid, gracePeriod) {
 			return
 		}
-		finishChan <- nil
-	}(cmd)
+		_ = syscall.Kill(-pgid, syscall.SIGKILL)
+	case <-gracePeriod:
+		_ = syscall.Kill(-pgid, syscall.SIGKILL)
+		select {
+		case <-finishChan:
+		case <-time.After(StopGracePeriod):
+			fmt.Printf("process group %d is still holding its output after SIGKILL\n", pgid)
+		}
+	}
+}
+
+// processGroupPollInterval is how often a process group whose leader has exited is checked for processes left
+const processGroupPollInterval = 50 * time.Millisecond
+
+// waitForProcessGroup tells whether every process of the group has exited before deadline
+func waitForProcessGroup(pgid int, deadline <-chan time.Time) bool {
+	ticker := time.NewTicker(processGroupPollInterval)
+	defer ticker.Stop()
+	for {
+		// signal 0 only checks that the group still has a process
+		if err := syscall.Kill(-pgid, 0); err == syscall.ESRCH {
+			return true
+		}
+		select {
+		case <-deadline:
+			return false
+		case <-ticker.C:
+		}
+	}
+}
+
+// ParseDuration accepts either a number of seconds or a go duration like "1h30m", empty means no duration
+func ParseDuration(str string) (time.Duration, error) {
+	str = strings.TrimSpace(str)
+	if str == "" {
+		return 0, nil
+	}
+	if seconds, err := strconv.Atoi(str); err == nil {
+		return time.Second * time.Duration(seconds), nil
+	}
+	return time.ParseDuration(str)
+}
+
+// WithTimeout derives a stop channel that is closed when forceStop is closed or when timeout elapses,
+// release must be called once the work is done
+func WithTimeout(forceStop chan bool, timeout time.Duration) (stop chan bool, timedOut func() bool, release func()) {
+	stop = make(chan bool)
+	done := make(chan bool)
+	fired := make(chan bool)
 	go func() {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
 		select {
 		case <-forceStop:
-			finishChan <- cmd.Process.Kill()
+			close(stop)
+		case <-timer.C:
+			close(fired)
+			close(stop)
+		case <-done:
 		}
 	}()
-	return <-finishChan
+	var once sync.Once
+	timedOut = func() bool {
+		select {
+		case <-fired:
+			return true
+		default:
+			return false
+		}
+	}
+	release = func() {
+		once.Do(func() {
+			close(done)
+		})
+	}
+	return stop, timedOut, release
 }
 
-func RunLinuxCommandWithDirectory(dir string, command string, writer IWriter, forceStop chan bool) error {
-	return RunLinuxCommandByCsvWithDirectory(dir, command, writer, forceStop)
+func RunLinuxCommandByCsvWithDirectory(dir string, command string, writer IWriter, session *Session) error {
+	cmd := exec.Command("bash", "-c", command)
+	cmd.Dir = dir
+	cmd.Stdout, cmd.Stderr = session.Outputs(writer)
+	return RunCmd(cmd, session)
+}
+
+func RunLinuxCommandWithDirectory(dir string, command string, writer IWriter, session *Session) error {
+	return RunLinuxCommandByCsvWithDirectory(dir, command, writer, session)
 }
 
-func RunLinuxCommand(command string, writer IWriter, forceStop chan bool) error {
-	return RunLinuxCommandWithDirectory("", command, writer, forceStop)
+func RunLinuxCommand(command string, writer IWriter, session *Session) error {
+	return RunLinuxCommandWithDirectory("", command, writer, session)
 }
 
-func RunBashScript(script string, workDir string, writer IWriter, forceStop chan bool) error {
-	wd, err := os.Getwd()
+// RunBashScript writes the script to a file of its own, runs may run scripts at the same time, and removes it afterwards
+func RunBashScript(script string, workDir string, writer IWriter, session *Session) error {
+	file, err := ioutil.TempFile("", "automation-notebook-*.sh")
 	if err != nil {
 		return err
 	}
-	err = ioutil.WriteFile(wd + "/test.sh", []byte(script), 0777)
+	defer os.Remove(file.Name())
+	_, err = file.WriteString(script)
+	if err == nil {
+		err = file.Chmod(0700)
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
 	if err != nil {
 		return err
 	}
-	if workDir != "" {
-		return RunLinuxCommand("cd " + wd + " && ./test.sh", writer, forceStop)
-	}
-	return RunLinuxCommand("./test.sh", writer, forceStop)
+	// the script runs in workDir, or the working directory of the session when it is empty
+	return RunLinuxCommandWithDirectory(workDir, file.Name(), writer, session)
 }
 
 func GenerateXLSXFromCSV(csvPath string, XLSXPath string, delimiter string) error {
@@ -335,7 +499,7 @@ func GenerateXLSXFromCSV(csvPath string, XLSXPath string, delimiter string) erro
 		fields, err = reader.Read()
 	}
 	if err != nil {
-		fmt.Printf(err.Error())
+		fmt.Print(err.Error())
 	}
 	return xlsxFile.Save(XLSXPath)
 }
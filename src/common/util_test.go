@@ -0,0 +1,77 @@
+package common
+
+import (
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// stopAfterReady runs script in its own process group and stops it once it has written ready to log
+func stopAfterReady(t *testing.T, script string, log string) (error, time.Duration) {
+	stop := make(chan bool)
+	finished := make(chan error, 1)
+	go func() {
+		finished <- RunCmd(exec.Command("bash", "-c", script), &Session{ForceStop: stop})
+	}()
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		data, _ := ioutil.ReadFile(log)
+		if strings.Contains(string(data), "ready") {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the script did not start")
+		}
+	}
+	stopped := time.Now()
+	close(stop)
+	err := <-finished
+	return err, time.Since(stopped)
+}
+
+func TestStopProcessGroupGivesChildrenTheGracePeriod(t *testing.T) {
+	defer func(period time.Duration) { StopGracePeriod = period }(StopGracePeriod)
+	StopGracePeriod = 5 * time.Second
+	log := filepath.Join(t.TempDir(), "child.log")
+	// bash exits on SIGTERM at once, its child takes a while to clean up
+	script := `sh -c 'trap "sleep 0.3; echo cleaned up; exit 0" TERM; echo ready; while :; do sleep 0.05; done' > ` + log + ` 2>&1 &
+wait`
+	err, took := stopAfterReady(t, script, log)
+	if err != ErrForceStopped {
+		t.Errorf("got %v, want %v", err, ErrForceStopped)
+	}
+	data, _ := ioutil.ReadFile(log)
+	if !strings.Contains(string(data), "cleaned up") {
+		t.Errorf("the child has been killed before it cleaned up, it wrote %q", data)
+	}
+	if took >= StopGracePeriod {
+		t.Errorf("stopping took %s, the group was gone before the grace period was over", took)
+	}
+}
+
+func TestStopProcessGroupKillsChildrenAfterTheGracePeriod(t *testing.T) {
+	defer func(period time.Duration) { StopGracePeriod = period }(StopGracePeriod)
+	StopGracePeriod = 300 * time.Millisecond
+	log := filepath.Join(t.TempDir(), "child.log")
+	// the child ignores SIGTERM
+	script := `sh -c 'trap "" TERM; echo ready; while :; do sleep 0.05; done' > ` + log + ` 2>&1 &
+echo $! > ` + log + `.pid
+wait`
+	err, took := stopAfterReady(t, script, log)
+	if err != ErrForceStopped {
+		t.Errorf("got %v, want %v", err, ErrForceStopped)
+	}
+	if took < StopGracePeriod || took > 3*StopGracePeriod {
+		t.Errorf("stopping took %s, want about %s", took, StopGracePeriod)
+	}
+	pid, _ := ioutil.ReadFile(log + ".pid")
+	if exec.Command("kill", "-0", strings.TrimSpace(string(pid))).Run() == nil {
+		// the child may be a zombie left to a parent that does not reap it
+		state, _ := ioutil.ReadFile("/proc/" + strings.TrimSpace(string(pid)) + "/stat")
+		if !strings.Contains(string(state), ") Z ") {
+			t.Errorf("the child ignoring SIGTERM is still running: %s", state)
+		}
+	}
+}
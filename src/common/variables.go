@@ -0,0 +1,37 @@
+package common
+
+import "sync"
+
+// Variables are set by the steps of a run for the steps after them, e.g. with "save output as",
+// every session derived from the session of the run shares them
+type Variables struct {
+	mutex  sync.Mutex
+	values map[string]string
+}
+
+func NewVariables() *Variables {
+	return &Variables{values: map[string]string{}}
+}
+
+func (this *Variables) Set(name string, value string) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	this.values[name] = value
+}
+
+// All returns a copy of the variables, nil when there are none
+func (this *Variables) All() map[string]string {
+	if this == nil {
+		return nil
+	}
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	if len(this.values) == 0 {
+		return nil
+	}
+	output := make(map[string]string, len(this.values))
+	for k, v := range this.values {
+		output[k] = v
+	}
+	return output
+}
@@ -9,12 +9,30 @@ import (
 	"os/exec"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 	"yaml_config"
 )
 
+// CommandOptions holds how a command should be run, beside the handler itself
+type CommandOptions struct {
+	Timeout   time.Duration
+	Singleton string
+	Locks     []string
+	Pty       bool
+	Params    []yaml_config.ParamDefinition
+	// Tags group commands for the rules of notification.yml
+	Tags []string
+	// Workflow is set for the commands of workflow.yml, the scheduler runs their nodes
+	Workflow *yaml_config.WorkflowItem
+}
+
+// CommandCenter is shared by every request and run, Reload builds the commands again and swaps them in under mutex
 type CommandCenter struct {
+	mutex                    sync.RWMutex
 	commands                 map[string]common.CommandHandler
+	options                  map[string]CommandOptions
+	choiceSources            map[string]ChoiceSource
 	time                     int64
 	config                   yaml_config.IConfig
 	curl                     yaml_config.ICurl
@@ -24,6 +42,7 @@ type CommandCenter struct {
 func NewCommandCenter(config yaml_config.IConfig, curl yaml_config.ICurl, test *yaml_config.AutomatedCheckCollection) *CommandCenter {
 	return &CommandCenter{
 		commands:                 nil,
+		options:                  nil,
 		time:                     0,
 		config:                   config,
 		curl:                     curl,
@@ -31,7 +50,9 @@ func NewCommandCenter(config yaml_config.IConfig, curl yaml_config.ICurl, test *
 	}
 }
 
-func (this *CommandCenter) reloadCommandsFromCodeFiles(newCommands map[string]common.CommandHandler) error {
+// reloadCommandsFromCodeFiles adds a command for every go file in formula, the file gets the text after "command:"
+// as its argument and the params declared in <name>.params.yml next to it as PARAM_<NAME> environment variables
+func (this *CommandCenter) reloadCommandsFromCodeFiles(newCommands map[string]common.CommandHandler, newOptions map[string]CommandOptions) error {
 	goRoot, err := this.config.GetStringByKey("go root")
 	if err != nil {
 		return err
@@ -49,116 +70,256 @@ func (this *CommandCenter) reloadCommandsFromCodeFiles(newCommands map[string]co
 			return nil
 		}
 		nameWithoutExtension := name[:len(name) - len(extension)]
-		newCommands[nameWithoutExtension] = func(w common.IWriter, param string, forceStopChan chan bool) error {
+		params, err := readParamDefinitions(filepath.Join(filepath.Dir(path), nameWithoutExtension + ".params.yml"))
+		if err != nil {
+			fmt.Printf("formula %s: %s\n", nameWithoutExtension, err)
+		}
+		newOptions[nameWithoutExtension] = CommandOptions{Params: params}
+		newCommands[nameWithoutExtension] = func(w common.IWriter, param string, session *common.Session) error {
 			cmd := exec.Command(goRoot, "run", "formula/" + info.Name(), param)
-			proxyWriter := common.NewProxyWriter(w)
-			cmd.Stdout = proxyWriter
-			cmd.Stderr = proxyWriter
-			err := cmd.Start()
-			if err != nil {
-				return err
-			}
-			finishChan := make(chan error)
-			go func() {
-				err = cmd.Wait()
-				if err != nil {
-					finishChan <- err
-					return
-				}
-				finishChan <- nil
-			}()
-			go func() {
-				<- forceStopChan
-				finishChan <- nil
-			}()
-			return <- finishChan
-
+			cmd.Stdout, cmd.Stderr = session.Outputs(w)
+			return common.RunCmd(cmd, session)
 		}
 		return nil
 	})
 }
 
-func (this *CommandCenter) reloadCommandsFromCurl(newCommands map[string]common.CommandHandler) error {
+func (this *CommandCenter) reloadCommandsFromCurl(newCommands map[string]common.CommandHandler, newOptions map[string]CommandOptions) error {
 	data, err := ioutil.ReadFile("config/curl.yml")
 	if err != nil {
 		return err
 	}
-	out := map[string]interface{}{}
+	out := map[string]yaml_config.CurlItem{}
 	err = yaml.Unmarshal(data, out)
 	if err != nil {
 		return err
 	}
 	for k := range out {
-		func(k string) {
-			newCommands["curl " + k] = func(w common.IWriter, param string, forceStop chan bool) error {
-				this.curl.EnableVerbose()
-				this.curl.SetWriter(w)
-				return this.curl.RunForKey(k)
+		func(k string, item yaml_config.CurlItem) {
+			if err := yaml_config.CheckParamDefinitions(out[k].Params); err != nil {
+				fmt.Printf("curl %s: %s\n", k, err)
 			}
-		}(k)
+			if err := out[k].Retry.Check(); err != nil {
+				fmt.Printf("curl %s: retry: %s\n", k, err)
+			}
+			// the item is rendered when it runs, every reference is checked now
+			if err := item.CheckTemplates(this.config); err != nil {
+				fmt.Printf("curl %s: %s\n", k, err)
+			}
+			newOptions["curl " + k] = CommandOptions{Params: out[k].Params}
+			newCommands["curl " + k] = func(w common.IWriter, param string, session *common.Session) error {
+				res, err := this.curl.RunForKeyWithParams(k, session.Params, true, yaml_config.ICurlWriter(w), session)
+				if res != nil {
+					_ = res.Body.Close()
+				}
+				return err
+			}
+		}(k, out[k])
 	}
 	return nil
 }
 
-func (this *CommandCenter) reloadCommandsFromFormulaConfig(newCommands map[string]common.CommandHandler) error {
-	type BashScriptInfo struct {
-		Content string `yaml:"content"`
-		WorkingDirectoryConfig string `yaml:"working directory config"`
-	}
-	type Command struct {
-		OpenUrl string `yaml:"open url"`
-		Output string `yaml:"output"`
-		RunLinuxCommand string `yaml:"run linux command"`
-		RunLinuxCommandByCsv string `yaml:"run linux command by csv"`
-		RunBashScript *BashScriptInfo `yaml:"run bash script"`
+// readParamDefinitions reads a list of params from a yaml file, a missing file declares no params
+func readParamDefinitions(path string) ([]yaml_config.ParamDefinition, error) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
 	}
+	if err != nil {
+		return nil, err
+	}
+	var params []yaml_config.ParamDefinition
+	err = yaml.Unmarshal(data, &params)
+	if err != nil {
+		return nil, err
+	}
+	return params, yaml_config.CheckParamDefinitions(params)
+}
+
+func (this *CommandCenter) reloadCommandsFromFormulaConfig(newCommands map[string]common.CommandHandler, newOptions map[string]CommandOptions) error {
 	data, err := ioutil.ReadFile("config/formula.yml")
 	if err != nil {
 		return err
 	}
-	out := map[string][]Command{}
+	out := map[string]yaml_config.FormulaItem{}
 	err = yaml.Unmarshal(data, out)
 	if err != nil {
 		return err
 	}
 	for k := range out {
-		func(k string, commands []Command) {
-			newCommands[k] = func(w common.IWriter, param string, forceStop chan bool) (err error) {
-				defer func() {
-					if r := recover(); r != nil {
-						err = r.(error)
-					}
-				}()
-				for k := range commands {
-					command := commands[k]
-					if command.RunLinuxCommand != "" {
-						w("executing " + command.RunLinuxCommand + "\n")
-						common.PanicOnError(common.RunLinuxCommand(command.RunLinuxCommand, w, forceStop))
-					}
-					if command.RunBashScript != nil {
-						w("running bash script... \n")
-						common.PanicOnError(RunBashScriptFromWorkingDirectoryConfig(this.config, command.RunBashScript.Content, command.RunBashScript.WorkingDirectoryConfig, w, forceStop))
-					}
-					if command.RunLinuxCommandByCsv != "" {
-						w("executing " + command.RunLinuxCommandByCsv + "\n")
-						common.PanicOnError(common.RunLinuxCommandByCsvWithDirectory("", command.RunLinuxCommandByCsv, w, forceStop))
-					}
-					if command.Output != "" {
-						w(command.Output)
-					}
-					if command.OpenUrl != "" {
-						cmd := exec.Command("sudo","-u","namph12","firefox","-new-tab","-url",command.OpenUrl)
-						common.PanicOnError(cmd.Run())
-					}
-				}
-				return nil
+		func(k string, item yaml_config.FormulaItem) {
+			// steps are rendered when they run, every reference is checked now
+			if err := item.CheckTemplates(this.config); err != nil {
+				fmt.Printf("formula %s: %s\n", k, err)
+			}
+			timeout, err := item.GetTimeout()
+			if err != nil {
+				fmt.Printf("formula %s: %s\n", k, err)
+			}
+			if item.Singleton != "" && item.Singleton != SingletonModeReject && item.Singleton != SingletonModeQueue {
+				fmt.Printf("formula %s: singleton must be %s or %s\n", k, SingletonModeReject, SingletonModeQueue)
+			}
+			if err := yaml_config.CheckParamDefinitions(item.Params); err != nil {
+				fmt.Printf("formula %s: %s\n", k, err)
+			}
+			if err := item.CheckRetries(); err != nil {
+				fmt.Printf("formula %s: %s\n", k, err)
+			}
+			newOptions[k] = CommandOptions{
+				Timeout:   timeout,
+				Singleton: item.Singleton,
+				Locks:     item.Locks,
+				Pty:       item.Pty,
+				Params:    item.Params,
+				Tags:      item.Tags,
+			}
+			newCommands[k] = func(w common.IWriter, param string, session *common.Session) error {
+				return item.Run(this.config, this.curl, w, session)
 			}
 		}(k, out[k])
 	}
 	return nil
 }
 
-func (this *CommandCenter) reloadCommandsFromIntegrationTest(newCommands map[string]common.CommandHandler) error {
+// reloadCommandsFromWorkflows registers the workflows of workflow.yml, a missing file declares none
+func (this *CommandCenter) reloadCommandsFromWorkflows(newCommands map[string]common.CommandHandler, newOptions map[string]CommandOptions) error {
+	data, err := ioutil.ReadFile("config/workflow.yml")
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	out := map[string]yaml_config.WorkflowItem{}
+	err = yaml.Unmarshal(data, out)
+	if err != nil {
+		return err
+	}
+	for k := range out {
+		item := out[k]
+		if err := item.Check(k); err != nil {
+			fmt.Printf("workflow %s: %s\n", k, err)
+			continue
+		}
+		if err := item.CheckTemplates(this.config); err != nil {
+			fmt.Printf("workflow %s: %s\n", k, err)
+		}
+		timeout, err := item.GetTimeout()
+		if err != nil {
+			fmt.Printf("workflow %s: %s\n", k, err)
+		}
+		if item.Singleton != "" && item.Singleton != SingletonModeReject && item.Singleton != SingletonModeQueue {
+			fmt.Printf("workflow %s: singleton must be %s or %s\n", k, SingletonModeReject, SingletonModeQueue)
+		}
+		newOptions[k] = CommandOptions{
+			Timeout:   timeout,
+			Singleton: item.Singleton,
+			Locks:     item.Locks,
+			Params:    item.Params,
+			Tags:      item.Tags,
+			Workflow:  &item,
+		}
+		name := k
+		newCommands[k] = func(w common.IWriter, param string, session *common.Session) error {
+			return fmt.Errorf("workflow %s runs through the scheduler", name)
+		}
+	}
+	// a workflow running itself through other workflows would never end, those in a cycle are left out
+	workflows := map[string]*yaml_config.WorkflowItem{}
+	for k := range out {
+		if options, ok := newOptions[k]; ok && options.Workflow != nil {
+			workflows[k] = options.Workflow
+		}
+	}
+	for k, cycle := range findWorkflowCycles(workflows) {
+		fmt.Printf("workflow %s: it runs itself through %s\n", k, cycle)
+		delete(newCommands, k)
+		delete(newOptions, k)
+	}
+	return nil
+}
+
+// generatedCommands are the commands, options and choice sources generated from one item of docker.yml,
+// docker-compose.yml, git-repo.yml or mysql.yml
+type generatedCommands struct {
+	commands      map[string]common.CommandHandler
+	options       map[string]CommandOptions
+	choiceSources map[string]ChoiceSource
+}
+
+func newGeneratedCommands() *generatedCommands {
+	return &generatedCommands{
+		commands:      map[string]common.CommandHandler{},
+		options:       map[string]CommandOptions{},
+		choiceSources: map[string]ChoiceSource{},
+	}
+}
+
+// itemGenerator renders an item with template then adds the commands it generates to generated
+type itemGenerator func(template *yaml_config.Template, generated *generatedCommands) error
+
+// addGeneratedCommands registers the commands of the item called name, declaring params. The item is generated
+// once now, with its params given as empty, to know which commands there are and again for every run with its params,
+// so a run gets config.yml, the environment and the secrets as they are then. An item whose references cannot be
+// resolved now is reported and generates nothing, e.g. docker x: unresolved references: {{env.HOST}} (...)
+func (this *CommandCenter) addGeneratedCommands(kind string, name string, params []yaml_config.ParamDefinition, generate itemGenerator,
+	newCommands map[string]common.CommandHandler, newOptions map[string]CommandOptions, newChoiceSources map[string]ChoiceSource) {
+	if err := yaml_config.CheckParamDefinitions(params); err != nil {
+		fmt.Printf("%s %s: %s\n", kind, name, err)
+	}
+	generated := newGeneratedCommands()
+	if err := generate(yaml_config.NewTemplate(this.config).WithDeclaredParams(params), generated); err != nil {
+		fmt.Printf("%s %s: %s\n", kind, name, err)
+		return
+	}
+	// generateForRun renders the item for the run of session, choices are computed outside of any run and without params
+	generateForRun := func(session *common.Session) (*generatedCommands, error) {
+		template := yaml_config.NewTemplate(this.config).WithParams(session.Params)
+		if session.Params == nil {
+			template = template.WithDeclaredParams(params)
+		}
+		generated := newGeneratedCommands()
+		if err := generate(template, generated); err != nil {
+			return nil, fmt.Errorf("%s %s: %w", kind, name, err)
+		}
+		return generated, nil
+	}
+	for commandName := range generated.commands {
+		func(commandName string) {
+			options := generated.options[commandName]
+			options.Params = params
+			newOptions[commandName] = options
+			newCommands[commandName] = func(w common.IWriter, param string, session *common.Session) error {
+				generated, err := generateForRun(session)
+				if err != nil {
+					return err
+				}
+				command, ok := generated.commands[commandName]
+				if !ok {
+					return fmt.Errorf("%s %s does not generate %s for this run", kind, name, commandName)
+				}
+				return command(w, param, session)
+			}
+		}(commandName)
+	}
+	for sourceName := range generated.choiceSources {
+		func(sourceName string) {
+			newChoiceSources[sourceName] = func(session *common.Session) ([]string, error) {
+				generated, err := generateForRun(session)
+				if err != nil {
+					return nil, err
+				}
+				source, ok := generated.choiceSources[sourceName]
+				if !ok {
+					return nil, fmt.Errorf("%s %s does not generate %s for this run", kind, name, sourceName)
+				}
+				return source(session)
+			}
+		}(sourceName)
+	}
+}
+
+func (this *CommandCenter) reloadCommandsFromIntegrationTest(newCommands map[string]common.CommandHandler, newOptions map[string]CommandOptions) error {
 	data, err := ioutil.ReadFile("config/automated-check.yml")
 	if err != nil {
 		return err
@@ -170,20 +331,27 @@ func (this *CommandCenter) reloadCommandsFromIntegrationTest(newCommands map[str
 	}
 	for k := range out {
 		func(k string) {
-			newCommands["integration test for " + k] = func(w common.IWriter, param string, forceStop chan bool) error {
+			// steps are rendered when they run, every reference is checked now
+			item := out[k]
+			if err := item.CheckTemplates(this.config); err != nil {
+				fmt.Printf("automated check %s: %s\n", k, err)
+			}
+			if err := yaml_config.CheckParamDefinitions(out[k].Params); err != nil {
+				fmt.Printf("automated check %s: %s\n", k, err)
+			}
+			newOptions["integration test for " + k] = CommandOptions{Params: out[k].Params}
+			newCommands["integration test for " + k] = func(w common.IWriter, param string, session *common.Session) error {
 				w(">>>> START integration test for " + k + "...\n")
-				this.automatedCheckCollection.SetWriter(yaml_config.IAutomatedCheckWriter(w))
-				err := this.automatedCheckCollection.Run(k)
+				err := this.automatedCheckCollection.Run(k, yaml_config.IAutomatedCheckWriter(w), session)
 				w(">>>> END integration test for " + k + "...\n")
 				return err
 			}
 			info := out[k]
 			if info.Group != nil {
 				if _, ok := newCommands["group test for " + *info.Group]; !ok {
-					newCommands["group test for " + *info.Group] = func(w common.IWriter, param string, forceStop chan bool) error {
+					newCommands["group test for " + *info.Group] = func(w common.IWriter, param string, session *common.Session) error {
 						w(fmt.Sprintf("============================== BEGIN RUNNING GROUP: %s ==============================\n", *info.Group))
-						this.automatedCheckCollection.SetWriter(yaml_config.IAutomatedCheckWriter(w))
-						err := this.automatedCheckCollection.RunGroup(*info.Group)
+						err := this.automatedCheckCollection.RunGroup(*info.Group, yaml_config.IAutomatedCheckWriter(w), session)
 						w(fmt.Sprintf("============================== END RUNNING GROUP: %s ==============================\n", *info.Group))
 						return err
 					}
@@ -194,7 +362,7 @@ func (this *CommandCenter) reloadCommandsFromIntegrationTest(newCommands map[str
 	return nil
 }
 
-func (this *CommandCenter) reloadCommandsFromDockerCompose(newCommands map[string]common.CommandHandler) error {
+func (this *CommandCenter) reloadCommandsFromDockerCompose(newCommands map[string]common.CommandHandler, newOptions map[string]CommandOptions, newChoiceSources map[string]ChoiceSource) error {
 	type DockerComposeServiceDefinition struct {
 		Image         string      `yaml:"image,omitempty"`
 		ContainerName string      `yaml:"container_name,omitempty"`
@@ -214,6 +382,7 @@ func (this *CommandCenter) reloadCommandsFromDockerCompose(newCommands map[strin
 		WorkingDirectory string `yaml:"working directory"`
 		DockerComposeDefinitionFromConfigPath string `yaml:"docker-compose definition from config path"`
 		DockerComposeDefinition *DockerComposeDefinition `yaml:"docker-compose definition"`
+		Params []yaml_config.ParamDefinition `yaml:"params"`
 	}
 	data, err := ioutil.ReadFile("config/docker-compose.yml")
 	if err != nil {
@@ -225,86 +394,96 @@ func (this *CommandCenter) reloadCommandsFromDockerCompose(newCommands map[strin
 		return err
 	}
 	for k := range out {
-		func(dockerComposeConfigName string) {
-			info := out[dockerComposeConfigName]
-			definition := info.DockerComposeDefinition
-			workingDirectory := ""
-			if info.DockerComposeDefinitionFromConfigPath != "" {
-				path, err := this.config.GetStringByKey(info.DockerComposeDefinitionFromConfigPath)
-				if err != nil {
-					fmt.Println(err)
-					return
-				}
-				workingDirectory = path
-				b, err := ioutil.ReadFile(path + "/docker-compose.yml")
-				if err != nil {
-					fmt.Println(err)
-					return
+		func(dockerComposeConfigName string, item YamlDockerComposeItem) {
+			this.addGeneratedCommands("docker-compose", dockerComposeConfigName, item.Params, func(template *yaml_config.Template, generated *generatedCommands) error {
+				info := item
+				if err := template.RenderValue(&info); err != nil {
+					return err
 				}
-				err = yaml.Unmarshal(b, &definition)
-				if err != nil {
-					fmt.Println(err)
-					return
+				definition := info.DockerComposeDefinition
+				workingDirectory := ""
+				if info.DockerComposeDefinitionFromConfigPath != "" {
+					path, err := this.config.GetStringByKey(info.DockerComposeDefinitionFromConfigPath)
+					if err != nil {
+						return err
+					}
+					workingDirectory = path
+					b, err := ioutil.ReadFile(path + "/docker-compose.yml")
+					if err != nil {
+						return err
+					}
+					err = yaml.Unmarshal(b, &definition)
+					if err != nil {
+						return err
+					}
 				}
-			}
-			if info.WorkingDirectory != "" {
-				workingDirectory = info.WorkingDirectory
-			}
-			if info.DockerComposeDefinitionFromConfigPath != "" && workingDirectory != "" {
-				newCommands[fmt.Sprintf("stop all containers of %s", dockerComposeConfigName)] = func(w common.IWriter, param string, forceStop chan bool) error {
-					return common.RunLinuxCommandWithDirectory(workingDirectory, "docker-compose stop", w, forceStop)
+				if info.WorkingDirectory != "" {
+					workingDirectory = info.WorkingDirectory
 				}
-				newCommands[fmt.Sprintf("view status of all containers of %s", dockerComposeConfigName)] = func(w common.IWriter, param string, forceStop chan bool) error {
-					return common.RunLinuxCommandWithDirectory(workingDirectory, "docker-compose ps", w, forceStop)
+				if info.DockerComposeDefinitionFromConfigPath != "" && workingDirectory != "" {
+					generated.commands[fmt.Sprintf("stop all containers of %s", dockerComposeConfigName)] = func(w common.IWriter, param string, session *common.Session) error {
+						return common.RunLinuxCommandWithDirectory(workingDirectory, "docker-compose stop", w, session)
+					}
+					generated.commands[fmt.Sprintf("view status of all containers of %s", dockerComposeConfigName)] = func(w common.IWriter, param string, session *common.Session) error {
+						return common.RunLinuxCommandWithDirectory(workingDirectory, "docker-compose ps", w, session)
+					}
+					generated.commands[fmt.Sprintf("start (create) all containers of %s", dockerComposeConfigName)] = func(w common.IWriter, param string, session *common.Session) error {
+						return common.RunLinuxCommandWithDirectory(workingDirectory, "docker-compose up", w, session)
+					}
 				}
-				newCommands[fmt.Sprintf("start (create) all containers of %s", dockerComposeConfigName)] = func(w common.IWriter, param string, forceStop chan bool) error {
-					return common.RunLinuxCommandWithDirectory(workingDirectory, "docker-compose up", w, forceStop)
+				if workingDirectory != "" && dockerComposeConfigName != "" && info.DockerComposeDefinition != nil {
+					generated.commands[fmt.Sprintf("sync docker-compose.yml of %s", dockerComposeConfigName)] = func(w common.IWriter, param string, session *common.Session) error {
+						data, err := yaml.Marshal(info.DockerComposeDefinition)
+						if err != nil {
+							return err
+						}
+						return ioutil.WriteFile(workingDirectory + "/docker-compose.yml", data, 0777)
+					}
 				}
-			}
-			if workingDirectory != "" && dockerComposeConfigName != "" && info.DockerComposeDefinition != nil {
-				newCommands[fmt.Sprintf("sync docker-compose.yml of %s", dockerComposeConfigName)] = func(w common.IWriter, param string, forceStop chan bool) error {
-					data, err := yaml.Marshal(info.DockerComposeDefinition)
-					if err != nil {
-						return err
+				if definition != nil {
+					var services []string
+					for serviceName := range definition.Services {
+						services = append(services, serviceName)
 					}
-					return ioutil.WriteFile(workingDirectory + "/docker-compose.yml", data, 0777)
+					generated.choiceSources[fmt.Sprintf("docker-compose services of %s", dockerComposeConfigName)] = staticChoices(services)
 				}
-			}
-			for serviceName := range definition.Services {
-				func(serviceName string) {
-					if workingDirectory != "" {
-						newCommands[fmt.Sprintf("view logs container %s of %s", serviceName, dockerComposeConfigName)] = func(w common.IWriter, param string, forceStop chan bool) error {
-							return common.RunLinuxCommandWithDirectory(workingDirectory, fmt.Sprintf("docker-compose logs --tail 10000 -f %s",serviceName), w, forceStop)
-						}
-						newCommands[fmt.Sprintf("start (create) container %s of %s", serviceName, dockerComposeConfigName)] = func(w common.IWriter, param string, forceStop chan bool) error {
-							return common.RunLinuxCommandWithDirectory(workingDirectory, fmt.Sprintf("docker-compose up %s",serviceName), w, forceStop)
-						}
-						newCommands[fmt.Sprintf("recreate container %s of %s", serviceName, dockerComposeConfigName)] = func(w common.IWriter, param string, forceStop chan bool) error {
-							err := common.RunLinuxCommandWithDirectory(workingDirectory, fmt.Sprintf("docker-compose stop %s",serviceName), w, forceStop)
-							if err != nil {
-								return err
+				for serviceName := range definition.Services {
+					func(serviceName string) {
+						if workingDirectory != "" {
+							generated.commands[fmt.Sprintf("view logs container %s of %s", serviceName, dockerComposeConfigName)] = func(w common.IWriter, param string, session *common.Session) error {
+								return common.RunLinuxCommandWithDirectory(workingDirectory, fmt.Sprintf("docker-compose logs --tail 10000 -f %s",serviceName), w, session)
 							}
-							err = common.RunLinuxCommandWithDirectory(workingDirectory, fmt.Sprintf("docker-compose rm -f %s",serviceName), w, forceStop)
-							if err != nil {
-								return err
+							generated.commands[fmt.Sprintf("start (create) container %s of %s", serviceName, dockerComposeConfigName)] = func(w common.IWriter, param string, session *common.Session) error {
+								return common.RunLinuxCommandWithDirectory(workingDirectory, fmt.Sprintf("docker-compose up %s",serviceName), w, session)
+							}
+							generated.commands[fmt.Sprintf("recreate container %s of %s", serviceName, dockerComposeConfigName)] = func(w common.IWriter, param string, session *common.Session) error {
+								err := common.RunLinuxCommandWithDirectory(workingDirectory, fmt.Sprintf("docker-compose stop %s",serviceName), w, session)
+								if err != nil {
+									return err
+								}
+								err = common.RunLinuxCommandWithDirectory(workingDirectory, fmt.Sprintf("docker-compose rm -f %s",serviceName), w, session)
+								if err != nil {
+									return err
+								}
+								return common.RunLinuxCommandWithDirectory(workingDirectory, fmt.Sprintf("docker-compose up %s",serviceName), w, session)
+							}
+							generated.commands[fmt.Sprintf("stop container %s of %s", serviceName, dockerComposeConfigName)] = func(w common.IWriter, param string, session *common.Session) error {
+								return common.RunLinuxCommandWithDirectory(workingDirectory, fmt.Sprintf("docker-compose stop %s",serviceName), w, session)
+							}
+							generated.commands[fmt.Sprintf("restart container %s of %s", serviceName, dockerComposeConfigName)] = func(w common.IWriter, param string, session *common.Session) error {
+								return common.RunLinuxCommandWithDirectory(workingDirectory, fmt.Sprintf("docker-compose restart %s",serviceName), w, session)
 							}
-							return common.RunLinuxCommandWithDirectory(workingDirectory, fmt.Sprintf("docker-compose up %s",serviceName), w, forceStop)
-						}
-						newCommands[fmt.Sprintf("stop container %s of %s", serviceName, dockerComposeConfigName)] = func(w common.IWriter, param string, forceStop chan bool) error {
-							return common.RunLinuxCommandWithDirectory(workingDirectory, fmt.Sprintf("docker-compose stop %s",serviceName), w, forceStop)
-						}
-						newCommands[fmt.Sprintf("restart container %s of %s", serviceName, dockerComposeConfigName)] = func(w common.IWriter, param string, forceStop chan bool) error {
-							return common.RunLinuxCommandWithDirectory(workingDirectory, fmt.Sprintf("docker-compose restart %s",serviceName), w, forceStop)
 						}
-					}
-				}(serviceName)
-			}
-		}(k)
+					}(serviceName)
+				}
+				return nil
+			}, newCommands, newOptions, newChoiceSources)
+		}(k, out[k])
 	}
 	return nil
 }
 
-func (this *CommandCenter) reloadCommandsFromDocker(newCommands map[string]common.CommandHandler) error {
+func (this *CommandCenter) reloadCommandsFromDocker(newCommands map[string]common.CommandHandler, newOptions map[string]CommandOptions, newChoiceSources map[string]ChoiceSource) error {
 	type YamlDocker struct {
 		ContainerName string `yaml:"container name"`
 		FromGitRepo string `yaml:"from git repo"`
@@ -315,6 +494,8 @@ func (this *CommandCenter) reloadCommandsFromDocker(newCommands map[string]commo
 		SupportMySqlDatabases []string `yaml:"support mysql databases"`
 		SupportPhp bool `yaml:"support php"`
 		WorkingDirectory string `yaml:"working directory"`
+		Locks []string `yaml:"locks"`
+		Params []yaml_config.ParamDefinition `yaml:"params"`
 	}
 	data, err := ioutil.ReadFile("config/docker.yml")
 	if err != nil {
@@ -326,68 +507,84 @@ func (this *CommandCenter) reloadCommandsFromDocker(newCommands map[string]commo
 		return err
 	}
 	for k := range out {
-		func(k string) {
-			info := out[k]
-			// get container name
-			containerName := ""
-			if info.ContainerName != "" {
-				containerName = info.ContainerName
-			}
-			if info.FromGitRepo != "" {
-				newCommands[fmt.Sprintf("clone source for %s", k)] = func(w common.IWriter, param string, forceStop chan bool) error {
-					return common.RunLinuxCommand(fmt.Sprintf("cd tmps/repos && git clone %s", info.FromGitRepo), w, forceStop)
-				}
-			}
-			if info.CreateContainerFromDockerRunCommand != "" && info.WorkingDirectory != "" {
-				newCommands[fmt.Sprintf("create container %s", k)] = func(w common.IWriter, param string, forceStop chan bool) error {
-					return common.RunLinuxCommandWithDirectory(info.WorkingDirectory, info.CreateContainerFromDockerRunCommand, w, forceStop)
-				}
-			}
-			if info.CreateContainerFromDockerRunCommand != "" && info.ContainerName != "" {
-				newCommands[fmt.Sprintf("create container %s", k)] = func(w common.IWriter, param string, forceStop chan bool) error {
-					return common.RunLinuxCommandWithDirectory(info.WorkingDirectory, info.CreateContainerFromDockerRunCommand, w, forceStop)
+		func(k string, item YamlDocker) {
+			this.addGeneratedCommands("docker", k, item.Params, func(template *yaml_config.Template, generated *generatedCommands) error {
+				info := item
+				if err := template.RenderValue(&info); err != nil {
+					return err
 				}
-				newCommands[fmt.Sprintf("recreate container %s", k)] = func(w common.IWriter, param string, forceStop chan bool) error {
-					_ = common.RunLinuxCommand(fmt.Sprintf("docker stop %s", info.ContainerName), w, forceStop)
-					_ = common.RunLinuxCommand(fmt.Sprintf("docker rm -f %s", info.ContainerName), w, forceStop)
-					return common.RunLinuxCommandWithDirectory(info.WorkingDirectory, info.CreateContainerFromDockerRunCommand, w, forceStop)
+				// get container name
+				containerName := ""
+				if info.ContainerName != "" {
+					containerName = info.ContainerName
 				}
-			}
-			if containerName != "" {
-				newCommands[fmt.Sprintf("start container %s", k)] = func(w common.IWriter, param string, forceStop chan bool) error {
-					err := common.RunLinuxCommand(fmt.Sprintf("docker start %s", containerName), w, forceStop)
-					if err != nil {
-						return err
+				if info.FromGitRepo != "" {
+					generated.commands[fmt.Sprintf("clone source for %s", k)] = func(w common.IWriter, param string, session *common.Session) error {
+						return common.RunLinuxCommand(fmt.Sprintf("cd tmps/repos && git clone %s", info.FromGitRepo), w, session)
 					}
-					return common.RunLinuxCommand("docker container ps", w, forceStop)
 				}
-				newCommands[fmt.Sprintf("inspect container %s", k)] = func(w common.IWriter, param string, forceStop chan bool) error {
-					return common.RunLinuxCommand(fmt.Sprintf("docker inspect %s", containerName), w, forceStop)
-				}
-				newCommands[fmt.Sprintf("stop container %s", k)] = func(w common.IWriter, param string, forceStop chan bool) error {
-					return common.RunLinuxCommand(fmt.Sprintf("docker stop %s", containerName), w, forceStop)
+				if info.CreateContainerFromDockerRunCommand != "" && info.WorkingDirectory != "" {
+					generated.commands[fmt.Sprintf("create container %s", k)] = func(w common.IWriter, param string, session *common.Session) error {
+						return common.RunLinuxCommandWithDirectory(info.WorkingDirectory, info.CreateContainerFromDockerRunCommand, w, session)
+					}
 				}
-				newCommands[fmt.Sprintf("restart container %s", k)] = func(w common.IWriter, param string, forceStop chan bool) error {
-					return common.RunLinuxCommand(fmt.Sprintf("docker restart %s", containerName), w, forceStop)
+				if info.CreateContainerFromDockerRunCommand != "" && info.ContainerName != "" {
+					generated.commands[fmt.Sprintf("create container %s", k)] = func(w common.IWriter, param string, session *common.Session) error {
+						return common.RunLinuxCommandWithDirectory(info.WorkingDirectory, info.CreateContainerFromDockerRunCommand, w, session)
+					}
+					generated.commands[fmt.Sprintf("recreate container %s", k)] = func(w common.IWriter, param string, session *common.Session) error {
+						_ = common.RunLinuxCommand(fmt.Sprintf("docker stop %s", info.ContainerName), w, session)
+						_ = common.RunLinuxCommand(fmt.Sprintf("docker rm -f %s", info.ContainerName), w, session)
+						return common.RunLinuxCommandWithDirectory(info.WorkingDirectory, info.CreateContainerFromDockerRunCommand, w, session)
+					}
 				}
-				newCommands[fmt.Sprintf("view logs container %s", k)] = func(w common.IWriter, param string, forceStop chan bool) error {
-					return common.RunLinuxCommand(fmt.Sprintf("docker logs --tail 10000 -f %s", containerName), w, forceStop)
+				if containerName != "" {
+					generated.commands[fmt.Sprintf("start container %s", k)] = func(w common.IWriter, param string, session *common.Session) error {
+						err := common.RunLinuxCommand(fmt.Sprintf("docker start %s", containerName), w, session)
+						if err != nil {
+							return err
+						}
+						return common.RunLinuxCommand("docker container ps", w, session)
+					}
+					generated.commands[fmt.Sprintf("inspect container %s", k)] = func(w common.IWriter, param string, session *common.Session) error {
+						return common.RunLinuxCommand(fmt.Sprintf("docker inspect %s", containerName), w, session)
+					}
+					generated.commands[fmt.Sprintf("stop container %s", k)] = func(w common.IWriter, param string, session *common.Session) error {
+						return common.RunLinuxCommand(fmt.Sprintf("docker stop %s", containerName), w, session)
+					}
+					generated.commands[fmt.Sprintf("restart container %s", k)] = func(w common.IWriter, param string, session *common.Session) error {
+						return common.RunLinuxCommand(fmt.Sprintf("docker restart %s", containerName), w, session)
+					}
+					generated.commands[fmt.Sprintf("view logs container %s", k)] = func(w common.IWriter, param string, session *common.Session) error {
+						return common.RunLinuxCommand(fmt.Sprintf("docker logs --tail 10000 -f %s", containerName), w, session)
+					}
+					generated.commands[fmt.Sprintf("remove container %s", k)] = func(w common.IWriter, param string, session *common.Session) error {
+						return common.RunLinuxCommand(fmt.Sprintf("docker stop %s && docker rm %s", containerName, containerName), w, session)
+					}
 				}
-				newCommands[fmt.Sprintf("remove container %s", k)] = func(w common.IWriter, param string, forceStop chan bool) error {
-					return common.RunLinuxCommand(fmt.Sprintf("docker stop %s && docker rm %s", containerName, containerName), w, forceStop)
+				// every command changing the container holds its locks, watching logs or inspecting does not
+				if len(info.Locks) > 0 {
+					for _, name := range []string{"clone source for %s", "create container %s", "recreate container %s", "start container %s", "stop container %s", "restart container %s", "remove container %s"} {
+						name = fmt.Sprintf(name, k)
+						if _, ok := generated.commands[name]; ok {
+							generated.options[name] = CommandOptions{Locks: info.Locks}
+						}
+					}
 				}
-			}
-		}(k)
+				return nil
+			}, newCommands, newOptions, newChoiceSources)
+		}(k, out[k])
 	}
 	return nil
 }
 
-func (this *CommandCenter) reloadCommandsFromGitRepos(newCommands map[string]common.CommandHandler) error {
+func (this *CommandCenter) reloadCommandsFromGitRepos(newCommands map[string]common.CommandHandler, newOptions map[string]CommandOptions, newChoiceSources map[string]ChoiceSource) error {
 	type Item struct {
 		Repo string `yaml:"repo"`
 		WorkingDirectoryFromConfig string `yaml:"working directory from config"`
 		WorkingDirectory string `yaml:"working directory"`
 		Branch string `yaml:"branch"`
+		Params []yaml_config.ParamDefinition `yaml:"params"`
 	}
 	data, err := ioutil.ReadFile("config/git-repo.yml")
 	if err != nil {
@@ -399,33 +596,54 @@ func (this *CommandCenter) reloadCommandsFromGitRepos(newCommands map[string]com
 		return err
 	}
 	for name := range items {
-		func(name string) {
-			item := items[name]
-			workingDirectory := item.WorkingDirectory
-			if item.Repo != "" && item.WorkingDirectoryFromConfig != "" {
-				var err error
-				workingDirectory, err = this.config.GetStringByKey(item.WorkingDirectoryFromConfig)
-				common.PanicOnError(err)
-			}
-			if item.Repo != "" && workingDirectory != "" {
-				newCommands[fmt.Sprintf("git clone %s", name)] = func(w common.IWriter, param string, forceStop chan bool) error {
-					return common.RunLinuxCommandWithDirectory(workingDirectory, fmt.Sprintf("git clone %s", item.Repo), w, forceStop)
+		func(name string, raw Item) {
+			this.addGeneratedCommands("git repo", name, raw.Params, func(template *yaml_config.Template, generated *generatedCommands) error {
+				item := raw
+				if err := template.RenderValue(&item); err != nil {
+					return err
 				}
-			}
-			if item.Repo != "" && workingDirectory != "" && item.Branch != "" {
-				pieces := strings.Split(item.Repo, "/")
-				pieces = strings.Split(pieces[1], ".")
-				repo := pieces[0]
-				newCommands[fmt.Sprintf("git pull latest code for %s", name)] = func(w common.IWriter, param string, forceStop chan bool) error {
-					return common.RunLinuxCommandWithDirectory(workingDirectory, fmt.Sprintf("cd %s && git checkout %s && git pull", repo, item.Branch), w, forceStop)
+				workingDirectory := item.WorkingDirectory
+				if item.Repo != "" && item.WorkingDirectoryFromConfig != "" {
+					var err error
+					workingDirectory, err = this.config.GetStringByKey(item.WorkingDirectoryFromConfig)
+					if err != nil {
+						return err
+					}
 				}
-			}
-		}(name)
+				if item.Repo != "" && workingDirectory != "" {
+					generated.commands[fmt.Sprintf("git clone %s", name)] = func(w common.IWriter, param string, session *common.Session) error {
+						return common.RunLinuxCommandWithDirectory(workingDirectory, fmt.Sprintf("git clone %s", item.Repo), w, session)
+					}
+				}
+				if item.Repo != "" && workingDirectory != "" && item.Branch != "" {
+					pieces := strings.Split(item.Repo, "/")
+					pieces = strings.Split(pieces[1], ".")
+					repo := pieces[0]
+					generated.commands[fmt.Sprintf("git pull latest code for %s", name)] = func(w common.IWriter, param string, session *common.Session) error {
+						return common.RunLinuxCommandWithDirectory(workingDirectory, fmt.Sprintf("cd %s && git checkout %s && git pull", repo, item.Branch), w, session)
+					}
+				}
+				if item.Repo != "" && workingDirectory != "" {
+					repo := strings.TrimSuffix(filepath.Base(item.Repo), ".git")
+					generated.choiceSources[fmt.Sprintf("git branches of %s", name)] = func(session *common.Session) ([]string, error) {
+						output := ""
+						err := common.RunLinuxCommandWithDirectory(filepath.Join(workingDirectory, repo), "git for-each-ref --format='%(refname:lstrip=2)' refs/heads refs/remotes", func(text string) {
+							output += text
+						}, session)
+						if err != nil {
+							return nil, fmt.Errorf("%s: %s", err, strings.TrimSpace(output))
+						}
+						return splitChoices(output, 0), nil
+					}
+				}
+				return nil
+			}, newCommands, newOptions, newChoiceSources)
+		}(name, items[name])
 	}
 	return nil
 }
 
-func (this *CommandCenter) reloadCommandsFromMysql(newCommands map[string]common.CommandHandler) error {
+func (this *CommandCenter) reloadCommandsFromMysql(newCommands map[string]common.CommandHandler, newOptions map[string]CommandOptions, newChoiceSources map[string]ChoiceSource) error {
 	data, err := ioutil.ReadFile("config/mysql.yml")
 	if err != nil {
 		return err
@@ -436,26 +654,47 @@ func (this *CommandCenter) reloadCommandsFromMysql(newCommands map[string]common
 		return err
 	}
 	for name := range items {
-		func(name string) {
-			item := items[name]
-			if item.CanExport() {
-				newCommands[fmt.Sprintf("export database %s", name)] = func(w common.IWriter, param string, forceStop chan bool) error {
-					return item.Export(func(key string) (item *yaml_config.SshItem, e error) {
+		func(name string, raw yaml_config.MysqlItem) {
+			this.addGeneratedCommands("mysql", name, raw.Params, func(template *yaml_config.Template, generated *generatedCommands) error {
+				item := raw
+				if err := template.RenderValue(&item); err != nil {
+					return err
+				}
+				if item.CanExport() {
+					generated.commands[fmt.Sprintf("export database %s", name)] = func(w common.IWriter, param string, session *common.Session) error {
+						return item.Export(func(key string) (item *yaml_config.SshItem, e error) {
+							return GetSshItemByKey(key)
+						},w, session)
+					}
+					generated.options[fmt.Sprintf("export database %s", name)] = CommandOptions{Locks: item.Locks}
+				}
+				if item.CanImport() {
+					generated.commands[fmt.Sprintf("import database %s", name)] = func(w common.IWriter, param string, session *common.Session) error {
+						return item.Import(w, session)
+					}
+					generated.options[fmt.Sprintf("import database %s", name)] = CommandOptions{Locks: item.Locks}
+				}
+				generated.commands[fmt.Sprintf("view tables of %s", name)] = func(w common.IWriter, param string, session *common.Session) error {
+					return item.RunSql(func(key string) (item *yaml_config.SshItem, e error) {
 						return GetSshItemByKey(key)
-					},w, forceStop)
+					},"SHOW TABLES;", w, session)
 				}
-			}
-			if item.CanImport() {
-				newCommands[fmt.Sprintf("import database %s", name)] = func(w common.IWriter, param string, forceStop chan bool) error {
-					return item.Import(w, forceStop)
+				generated.choiceSources[fmt.Sprintf("mysql tables of %s", name)] = func(session *common.Session) ([]string, error) {
+					output := ""
+					err := item.RunSql(func(key string) (item *yaml_config.SshItem, e error) {
+						return GetSshItemByKey(key)
+					}, "SHOW TABLES;", func(text string) {
+						output += text
+					}, session)
+					if err != nil {
+						return nil, fmt.Errorf("%s: %s", err, strings.TrimSpace(output))
+					}
+					// mysql prints the column name first
+					return splitChoices(output, 1), nil
 				}
-			}
-			newCommands[fmt.Sprintf("view tables of %s", name)] = func(w common.IWriter, param string, forceStop chan bool) error {
-				return item.RunSql(func(key string) (item *yaml_config.SshItem, e error) {
-					return GetSshItemByKey(key)
-				},"SHOW TABLES;", w, forceStop)
-			}
-		}(name)
+				return nil
+			}, newCommands, newOptions, newChoiceSources)
+		}(name, items[name])
 	}
 	return nil
 }
@@ -466,21 +705,54 @@ func (this *CommandCenter) Reload() (err error) {
 			err = r.(error)
 		}
 	}()
-	this.time = time.Now().Unix()
 	newCommands := map[string]common.CommandHandler{}
-	common.PanicOnError(this.reloadCommandsFromCurl(newCommands))
-	common.PanicOnError(this.reloadCommandsFromIntegrationTest(newCommands))
-	common.PanicOnError(this.reloadCommandsFromFormulaConfig(newCommands))
-	common.PanicOnError(this.reloadCommandsFromCodeFiles(newCommands))
-	common.PanicOnError(this.reloadCommandsFromDocker(newCommands))
-	common.PanicOnError(this.reloadCommandsFromDockerCompose(newCommands))
-	common.PanicOnError(this.reloadCommandsFromGitRepos(newCommands))
-	common.PanicOnError(this.reloadCommandsFromMysql(newCommands))
+	newOptions := map[string]CommandOptions{}
+	newChoiceSources := map[string]ChoiceSource{}
+	common.PanicOnError(this.reloadCommandsFromCurl(newCommands, newOptions))
+	common.PanicOnError(this.reloadCommandsFromIntegrationTest(newCommands, newOptions))
+	common.PanicOnError(this.reloadCommandsFromFormulaConfig(newCommands, newOptions))
+	common.PanicOnError(this.reloadCommandsFromCodeFiles(newCommands, newOptions))
+	common.PanicOnError(this.reloadCommandsFromWorkflows(newCommands, newOptions))
+
+	// docker, git and mysql commands are generated, they share a configurable default timeout
+	generatedCommands := map[string]common.CommandHandler{}
+	generatedOptions := map[string]CommandOptions{}
+	common.PanicOnError(this.reloadCommandsFromDocker(generatedCommands, generatedOptions, newChoiceSources))
+	common.PanicOnError(this.reloadCommandsFromDockerCompose(generatedCommands, generatedOptions, newChoiceSources))
+	common.PanicOnError(this.reloadCommandsFromGitRepos(generatedCommands, generatedOptions, newChoiceSources))
+	common.PanicOnError(this.reloadCommandsFromMysql(generatedCommands, generatedOptions, newChoiceSources))
+	generatedTimeout := this.getDurationFromConfig("default timeout for docker, git and mysql commands")
+	for k := range generatedCommands {
+		options := generatedOptions[k]
+		options.Timeout = generatedTimeout
+		newCommands[k] = generatedCommands[k]
+		newOptions[k] = options
+	}
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	this.time = time.Now().Unix()
 	this.commands = newCommands
+	this.options = newOptions
+	this.choiceSources = newChoiceSources
 	return nil
 }
 
+func (this *CommandCenter) getDurationFromConfig(key string) time.Duration {
+	str, err := this.config.GetStringByKey(key)
+	if err != nil {
+		return 0
+	}
+	duration, err := common.ParseDuration(str)
+	if err != nil {
+		fmt.Printf("config %s: %s\n", key, err)
+		return 0
+	}
+	return duration
+}
+
 func (this *CommandCenter) GetCommandNames() ([]string, error) {
+	this.mutex.RLock()
+	defer this.mutex.RUnlock()
 	res := make([]string, 0, len(this.commands))
 	for k := range this.commands {
 		res = append(res, k)
@@ -489,10 +761,23 @@ func (this *CommandCenter) GetCommandNames() ([]string, error) {
 }
 
 func (this *CommandCenter) GetCommandInfo(commandName string) (common.CommandHandler, error) {
+	this.mutex.RLock()
+	defer this.mutex.RUnlock()
 	var val common.CommandHandler
 	ok := true
 	if val, ok = this.commands[commandName]; !ok {
 		return nil, fmt.Errorf("key %s not exists", commandName)
 	}
 	return val, nil
+}
+
+// GetCommandOptions returns how a command should be run, a zero timeout means forever
+func (this *CommandCenter) GetCommandOptions(commandName string) CommandOptions {
+	this.mutex.RLock()
+	options := this.options[commandName]
+	this.mutex.RUnlock()
+	if options.Timeout <= 0 {
+		options.Timeout = this.getDurationFromConfig("default command timeout")
+	}
+	return options
 }
\ No newline at end of file
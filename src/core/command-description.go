@@ -0,0 +1,102 @@
+package core
+
+import (
+	"common"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+	"yaml_config"
+)
+
+const choicesTimeout = 10 * time.Second
+
+// ChoiceSource computes the choices of a param, it is registered under the name used in "choices from"
+type ChoiceSource func(session *common.Session) ([]string, error)
+
+// ParamDescription is a declared param with its computed choices, or why they could not be computed
+type ParamDescription struct {
+	yaml_config.ParamDefinition
+	ChoicesError string `json:"choices_error,omitempty"`
+}
+
+// CommandDescription tells the ui which form to show before running a command
+type CommandDescription struct {
+	Command string             `json:"command"`
+	Params  []ParamDescription `json:"params"`
+}
+
+// Describe returns the params of a command, the choices of a param with "choices from" are computed now
+func (this *CommandCenter) Describe(command string) (*CommandDescription, error) {
+	if _, err := this.GetCommandInfo(command); err != nil {
+		return nil, err
+	}
+	description := &CommandDescription{Command: command, Params: []ParamDescription{}}
+	for _, definition := range this.GetCommandOptions(command).Params {
+		param := ParamDescription{ParamDefinition: definition}
+		if definition.ChoicesFrom != "" {
+			choices, err := this.getChoices(definition.ChoicesFrom)
+			if err != nil {
+				param.ChoicesError = err.Error()
+			}
+			param.Choices = append(param.Choices, choices...)
+		}
+		description.Params = append(description.Params, param)
+	}
+	return description, nil
+}
+
+func (this *CommandCenter) getChoices(from string) ([]string, error) {
+	this.mutex.RLock()
+	source, ok := this.choiceSources[from]
+	this.mutex.RUnlock()
+	if !ok && strings.HasPrefix(from, "output of ") {
+		source = commandOutputChoices(strings.TrimPrefix(from, "output of "))
+		ok = true
+	}
+	if !ok {
+		return nil, fmt.Errorf("unknown choices source %s", from)
+	}
+	stop, timedOut, release := common.WithTimeout(nil, choicesTimeout)
+	defer release()
+	choices, err := source(&common.Session{ForceStop: stop})
+	if timedOut() {
+		return nil, fmt.Errorf("computing choices from %s took more than %s", from, choicesTimeout)
+	}
+	return choices, err
+}
+
+// commandOutputChoices makes every non empty line printed by a linux command a choice
+func commandOutputChoices(command string) ChoiceSource {
+	return func(session *common.Session) ([]string, error) {
+		output := ""
+		err := common.RunLinuxCommand(command, func(text string) {
+			output += text
+		}, session)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", err, strings.TrimSpace(output))
+		}
+		return splitChoices(output, 0), nil
+	}
+}
+
+// splitChoices returns the trimmed non empty lines of text after skipping the first skip lines, e.g. a header
+func splitChoices(text string, skip int) []string {
+	choices := []string{}
+	for k, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if k < skip || line == "" {
+			continue
+		}
+		choices = append(choices, line)
+	}
+	return choices
+}
+
+func staticChoices(choices []string) ChoiceSource {
+	sorted := append([]string{}, choices...)
+	sort.Strings(sorted)
+	return func(session *common.Session) ([]string, error) {
+		return sorted, nil
+	}
+}
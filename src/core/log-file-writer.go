@@ -0,0 +1,118 @@
+package core
+
+import (
+	"os"
+	"sync"
+)
+
+// maxQueuedLogFileBytes is how much output of a process may wait for its log file before its producer waits
+const maxQueuedLogFileBytes = 1 << 20
+
+// logFileWriter writes the output of a process to its log file from a goroutine of its own. The registry only queues
+// the output under its mutex, so a slow disk holds up neither the other processes, the watchers nor the status,
+// only the producer of the process once maxQueuedLogFileBytes are waiting
+type logFileWriter struct {
+	mutex sync.Mutex
+	cond  *sync.Cond
+	file  *os.File
+	queue []string
+	// queued is how many bytes wait in queue, written the offset in the whole output the file has reached
+	queued  int
+	written int64
+	closed  bool
+	err     error
+	done    chan bool
+}
+
+// newLogFileWriter starts writing to file, which gets the output of the process from offset on
+func newLogFileWriter(file *os.File, offset int64) *logFileWriter {
+	writer := &logFileWriter{
+		file:    file,
+		written: offset,
+		done:    make(chan bool),
+	}
+	writer.cond = sync.NewCond(&writer.mutex)
+	go writer.run()
+	return writer
+}
+
+func (this *logFileWriter) run() {
+	defer close(this.done)
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	for {
+		for len(this.queue) == 0 && !this.closed {
+			this.cond.Wait()
+		}
+		if len(this.queue) == 0 {
+			return
+		}
+		queue := this.queue
+		this.queue = nil
+		this.mutex.Unlock()
+		var written int64
+		var err error
+		for _, text := range queue {
+			if _, err = this.file.WriteString(text); err != nil {
+				break
+			}
+			written += int64(len(text))
+		}
+		this.mutex.Lock()
+		this.written += written
+		for _, text := range queue {
+			this.queued -= len(text)
+		}
+		this.cond.Broadcast()
+		if err != nil {
+			this.err = err
+			this.queue = nil
+			this.queued = 0
+			return
+		}
+	}
+}
+
+// Queue adds text to what is to be written, it returns the error that made the writer give up.
+// Text queued once the writer is closed is left out
+func (this *logFileWriter) Queue(text string) error {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	if this.err != nil || this.closed {
+		return this.err
+	}
+	this.queue = append(this.queue, text)
+	this.queued += len(text)
+	this.cond.Broadcast()
+	return nil
+}
+
+// WaitQueue blocks the producer while too much output waits, it must be called outside of the registry mutex
+func (this *logFileWriter) WaitQueue() {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	for this.queued > maxQueuedLogFileBytes && this.err == nil {
+		this.cond.Wait()
+	}
+}
+
+// WaitWritten blocks until the output up to offset is in the file, or the writer has given up
+func (this *logFileWriter) WaitWritten(offset int64) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	for this.written < offset && this.queued > 0 && this.err == nil {
+		this.cond.Wait()
+	}
+}
+
+// Close writes what is still queued and returns the error that made the writer give up, the file stays open
+func (this *logFileWriter) Close() error {
+	this.mutex.Lock()
+	this.closed = true
+	this.cond.Broadcast()
+	this.mutex.Unlock()
+	<-this.done
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	return this.err
+}
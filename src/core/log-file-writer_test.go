@@ -0,0 +1,66 @@
+package core
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestLogFileWriter(t *testing.T) {
+	file, err := ioutil.TempFile("", "log-file-writer-*.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+	writer := newLogFileWriter(file, 0)
+	var want strings.Builder
+	for k := 0; k < 1000; k++ {
+		text := strings.Repeat(string(rune('a'+k%26)), k)
+		if err := writer.Queue(text); err != nil {
+			t.Fatal(err)
+		}
+		want.WriteString(text)
+		writer.WaitQueue()
+	}
+	writer.WaitWritten(int64(want.Len()))
+	got, err := ioutil.ReadFile(file.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != want.String() {
+		t.Fatalf("the file has %d bytes, want %d in the same order", len(got), want.Len())
+	}
+	// nothing more is queued, waiting for more must not block
+	writer.WaitWritten(int64(want.Len()) + 10)
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Queue("late"); err != nil {
+		t.Fatal(err)
+	}
+	got, _ = ioutil.ReadFile(file.Name())
+	if len(got) != want.Len() {
+		t.Errorf("text queued after Close has been written")
+	}
+}
+
+func TestLogFileWriterGivesUp(t *testing.T) {
+	file, err := ioutil.TempFile("", "log-file-writer-*.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	file.Close()
+	// writing to a closed file fails, the writer gives up instead of blocking the producer
+	writer := newLogFileWriter(file, 5)
+	_ = writer.Queue("hello")
+	writer.WaitWritten(10)
+	if err := writer.Close(); err == nil {
+		t.Fatal("Close returned no error for a failed write")
+	}
+	if err := writer.Queue("again"); err == nil {
+		t.Fatal("Queue accepted text after the writer gave up")
+	}
+}
@@ -0,0 +1,128 @@
+package core
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LogStore keeps the whole output of every process in its own file under a directory,
+// files are removed once they are older than maxAge or when the directory grows over maxTotalSize
+type LogStore struct {
+	mutex        sync.Mutex
+	directory    string
+	maxAge       time.Duration
+	maxTotalSize int64
+	open         map[string]bool
+}
+
+func NewLogStore(directory string, maxAge time.Duration, maxTotalSize int64) (*LogStore, error) {
+	err := os.MkdirAll(directory, 0755)
+	if err != nil {
+		return nil, err
+	}
+	return &LogStore{
+		directory:    directory,
+		maxAge:       maxAge,
+		maxTotalSize: maxTotalSize,
+		open:         map[string]bool{},
+	}, nil
+}
+
+// Create opens a new log file for a process, the name contains the queue time because process ids start over after a restart
+func (this *LogStore) Create(processId int, queuedAt time.Time) (*os.File, string, error) {
+	name := fmt.Sprintf("%s-%d.log", queuedAt.Format("20060102-150405"), processId)
+	f, err := os.OpenFile(filepath.Join(this.directory, name), os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, "", err
+	}
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	this.open[name] = true
+	return f, name, nil
+}
+
+// Close closes a log file returned by Create, it becomes subject to retention from then on
+func (this *LogStore) Close(f *os.File, name string) {
+	_ = f.Close()
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	delete(this.open, name)
+}
+
+// Path returns the full path of a log file, name must be a plain file name as returned by Create
+func (this *LogStore) Path(name string) (string, error) {
+	if name == "" || name != filepath.Base(name) || !strings.HasSuffix(name, ".log") {
+		return "", fmt.Errorf("invalid log file %s", name)
+	}
+	return filepath.Join(this.directory, name), nil
+}
+
+// Read returns at most length bytes of a log file from offset on, all of length is allocated so callers keep it bounded
+func (this *LogStore) Read(name string, offset int64, length int64) (string, error) {
+	path, err := this.Path(name)
+	if err != nil {
+		return "", err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	b := make([]byte, length)
+	n, err := f.ReadAt(b, offset)
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+	return string(b[:n]), nil
+}
+
+// Clean removes the log files that are too old, then the oldest ones until the directory fits in maxTotalSize,
+// files that are still being written are kept
+func (this *LogStore) Clean() error {
+	files, err := ioutil.ReadDir(this.directory)
+	if err != nil {
+		return err
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].ModTime().Before(files[j].ModTime())
+	})
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	var kept []os.FileInfo
+	var totalSize int64
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), ".log") {
+			continue
+		}
+		if !this.open[file.Name()] && this.maxAge > 0 && time.Since(file.ModTime()) > this.maxAge {
+			err := os.Remove(filepath.Join(this.directory, file.Name()))
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		kept = append(kept, file)
+		totalSize += file.Size()
+	}
+	for _, file := range kept {
+		if this.maxTotalSize <= 0 || totalSize <= this.maxTotalSize {
+			break
+		}
+		if this.open[file.Name()] {
+			continue
+		}
+		err := os.Remove(filepath.Join(this.directory, file.Name()))
+		if err != nil {
+			return err
+		}
+		totalSize -= file.Size()
+	}
+	return nil
+}
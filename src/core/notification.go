@@ -0,0 +1,235 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/smtp"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"yaml_config"
+)
+
+const notificationTimeout = 10 * time.Second
+
+// Notification is what a webhook gets as json when a finished run matches one of its rules,
+// Url links back to the log of the run
+type Notification struct {
+	Rule    string  `json:"rule"`
+	Summary string  `json:"summary"`
+	Process Process `json:"process"`
+	Url     string  `json:"url"`
+	LogTail string  `json:"log_tail"`
+}
+
+// Notifier tells about finished runs as the rules of notification.yml say, it posts to webhooks,
+// shows desktop notifications through notify-send and sends emails through the smtp server
+type Notifier struct {
+	mutex         sync.Mutex
+	registry      *ProcessRegistry
+	commandCenter *CommandCenter
+	config        yaml_config.IConfig
+	notification  yaml_config.NotificationConfig
+}
+
+func NewNotifier(registry *ProcessRegistry, commandCenter *CommandCenter, config yaml_config.IConfig) *Notifier {
+	return &Notifier{
+		registry:      registry,
+		commandCenter: commandCenter,
+		config:        config,
+	}
+}
+
+// Reload reads the rules again from path, config/notification.yml for the server, a missing file notifies of nothing,
+// an invalid rule is left out and reported
+func (this *Notifier) Reload(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	notification := yaml_config.NotificationConfig{}
+	err = yaml.Unmarshal(data, &notification)
+	if err != nil {
+		return err
+	}
+	err = yaml_config.NewTemplate(this.config).RenderValue(&notification)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	for name, rule := range notification.Rules {
+		if err := rule.Check(notification.Smtp); err != nil {
+			fmt.Printf("notification %s: %s\n", name, err)
+			delete(notification.Rules, name)
+		}
+	}
+	if notification.BaseUrl == "" {
+		port, _ := this.config.GetStringByKey("server port")
+		notification.BaseUrl = "http://localhost:" + port
+	}
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	this.notification = notification
+	return nil
+}
+
+// Start notifies of every run finishing from now on
+func (this *Notifier) Start() {
+	_, subscription := this.registry.SubscribeEvents(context.Background(), false, nil, 0)
+	go func() {
+		for range subscription.Ready() {
+			for _, event := range subscription.Take() {
+				if event.Type == EventProcessFinished {
+					this.notify(*event.Process)
+				}
+			}
+		}
+	}()
+}
+
+// notificationEnding tells how a run ended in the words of the "on" of a rule
+func notificationEnding(state string) string {
+	switch state {
+	case ProcessStateSucceeded:
+		return yaml_config.NotifyOnSuccess
+	case ProcessStateCancelled:
+		return yaml_config.NotifyOnCancelled
+	}
+	return yaml_config.NotifyOnFailure
+}
+
+func (this *Notifier) notify(process Process) {
+	this.mutex.Lock()
+	notification := this.notification
+	this.mutex.Unlock()
+	if len(notification.Rules) == 0 {
+		return
+	}
+	names := make([]string, 0, len(notification.Rules))
+	for name := range notification.Rules {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	ending := notificationEnding(process.State)
+	tags := this.commandCenter.GetCommandOptions(process.Command).Tags
+	duration := time.Duration(process.Duration) * time.Millisecond
+	fullCommand := process.Command
+	if process.Param != "" {
+		fullCommand += ":" + process.Param
+	}
+	summary := fmt.Sprintf("%s %s after %s", fullCommand, process.State, duration.Round(time.Second))
+	if process.Error != "" {
+		summary += ": " + process.Error
+	}
+	for _, name := range names {
+		rule := notification.Rules[name]
+		if !rule.Matches(process.Command, tags, ending, duration) {
+			continue
+		}
+		message := Notification{
+			Rule:    name,
+			Summary: summary,
+			Process: process,
+			Url:     fmt.Sprintf("%s/download-log?process_id=%d", strings.TrimRight(notification.BaseUrl, "/"), process.Id),
+			LogTail: this.registry.GetLogTail(process.Id, rule.GetLogLines()),
+		}
+		// a slow webhook or mail server holds up neither the other rules nor the next runs
+		go this.send(rule, notification.Smtp, message)
+	}
+}
+
+func (this *Notifier) send(rule yaml_config.NotificationRule, smtpConfig *yaml_config.NotificationSmtp, notification Notification) {
+	if rule.Webhook != nil {
+		if err := postWebhook(rule.Webhook, notification); err != nil {
+			fmt.Printf("notification %s: webhook: %s\n", notification.Rule, err)
+		}
+	}
+	if rule.Desktop {
+		if err := notifyDesktop(notification); err != nil {
+			fmt.Printf("notification %s: desktop: %s\n", notification.Rule, err)
+		}
+	}
+	if rule.Email != nil {
+		if err := sendEmail(smtpConfig, rule.Email, notification); err != nil {
+			fmt.Printf("notification %s: email: %s\n", notification.Rule, err)
+		}
+	}
+}
+
+func postWebhook(webhook *yaml_config.NotificationWebhook, notification Notification) error {
+	body, err := json.Marshal(notification)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", webhook.Url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range webhook.Headers {
+		req.Header.Set(k, v)
+	}
+	client := &http.Client{Timeout: notificationTimeout}
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode >= 300 {
+		return fmt.Errorf("%s answered %s", webhook.Url, res.Status)
+	}
+	return nil
+}
+
+func notifyDesktop(notification Notification) error {
+	urgency := "normal"
+	if notification.Process.State != ProcessStateSucceeded {
+		urgency = "critical"
+	}
+	text := notification.Url
+	if notification.LogTail != "" {
+		text = notification.LogTail + "\n" + text
+	}
+	output, err := exec.Command("notify-send", "--urgency", urgency, "--app-name", "automation notebook",
+		notification.Summary, text).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+func sendEmail(smtpConfig *yaml_config.NotificationSmtp, email *yaml_config.NotificationEmail, notification Notification) error {
+	from := smtpConfig.From
+	if from == "" {
+		from = "automation-notebook@localhost"
+	}
+	subject := email.Subject
+	if subject == "" {
+		subject = "[automation notebook] " + notification.Summary
+	}
+	message := &bytes.Buffer{}
+	fmt.Fprintf(message, "From: %s\r\n", from)
+	fmt.Fprintf(message, "To: %s\r\n", strings.Join(email.To, ", "))
+	fmt.Fprintf(message, "Subject: %s\r\n", strings.NewReplacer("\r", " ", "\n", " ").Replace(subject))
+	fmt.Fprintf(message, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	message.WriteString("MIME-Version: 1.0\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n")
+	body := notification.Summary + "\n" + notification.Url + "\n\n" + notification.LogTail + "\n"
+	message.WriteString(strings.Replace(body, "\n", "\r\n", -1))
+	var auth smtp.Auth
+	if smtpConfig.Username != "" {
+		host, _, err := net.SplitHostPort(smtpConfig.Address)
+		if err != nil {
+			return err
+		}
+		auth = smtp.PlainAuth("", smtpConfig.Username, smtpConfig.Password, host)
+	}
+	return smtp.SendMail(smtpConfig.Address, auth, from, email.To, message.Bytes())
+}
@@ -0,0 +1,265 @@
+package core
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+	"yaml_config"
+)
+
+// startTestNotifier starts a notifier with the rules of yml for a registry in which deploy is tagged prod
+func startTestNotifier(t *testing.T, yml string) *ProcessRegistry {
+	path := filepath.Join(t.TempDir(), "notification.yml")
+	if err := ioutil.WriteFile(path, []byte(yml), 0644); err != nil {
+		t.Fatal(err)
+	}
+	config, err := yaml_config.NewConfig(map[string]string{"server port": "1234"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	registry := NewProcessRegistry(1<<20, nil)
+	commandCenter := NewCommandCenter(config, nil, nil)
+	commandCenter.options = map[string]CommandOptions{"deploy": {Tags: []string{"prod"}}}
+	notifier := NewNotifier(registry, commandCenter, config)
+	if err := notifier.Reload(path); err != nil {
+		t.Fatal(err)
+	}
+	notifier.Start()
+	return registry
+}
+
+// runTestProcess runs command in registry, it writes output then ends with err
+func runTestProcess(registry *ProcessRegistry, command string, output string, err error) int {
+	processId, _ := registry.Queue(ProcessOrigin{}, command, "", "tester", false)
+	registry.MarkRunning(processId)
+	registry.Write(processId, output)
+	registry.Finish(processId, err)
+	return processId
+}
+
+func TestNotifierPostsWebhook(t *testing.T) {
+	requests := make(chan *http.Request, 10)
+	bodies := make(chan []byte, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		requests <- r
+		bodies <- body
+	}))
+	defer server.Close()
+	registry := startTestNotifier(t, `
+base url: http://notebook.test/
+rules:
+  deploys:
+    tags: [prod]
+    log lines: 2
+    webhook:
+      url: `+server.URL+`/hook
+      headers:
+        X-Token: secret
+`)
+	processId := runTestProcess(registry, "deploy", "one\ntwo\nthree\n", errors.New("it broke"))
+	var request *http.Request
+	select {
+	case request = <-requests:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the webhook has not been called")
+	}
+	body := <-bodies
+	if request.Method != http.MethodPost || request.URL.Path != "/hook" {
+		t.Errorf("got %s %s, want POST /hook", request.Method, request.URL.Path)
+	}
+	if request.Header.Get("Content-Type") != "application/json" || request.Header.Get("X-Token") != "secret" {
+		t.Errorf("got headers %v", request.Header)
+	}
+	notification := Notification{}
+	if err := json.Unmarshal(body, &notification); err != nil {
+		t.Fatalf("the payload is not a notification: %s: %s", err, body)
+	}
+	if notification.Rule != "deploys" {
+		t.Errorf("rule is %s, want deploys", notification.Rule)
+	}
+	if !strings.HasPrefix(notification.Summary, "deploy failed after ") || !strings.HasSuffix(notification.Summary, ": it broke") {
+		t.Errorf("summary is %s", notification.Summary)
+	}
+	if notification.Url != "http://notebook.test/download-log?process_id=1" {
+		t.Errorf("url is %s", notification.Url)
+	}
+	if notification.LogTail != "two\nthree" {
+		t.Errorf("log tail is %q, want the last 2 lines", notification.LogTail)
+	}
+	if notification.Process.Id != processId || notification.Process.State != ProcessStateFailed {
+		t.Errorf("process is %+v", notification.Process)
+	}
+}
+
+func TestNotifierDefaultsToTheServerUrl(t *testing.T) {
+	bodies := make(chan []byte, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		bodies <- body
+	}))
+	defer server.Close()
+	registry := startTestNotifier(t, `
+rules:
+  everything:
+    on: [success]
+    webhook: `+server.URL+`
+`)
+	runTestProcess(registry, "build", "done\n", nil)
+	select {
+	case body := <-bodies:
+		notification := Notification{}
+		_ = json.Unmarshal(body, &notification)
+		if notification.Url != "http://localhost:1234/download-log?process_id=1" {
+			t.Errorf("url is %s", notification.Url)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the webhook has not been called")
+	}
+}
+
+// fakeSmtpServer accepts one mail and sends what follows DATA to messages
+func fakeSmtpServer(t *testing.T, messages chan<- string) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		reader := bufio.NewReader(conn)
+		reply := func(line string) { _, _ = conn.Write([]byte(line + "\r\n")) }
+		reply("220 localhost fake smtp")
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			verb := strings.ToUpper(strings.Fields(line + " x")[0])
+			switch verb {
+			case "EHLO", "HELO", "MAIL", "RCPT", "RSET", "NOOP":
+				reply("250 OK")
+			case "DATA":
+				reply("354 go on")
+				var message strings.Builder
+				for {
+					line, err := reader.ReadString('\n')
+					if err != nil {
+						return
+					}
+					if line == ".\r\n" {
+						break
+					}
+					message.WriteString(line)
+				}
+				messages <- message.String()
+				reply("250 queued")
+			case "QUIT":
+				reply("221 bye")
+				return
+			default:
+				reply("502 not implemented")
+			}
+		}
+	}()
+	return listener.Addr().String()
+}
+
+func TestNotifierSendsEmail(t *testing.T) {
+	messages := make(chan string, 1)
+	address := fakeSmtpServer(t, messages)
+	registry := startTestNotifier(t, `
+base url: http://notebook.test
+smtp:
+  address: `+address+`
+  from: notebook@example.com
+rules:
+  failures:
+    commands: [dep*]
+    email:
+      to: [ops@example.com, dev@example.com]
+`)
+	runTestProcess(registry, "deploy", "starting\nfailed badly\n", errors.New("exit status 3"))
+	var message string
+	select {
+	case message = <-messages:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no mail has been sent")
+	}
+	parts := strings.SplitN(message, "\r\n\r\n", 2)
+	if len(parts) != 2 {
+		t.Fatalf("the mail has no body: %q", message)
+	}
+	headers := map[string]string{}
+	for _, line := range strings.Split(parts[0], "\r\n") {
+		k := strings.Index(line, ": ")
+		if k < 0 {
+			t.Fatalf("%q is not a header", line)
+		}
+		headers[line[:k]] = line[k+2:]
+	}
+	want := map[string]string{
+		"From":         "notebook@example.com",
+		"To":           "ops@example.com, dev@example.com",
+		"MIME-Version": "1.0",
+		"Content-Type": "text/plain; charset=utf-8",
+	}
+	for k, v := range want {
+		if headers[k] != v {
+			t.Errorf("header %s is %q, want %q", k, headers[k], v)
+		}
+	}
+	if !strings.HasPrefix(headers["Subject"], "[automation notebook] deploy failed after ") {
+		t.Errorf("subject is %q", headers["Subject"])
+	}
+	if _, err := time.Parse(time.RFC1123Z, headers["Date"]); err != nil {
+		t.Errorf("date: %s", err)
+	}
+	body := parts[1]
+	if !strings.Contains(body, "\r\nhttp://notebook.test/download-log?process_id=1\r\n\r\nstarting\r\nfailed badly\r\n") {
+		t.Errorf("the body has not the url then the log tail: %q", body)
+	}
+	if strings.Contains(strings.Replace(body, "\r\n", "", -1), "\n") {
+		t.Errorf("the body has bare line feeds: %q", body)
+	}
+}
+
+func TestNotifierReloadLeavesOutInvalidRules(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "notification.yml")
+	err := ioutil.WriteFile(path, []byte(`
+rules:
+  fine:
+    desktop: true
+  no target:
+    on: [failure]
+  bad ending:
+    on: [sometimes]
+    desktop: true
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, _ := yaml_config.NewConfig(map[string]string{})
+	notifier := NewNotifier(NewProcessRegistry(1024, nil), NewCommandCenter(config, nil, nil), config)
+	if err := notifier.Reload(path); err != nil {
+		t.Fatal(err)
+	}
+	if len(notifier.notification.Rules) != 1 {
+		t.Errorf("got rules %v, want only fine", notifier.notification.Rules)
+	}
+	if err := notifier.Reload(filepath.Join(t.TempDir(), "missing.yml")); err != nil || len(notifier.notification.Rules) != 0 {
+		t.Errorf("a missing file must notify of nothing, got %v and %v", err, notifier.notification.Rules)
+	}
+}
@@ -0,0 +1,381 @@
+package core
+
+import (
+	"common"
+	"context"
+	"fmt"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	EventLog             = "log"
+	EventLogSkipped      = "log-skipped"
+	EventProcessQueued   = "process-queued"
+	EventProcessStarted  = "process-started"
+	EventProcessFinished = "process-finished"
+	EventProcessRemoved  = "process-removed"
+	EventReload          = "reload"
+)
+
+// maxJournalEvents is how many events are kept for subscribers resuming from an event id
+const maxJournalEvents = 100000
+
+// maxPendingLogBytes is how much output may wait for a slow watcher, anything above is skipped
+const maxPendingLogBytes = 1 << 20
+
+// Event is either a chunk of process output (Log) or a change of the process list (Process),
+// Id grows by one for every event so a client can resume after the last one it has seen.
+// An EventLogSkipped event stands for Skipped bytes from Log.Offset on that a slow watcher did not get
+type Event struct {
+	Id      int64
+	Type    string
+	Time    time.Time
+	Process *Process
+	Log     *LogItem
+	Skipped int64
+}
+
+// journalEntry remembers a published event, the text of a log event is not copied,
+// it is read back from the process log when replayed
+type journalEntry struct {
+	event  Event
+	length int
+}
+
+// Subscription receives the output of every process (all) or of the watched ones, and the process events if lifecycle is set.
+// The registry never waits for it: events pile up until Take is called, and once some output is waiting the output
+// that would take it above maxPendingLogBytes is coalesced into one EventLogSkipped event per process
+type Subscription struct {
+	Id           int
+	all          bool
+	processIds   map[int]bool
+	lifecycle    bool
+	mutex        sync.Mutex
+	pending      []*Event
+	pendingBytes int
+	skips        map[int]*Event
+	ready        chan bool
+}
+
+func newSubscription(id int) *Subscription {
+	return &Subscription{
+		Id:         id,
+		processIds: map[int]bool{},
+		skips:      map[int]*Event{},
+		ready:      make(chan bool, 1),
+	}
+}
+
+func (this *Subscription) wants(event Event) bool {
+	if event.Log != nil {
+		return this.all || this.processIds[event.Log.ProcessId]
+	}
+	return this.lifecycle
+}
+
+func (this *Subscription) push(event Event) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	if event.Log != nil {
+		processId := event.Log.ProcessId
+		size := len(event.Log.Log)
+		// a chunk larger than the limit is still passed to an idle watcher, or it could never get it
+		if this.pendingBytes > 0 && this.pendingBytes+size > maxPendingLogBytes {
+			if skip, ok := this.skips[processId]; ok {
+				skip.Skipped += int64(size)
+				return
+			}
+			skip := &Event{
+				Id:   event.Id,
+				Type: EventLogSkipped,
+				Time: event.Time,
+				Log: &LogItem{
+					ProcessId: processId,
+					Offset:    event.Log.Offset,
+					Time:      event.Time,
+					Stream:    event.Log.Stream,
+				},
+				Skipped: int64(size),
+			}
+			this.skips[processId] = skip
+			this.pending = append(this.pending, skip)
+			this.signal()
+			return
+		}
+		// output coming after a skip must not be merged into it anymore
+		delete(this.skips, processId)
+		this.pendingBytes += size
+	}
+	this.pending = append(this.pending, &event)
+	this.signal()
+}
+
+func (this *Subscription) signal() {
+	select {
+	case this.ready <- true:
+	default:
+	}
+}
+
+// Ready fires when there are events to Take
+func (this *Subscription) Ready() <-chan bool {
+	return this.ready
+}
+
+// Take returns the waiting events in publishing order
+func (this *Subscription) Take() []Event {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	output := make([]Event, 0, len(this.pending))
+	for _, v := range this.pending {
+		output = append(output, *v)
+	}
+	this.pending = nil
+	this.pendingBytes = 0
+	this.skips = map[int]*Event{}
+	return output
+}
+
+// publish numbers an event, adds it to the journal and passes it to the interested subscribers, mutex must be held
+func (this *ProcessRegistry) publish(event Event) {
+	this.eventAutoIncrementId++
+	event.Id = this.eventAutoIncrementId
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	entry := journalEntry{event: event}
+	if event.Log != nil {
+		item := *event.Log
+		entry.length = len(item.Log)
+		item.Log = ""
+		entry.event.Log = &item
+	}
+	this.journal = append(this.journal, entry)
+	if len(this.journal) > maxJournalEvents {
+		this.journal = append([]journalEntry{}, this.journal[maxJournalEvents/10:]...)
+	}
+	for _, subscriber := range this.subscribers {
+		if subscriber.wants(event) {
+			subscriber.push(event)
+		}
+	}
+}
+
+func (this *ProcessRegistry) publishProcess(eventType string, record *processRecord) {
+	process := record.snapshot()
+	this.publish(Event{Type: eventType, Process: &process})
+}
+
+// PublishReload tells the subscribers that the configuration and the command list have been reloaded
+func (this *ProcessRegistry) PublishReload() {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	this.publish(Event{Type: EventReload})
+}
+
+// replay rebuilds a journal entry, a log event whose text is not stored anymore is dropped, mutex must be held
+func (this *ProcessRegistry) replay(entry journalEntry) (Event, bool) {
+	if entry.event.Log == nil {
+		return entry.event, true
+	}
+	item := *entry.event.Log
+	record, ok := this.processes[item.ProcessId]
+	if !ok {
+		return Event{}, false
+	}
+	start := item.Offset
+	end := item.Offset + int64(entry.length)
+	if start < record.logOffset {
+		start = record.logOffset
+	}
+	if end <= start {
+		return Event{}, false
+	}
+	item.Offset = start
+	item.Log = record.log[start-record.logOffset : end-record.logOffset]
+	event := entry.event
+	event.Log = &item
+	return event, true
+}
+
+// subscribe registers a subscription that lives until ctx is done
+func (this *ProcessRegistry) subscribe(ctx context.Context, all bool, processIds []int, lifecycle bool, lastEventId int64) ([]Event, *Subscription) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	this.subscriberAutoIncrementId++
+	subscriber := newSubscription(this.subscriberAutoIncrementId)
+	subscriber.all = all
+	subscriber.lifecycle = lifecycle
+	for _, v := range processIds {
+		subscriber.processIds[v] = true
+	}
+	var backlog []Event
+	if lastEventId > 0 {
+		for _, entry := range this.journal {
+			if entry.event.Id <= lastEventId || !subscriber.wants(entry.event) {
+				continue
+			}
+			if event, ok := this.replay(entry); ok {
+				backlog = append(backlog, event)
+			}
+		}
+	}
+	this.subscribers[subscriber.Id] = subscriber
+	go func() {
+		<-ctx.Done()
+		this.unsubscribe(subscriber.Id)
+	}()
+	return backlog, subscriber
+}
+
+// Subscribe registers a log watcher for the given processes until ctx is done
+func (this *ProcessRegistry) Subscribe(ctx context.Context, processIds []int) *Subscription {
+	_, subscriber := this.subscribe(ctx, false, processIds, false, 0)
+	return subscriber
+}
+
+// SubscribeAll registers a log watcher for every process, including the ones started later, until ctx is done
+func (this *ProcessRegistry) SubscribeAll(ctx context.Context) *Subscription {
+	_, subscriber := this.subscribe(ctx, true, nil, false, 0)
+	return subscriber
+}
+
+// SubscribeEvents registers a watcher for process events and for the output of every process (all) or of the given ones,
+// it also returns the events published after lastEventId that are still in the journal, 0 means no replay
+func (this *ProcessRegistry) SubscribeEvents(ctx context.Context, all bool, processIds []int, lastEventId int64) ([]Event, *Subscription) {
+	return this.subscribe(ctx, all, processIds, true, lastEventId)
+}
+
+func (this *ProcessRegistry) SetWatchAll(subscriberId int, all bool) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	if subscriber, ok := this.subscribers[subscriberId]; ok {
+		subscriber.all = all
+	}
+}
+
+// Watch adds a process to a log watcher and gives send what has been logged from offset on,
+// both happen under the same lock so the watcher neither misses nor duplicates output.
+// Output that has been trimmed from memory is read back from the log file of the process in parts no bigger
+// than what is kept in memory, so a watcher starting at the beginning of a long log never gets it at once.
+// An error of send stops the reading and is returned
+func (this *ProcessRegistry) Watch(subscriberId int, processId int, offset int64, send func(LogItem) error) error {
+	this.mutex.Lock()
+	subscriber, ok := this.subscribers[subscriberId]
+	if !ok {
+		this.mutex.Unlock()
+		return fmt.Errorf("log watcher %d does not exist", subscriberId)
+	}
+	record, ok := this.processes[processId]
+	if !ok {
+		this.mutex.Unlock()
+		return fmt.Errorf("process %d does not exist", processId)
+	}
+	subscriber.processIds[processId] = true
+	if offset < 0 {
+		offset = 0
+	}
+	logFile := record.LogFile
+	logOffset := record.logOffset
+	writer := record.logFile
+	start := offset
+	if start < logOffset {
+		start = logOffset
+	}
+	output := record.getChunks(start)
+	this.mutex.Unlock()
+
+	// the file is only appended to, so everything before logOffset stays the same once it is there
+	if offset < logOffset && logFile != "" && this.logStore != nil {
+		if writer != nil {
+			writer.WaitWritten(logOffset)
+		}
+		for offset < logOffset {
+			length := logOffset - offset
+			if length > int64(this.maxStoredLogCharacters) {
+				length = int64(this.maxStoredLogCharacters)
+			}
+			text, err := this.logStore.Read(logFile, offset, length)
+			if err != nil || text == "" {
+				fmt.Println(err)
+				break
+			}
+			if offset+int64(len(text)) < logOffset {
+				// a part ends on a whole character, the next one starts with the rest of it
+				text = trimPartialRune(text)
+			}
+			err = send(LogItem{
+				ProcessId: processId,
+				Offset:    offset,
+				Time:      time.Now(),
+				Stream:    common.StreamOutput,
+				Log:       text,
+			})
+			if err != nil {
+				return err
+			}
+			offset += int64(len(text))
+		}
+	}
+	for _, item := range output {
+		if err := send(item); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// trimPartialRune removes a character cut at the end of text, text is kept whole when that would leave nothing
+func trimPartialRune(text string) string {
+	for k := len(text) - 1; k >= 0 && k >= len(text)-utf8.UTFMax; k-- {
+		if utf8.RuneStart(text[k]) {
+			if k > 0 && !utf8.FullRuneInString(text[k:]) {
+				return text[:k]
+			}
+			break
+		}
+	}
+	return text
+}
+
+// getChunks returns the stored log from offset on, split into the chunks it has been written in
+func (this *processRecord) getChunks(offset int64) []LogItem {
+	var output []LogItem
+	for k, chunk := range this.chunks {
+		end := this.size
+		if k+1 < len(this.chunks) {
+			end = this.chunks[k+1].offset
+		}
+		if end <= offset {
+			continue
+		}
+		start := chunk.offset
+		if start < offset {
+			start = offset
+		}
+		output = append(output, LogItem{
+			ProcessId: this.Id,
+			Offset:    start,
+			Time:      chunk.time,
+			Stream:    chunk.stream,
+			Log:       this.log[start-this.logOffset : end-this.logOffset],
+		})
+	}
+	return output
+}
+
+func (this *ProcessRegistry) Unwatch(subscriberId int, processId int) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	if subscriber, ok := this.subscribers[subscriberId]; ok {
+		delete(subscriber.processIds, processId)
+	}
+}
+
+func (this *ProcessRegistry) unsubscribe(subscriberId int) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	delete(this.subscribers, subscriberId)
+}
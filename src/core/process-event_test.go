@@ -0,0 +1,156 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func logEvent(id int64, processId int, offset int64, size int) Event {
+	return Event{
+		Id:   id,
+		Type: EventLog,
+		Log:  &LogItem{ProcessId: processId, Offset: offset, Log: strings.Repeat("x", size)},
+	}
+}
+
+func TestSubscriptionPush(t *testing.T) {
+	tests := []struct {
+		name    string
+		sizes   []int
+		types   []string
+		skipped []int64
+	}{
+		{
+			name:    "small chunks all pass",
+			sizes:   []int{10, 20, 30},
+			types:   []string{EventLog, EventLog, EventLog},
+			skipped: []int64{0, 0, 0},
+		},
+		{
+			name:    "an oversize chunk passes to an idle watcher",
+			sizes:   []int{maxPendingLogBytes + 1},
+			types:   []string{EventLog},
+			skipped: []int64{0},
+		},
+		{
+			name:    "output above the limit is coalesced into one skip",
+			sizes:   []int{maxPendingLogBytes - 10, 20, 30},
+			types:   []string{EventLog, EventLogSkipped},
+			skipped: []int64{0, 50},
+		},
+		{
+			name:    "an oversize chunk behind waiting output is skipped",
+			sizes:   []int{10, maxPendingLogBytes},
+			types:   []string{EventLog, EventLogSkipped},
+			skipped: []int64{0, maxPendingLogBytes},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			subscription := newSubscription(1)
+			offset := int64(0)
+			for k, size := range test.sizes {
+				subscription.push(logEvent(int64(k+1), 1, offset, size))
+				offset += int64(size)
+			}
+			events := subscription.Take()
+			if len(events) != len(test.types) {
+				t.Fatalf("got %d events, want %d", len(events), len(test.types))
+			}
+			for k, event := range events {
+				if event.Type != test.types[k] || event.Skipped != test.skipped[k] {
+					t.Errorf("event %d is %s skipping %d, want %s skipping %d", k, event.Type, event.Skipped, test.types[k], test.skipped[k])
+				}
+			}
+		})
+	}
+}
+
+func TestSubscriptionTakeResetsTheLimit(t *testing.T) {
+	subscription := newSubscription(1)
+	subscription.push(logEvent(1, 1, 0, maxPendingLogBytes))
+	subscription.Take()
+	subscription.push(logEvent(2, 1, maxPendingLogBytes, maxPendingLogBytes))
+	events := subscription.Take()
+	if len(events) != 1 || events[0].Type != EventLog {
+		t.Fatalf("got %+v, want the whole second chunk", events)
+	}
+}
+
+func TestWatchReadsTheTrimmedLogInParts(t *testing.T) {
+	logStore, err := NewLogStore(t.TempDir(), 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	registry := NewProcessRegistry(100, logStore)
+	subscription := registry.Subscribe(context.Background(), nil)
+	processId, _ := registry.Queue(ProcessOrigin{}, "long", "", "tester", false)
+	registry.MarkRunning(processId)
+	// characters of 2 and 3 bytes fall across the ends of the parts
+	var written strings.Builder
+	for k := 0; k < 200; k++ {
+		line := fmt.Sprintf("%d é€\n", k)
+		written.WriteString(line)
+		registry.Write(processId, line)
+	}
+	registry.Finish(processId, nil)
+	registry.mutex.Lock()
+	logOffset := registry.processes[processId].logOffset
+	registry.mutex.Unlock()
+	if logOffset == 0 {
+		t.Fatal("nothing has been trimmed from memory")
+	}
+
+	for _, offset := range []int64{0, 7, logOffset - 50, logOffset + 10} {
+		var items []LogItem
+		err = registry.Watch(subscription.Id, processId, offset, func(item LogItem) error {
+			items = append(items, item)
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var read strings.Builder
+		next := offset
+		for _, item := range items {
+			if item.Offset != next {
+				t.Fatalf("from %d: a part starts at %d, want %d", offset, item.Offset, next)
+			}
+			if len(item.Log) > 100 {
+				t.Errorf("from %d: a part of %d bytes is bigger than what is kept in memory", offset, len(item.Log))
+			}
+			// what is kept in memory may start with the end of a character, the parts read from the file do not
+			if item.Offset < logOffset && !utf8.ValidString(item.Log) {
+				t.Errorf("from %d: the part at %d cuts a character: %q", offset, item.Offset, item.Log)
+			}
+			read.WriteString(item.Log)
+			next += int64(len(item.Log))
+		}
+		if want := written.String()[offset:]; read.String() != want {
+			t.Errorf("from %d: got %d bytes, want %d", offset, read.Len(), len(want))
+		}
+	}
+}
+
+func TestWatchStopsOnSendError(t *testing.T) {
+	logStore, err := NewLogStore(t.TempDir(), 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	registry := NewProcessRegistry(10, logStore)
+	subscription := registry.Subscribe(context.Background(), nil)
+	processId := runTestProcess(registry, "long", strings.Repeat("x", 1000), nil)
+	sent := 0
+	closed := errors.New("the watcher went away")
+	err = registry.Watch(subscription.Id, processId, 0, func(item LogItem) error {
+		sent++
+		return closed
+	})
+	if err != closed || sent != 1 {
+		t.Errorf("got %v after %d parts, want %v after 1", err, sent, closed)
+	}
+}
@@ -0,0 +1,541 @@
+package core
+
+import (
+	"common"
+	"errors"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	ProcessStateQueued  = "queued"
+	ProcessStateRunning = "running"
+	// ProcessStateStopping is a stopped process whose handler has not returned yet, it ends as cancelled or timed out
+	ProcessStateStopping  = "stopping"
+	ProcessStateSucceeded = "succeeded"
+	ProcessStateFailed    = "failed"
+	ProcessStateCancelled = "cancelled"
+	ProcessStateTimedOut  = "timed out"
+)
+
+var ansiEscapePattern = regexp.MustCompile(`\x1b\[[0-9;?]*[A-Za-z]`)
+
+// LogItem is a chunk of process output, Offset is the position of its first byte in the whole output of the process,
+// Stream is one of the common.Stream* constants
+type LogItem struct {
+	ProcessId int
+	Offset    int64
+	Time      time.Time
+	Stream    string
+	Log       string
+}
+
+// Process is a snapshot of a process record, the log buffer and stop channel stay inside the registry
+type Process struct {
+	Id            int        `json:"process_id"`
+	Command       string     `json:"command"`
+	Param         string     `json:"param"`
+	User          string     `json:"user,omitempty"`
+	State         string     `json:"state"`
+	ExitCode      *int       `json:"exit_code"`
+	Error         string     `json:"error,omitempty"`
+	QueuedAt      time.Time  `json:"queued_at"`
+	QueuePosition int        `json:"queue_position,omitempty"`
+	StartedAt     time.Time  `json:"started_at"`
+	FinishedAt    *time.Time `json:"finished_at"`
+	Duration      int64      `json:"duration_ms"`
+	LogFile       string     `json:"log_file,omitempty"`
+	Pty           bool       `json:"pty,omitempty"`
+	// ParentId is the process of the workflow a node has been run for, Schedule the schedule.yml entry that ran it
+	ParentId int    `json:"parent_id,omitempty"`
+	Schedule string `json:"schedule,omitempty"`
+	// Variables are those set by the steps of the run, e.g. with "save output as",
+	// Attempts those made by the retry policies of its steps, curl items or workflow nodes
+	Variables map[string]string `json:"variables,omitempty"`
+	Attempts  []common.Attempt  `json:"attempts,omitempty"`
+}
+
+func (this *Process) IsFinished() bool {
+	return this.State == ProcessStateSucceeded || this.State == ProcessStateFailed || this.State == ProcessStateCancelled || this.State == ProcessStateTimedOut
+}
+
+// ProcessOrigin tells what a process is run for besides a user: a node of the workflow run as process ParentId
+// or an entry of schedule.yml, the zero value means neither
+type ProcessOrigin struct {
+	ParentId int
+	Schedule string
+}
+
+// logChunk marks where a chunk written by WriteChunk starts in the log of a process
+type logChunk struct {
+	offset int64
+	stream string
+	time   time.Time
+}
+
+type processRecord struct {
+	Process
+	log       string
+	chunks    []logChunk
+	logOffset int64
+	size      int64
+	logFile   *logFileWriter
+	session   *common.Session
+	stopped   bool
+	// stopState is the state a stopped process ends in once Finish runs
+	stopState string
+	// done is closed once the end of the process has been published
+	done chan bool
+}
+
+// ProcessRegistry owns every running and finished process together with their logs and log watchers,
+// all handlers must go through it instead of touching the state directly
+type ProcessRegistry struct {
+	mutex                     sync.Mutex
+	processes                 map[int]*processRecord
+	subscribers               map[int]*Subscription
+	journal                   []journalEntry
+	processAutoIncrementId    int
+	subscriberAutoIncrementId int
+	eventAutoIncrementId      int64
+	maxStoredLogCharacters    int
+	logStore                  *LogStore
+}
+
+// NewProcessRegistry creates the registry, the whole output of a process goes to logStore when it is not nil
+// while only the last maxStoredLogCharacters are kept in memory
+func NewProcessRegistry(maxStoredLogCharacters int, logStore *LogStore) *ProcessRegistry {
+	return &ProcessRegistry{
+		processes:              map[int]*processRecord{},
+		subscribers:            map[int]*Subscription{},
+		maxStoredLogCharacters: maxStoredLogCharacters,
+		logStore:               logStore,
+	}
+}
+
+func (this *ProcessRegistry) add(origin ProcessOrigin, command string, param string, user string, state string) *processRecord {
+	this.processAutoIncrementId++
+	now := time.Now()
+	record := &processRecord{
+		Process: Process{
+			Id:        this.processAutoIncrementId,
+			ParentId:  origin.ParentId,
+			Schedule:  origin.Schedule,
+			Command:   command,
+			Param:     param,
+			User:      user,
+			State:     state,
+			QueuedAt:  now,
+			StartedAt: now,
+		},
+		session: common.NewSession(make(chan bool)),
+		done:    make(chan bool),
+	}
+	this.processes[record.Id] = record
+	return record
+}
+
+// Queue registers a new queued process and returns its id and the session to run it with, in a pseudo terminal if pty is set.
+// origin tells what it is run for
+func (this *ProcessRegistry) Queue(origin ProcessOrigin, command string, param string, user string, pty bool) (int, *common.Session) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	record := this.add(origin, command, param, user, ProcessStateQueued)
+	record.Pty = pty
+	record.session.Pty = pty
+	this.publishProcess(EventProcessQueued, record)
+	return record.Id, record.session
+}
+
+// MarkRunning moves a queued process to running, it returns false when the process is not queued anymore
+func (this *ProcessRegistry) MarkRunning(processId int) bool {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	record, ok := this.processes[processId]
+	if !ok || record.State != ProcessStateQueued {
+		return false
+	}
+	record.State = ProcessStateRunning
+	record.QueuePosition = 0
+	record.StartedAt = time.Now()
+	if this.logStore != nil {
+		f, name, err := this.logStore.Create(record.Id, record.QueuedAt)
+		if err != nil {
+			fmt.Println(err)
+		} else {
+			record.logFile = newLogFileWriter(f, record.size)
+			record.LogFile = name
+		}
+	}
+	this.publishProcess(EventProcessStarted, record)
+	return true
+}
+
+func (this *ProcessRegistry) SetQueuePositions(positions map[int]int) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	for processId, record := range this.processes {
+		if record.State == ProcessStateQueued {
+			record.QueuePosition = positions[processId]
+		}
+	}
+}
+
+// AddFailed registers a process that has never been started, e.g. when the command does not exist
+func (this *ProcessRegistry) AddFailed(origin ProcessOrigin, command string, param string, user string, err error) int {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	record := this.add(origin, command, param, user, ProcessStateFailed)
+	record.finish(err)
+	this.publishFinished(record)
+	return record.Id
+}
+
+// publishFinished tells the subscribers and those waiting for the process that it has finished
+func (this *ProcessRegistry) publishFinished(record *processRecord) {
+	this.publishProcess(EventProcessFinished, record)
+	select {
+	case <-record.done:
+	default:
+		close(record.done)
+	}
+}
+
+// Wait blocks until the process has finished and returns its last snapshot, false when it does not exist
+func (this *ProcessRegistry) Wait(processId int) (Process, bool) {
+	this.mutex.Lock()
+	record, ok := this.processes[processId]
+	this.mutex.Unlock()
+	if !ok {
+		return Process{}, false
+	}
+	<-record.done
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	return record.snapshot(), true
+}
+
+func (this *processRecord) finish(err error) {
+	now := time.Now()
+	if this.FinishedAt == nil {
+		this.FinishedAt = &now
+		this.Duration = now.Sub(this.StartedAt).Milliseconds()
+	}
+	if err == nil {
+		exitCode := 0
+		this.ExitCode = &exitCode
+		return
+	}
+	this.Error = err.Error()
+	var exitError *exec.ExitError
+	if errors.As(err, &exitError) {
+		exitCode := exitError.ExitCode()
+		this.ExitCode = &exitCode
+	}
+}
+
+// Finish records the result of a process, a stopped process keeps its state but still gets its exit status
+func (this *ProcessRegistry) Finish(processId int, err error) {
+	this.mutex.Lock()
+	record, ok := this.processes[processId]
+	if !ok {
+		this.mutex.Unlock()
+		return
+	}
+	// the log file is complete once the end of the process is published, the disk is waited for outside the mutex
+	if writer, size := record.logFile, record.size; writer != nil {
+		this.mutex.Unlock()
+		writer.WaitWritten(size)
+		this.mutex.Lock()
+	}
+	defer this.mutex.Unlock()
+	if record.stopped {
+		record.State = record.stopState
+	} else {
+		record.State = ProcessStateSucceeded
+		if err != nil {
+			record.State = ProcessStateFailed
+		}
+	}
+	record.finish(err)
+	this.closeLogFile(record)
+	this.publishFinished(record)
+}
+
+// closeLogFile stops writing the output of a process to its log file, what is still queued is written meanwhile
+func (this *ProcessRegistry) closeLogFile(record *processRecord) {
+	if record.logFile != nil {
+		writer, name := record.logFile, record.LogFile
+		record.logFile = nil
+		go func() {
+			_ = writer.Close()
+			this.logStore.Close(writer.file, name)
+		}()
+	}
+}
+
+// Close stops a running or queued process, or forgets a finished one together with its log.
+// A stopping process is only forgotten once Finish has run, those waiting for it would never know it has ended
+func (this *ProcessRegistry) Close(processId int) error {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	record, ok := this.processes[processId]
+	if !ok {
+		return fmt.Errorf("process %d does not exist", processId)
+	}
+	if record.IsFinished() {
+		delete(this.processes, processId)
+		this.publishProcess(EventProcessRemoved, record)
+		return nil
+	}
+	if record.State == ProcessStateQueued {
+		// a queued process is never started so nobody else reports its end
+		now := time.Now()
+		record.StartedAt = now
+		record.FinishedAt = &now
+		record.stop(ProcessStateCancelled)
+		record.State = ProcessStateCancelled
+		this.publishFinished(record)
+		return nil
+	}
+	record.stop(ProcessStateCancelled)
+	return nil
+}
+
+// TimeOut stops a running process the same way as Close but records it as timed out
+func (this *ProcessRegistry) TimeOut(processId int) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	if record, ok := this.processes[processId]; ok && record.State == ProcessStateRunning {
+		record.stop(ProcessStateTimedOut)
+	}
+}
+
+// stop asks the handler of the process to stop, the process is stopping until Finish runs and then ends as state
+func (this *processRecord) stop(state string) {
+	if this.stopped {
+		return
+	}
+	this.stopped = true
+	this.stopState = state
+	this.State = ProcessStateStopping
+	this.QueuePosition = 0
+	close(this.session.ForceStop)
+}
+
+// Write appends plain text to the stored log of a process
+func (this *ProcessRegistry) Write(processId int, text string) {
+	this.WriteChunk(processId, common.Chunk{Stream: common.StreamOutput, Time: time.Now(), Text: text})
+}
+
+// WriteChunk appends a chunk to the stored log of a process and passes it to every log watcher, it never waits for them
+func (this *ProcessRegistry) WriteChunk(processId int, chunk common.Chunk) {
+	this.mutex.Lock()
+	text := chunk.Text
+	item := LogItem{
+		ProcessId: processId,
+		Time:      chunk.Time,
+		Stream:    chunk.Stream,
+		Log:       text,
+	}
+	var writer *logFileWriter
+	if record, ok := this.processes[processId]; ok {
+		item.Offset = record.size
+		if record.logFile != nil {
+			if err := record.logFile.Queue(text); err != nil {
+				// offsets in a file with a gap would be wrong, so the file is given up
+				fmt.Println(err)
+				this.closeLogFile(record)
+				record.LogFile = ""
+			}
+			writer = record.logFile
+		}
+		record.chunks = append(record.chunks, logChunk{offset: record.size, stream: chunk.Stream, time: chunk.Time})
+		record.size += int64(len(text))
+		record.log += text
+		if len(record.log) > this.maxStoredLogCharacters {
+			trimmed := this.maxStoredLogCharacters / 5
+			record.log = record.log[trimmed:]
+			record.logOffset += int64(trimmed)
+			// the first chunk left may have lost its beginning, it still tells the stream of the rest
+			k := 0
+			for k+1 < len(record.chunks) && record.chunks[k+1].offset <= record.logOffset {
+				k++
+			}
+			record.chunks = append([]logChunk{}, record.chunks[k:]...)
+		}
+	}
+	this.publish(Event{Type: EventLog, Time: item.Time, Log: &item})
+	this.mutex.Unlock()
+	// only the producer of the process waits for a slow disk
+	if writer != nil {
+		writer.WaitQueue()
+	}
+}
+
+// getRunning returns the session of a running process, mutex must be held
+func (this *ProcessRegistry) getRunning(processId int) (*common.Session, error) {
+	record, ok := this.processes[processId]
+	if !ok {
+		return nil, fmt.Errorf("process %d does not exist", processId)
+	}
+	if record.State != ProcessStateRunning {
+		return nil, fmt.Errorf("process %d is not running", processId)
+	}
+	return record.session, nil
+}
+
+// WriteInput sends text to the standard input of a running process
+func (this *ProcessRegistry) WriteInput(processId int, text string) error {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	session, err := this.getRunning(processId)
+	if err != nil {
+		return err
+	}
+	return session.WriteInput(text)
+}
+
+// CloseInput sends end of file to the standard input of a running process
+func (this *ProcessRegistry) CloseInput(processId int) error {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	session, err := this.getRunning(processId)
+	if err != nil {
+		return err
+	}
+	session.CloseInput()
+	return nil
+}
+
+// Resize changes the terminal size of a running process started in pty mode
+func (this *ProcessRegistry) Resize(processId int, rows uint16, cols uint16) error {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	session, err := this.getRunning(processId)
+	if err != nil {
+		return err
+	}
+	if !this.processes[processId].Pty {
+		return fmt.Errorf("process %d has no terminal", processId)
+	}
+	return session.Resize(rows, cols)
+}
+
+func (this *ProcessRegistry) Exists(processId int) bool {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	_, ok := this.processes[processId]
+	return ok
+}
+
+func (this *ProcessRegistry) GetLog(processId int) (string, bool) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	if record, ok := this.processes[processId]; ok {
+		return record.log, true
+	}
+	return "", false
+}
+
+// GetLogTail returns the last lines of the log of a process without its terminal escapes
+func (this *ProcessRegistry) GetLogTail(processId int, lines int) string {
+	log, _ := this.GetLog(processId)
+	log = strings.TrimRight(ansiEscapePattern.ReplaceAllString(log, ""), "\r\n")
+	all := strings.Split(log, "\n")
+	if len(all) > lines {
+		all = all[len(all)-lines:]
+	}
+	return strings.Join(all, "\n")
+}
+
+// LogPart is a part of the output of a process, Offset is where Text starts in the whole output
+// and Size how long the whole output is so far
+type LogPart struct {
+	ProcessId int    `json:"process_id"`
+	Offset    int64  `json:"offset"`
+	Size      int64  `json:"size"`
+	Text      string `json:"text"`
+	Finished  bool   `json:"finished"`
+}
+
+// ReadLog returns at most length bytes of the output of a process from offset on, read from its log file
+// when it has one, or else from what is kept in memory, which may start after offset
+func (this *ProcessRegistry) ReadLog(processId int, offset int64, length int64) (LogPart, error) {
+	this.mutex.Lock()
+	record, ok := this.processes[processId]
+	if !ok {
+		this.mutex.Unlock()
+		return LogPart{}, fmt.Errorf("process %d does not exist", processId)
+	}
+	part := LogPart{ProcessId: processId, Size: record.size, Finished: record.IsFinished()}
+	name, log, logOffset, writer := record.LogFile, record.log, record.logOffset, record.logFile
+	this.mutex.Unlock()
+	if offset > part.Size {
+		offset = part.Size
+	}
+	if length > part.Size-offset {
+		length = part.Size - offset
+	}
+	part.Offset = offset
+	if name != "" && this.logStore != nil {
+		if writer != nil {
+			writer.WaitWritten(offset + length)
+		}
+		text, err := this.logStore.Read(name, offset, length)
+		if err == nil {
+			part.Text = text
+			return part, nil
+		}
+	}
+	if offset < logOffset {
+		length -= logOffset - offset
+		offset = logOffset
+	}
+	part.Offset = offset
+	if start := offset - logOffset; length > 0 && start+length <= int64(len(log)) {
+		part.Text = log[start : start+length]
+	}
+	return part, nil
+}
+
+func (this *ProcessRegistry) Get(processId int) (Process, bool) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	if record, ok := this.processes[processId]; ok {
+		return record.snapshot(), true
+	}
+	return Process{}, false
+}
+
+func (this *processRecord) snapshot() Process {
+	output := this.Process
+	output.Variables = this.session.Variables.All()
+	output.Attempts = this.session.Attempts.All()
+	if output.FinishedAt == nil && output.State != ProcessStateQueued {
+		output.Duration = time.Since(output.StartedAt).Milliseconds()
+	}
+	return output
+}
+
+// List returns a snapshot of all processes ordered by start time
+func (this *ProcessRegistry) List() []Process {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	output := make([]Process, 0, len(this.processes))
+	for _, record := range this.processes {
+		output = append(output, record.snapshot())
+	}
+	sort.Slice(output, func(i, j int) bool {
+		if output[i].StartedAt.Equal(output[j].StartedAt) {
+			return output[i].Id < output[j].Id
+		}
+		return output[i].StartedAt.Before(output[j].StartedAt)
+	})
+	return output
+}
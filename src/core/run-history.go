@@ -0,0 +1,41 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"repository"
+)
+
+// RecordRunHistory stores every finished process in the run history
+func RecordRunHistory(registry *ProcessRegistry, history *repository.RunHistory) {
+	_, subscription := registry.SubscribeEvents(context.Background(), false, nil, 0)
+	go func() {
+		for range subscription.Ready() {
+			for _, event := range subscription.Take() {
+				if event.Type != EventProcessFinished {
+					continue
+				}
+				process := event.Process
+				err := history.Add(repository.RunRecord{
+					ProcessId:  process.Id,
+					Command:    process.Command,
+					Param:      process.Param,
+					User:       process.User,
+					State:      process.State,
+					ExitCode:   process.ExitCode,
+					Error:      process.Error,
+					StartedAt:  process.StartedAt,
+					FinishedAt: process.FinishedAt,
+					Duration:   process.Duration,
+					LogFile:    process.LogFile,
+					Variables:  process.Variables,
+					Attempts:   process.Attempts,
+					Schedule:   process.Schedule,
+				})
+				if err != nil {
+					fmt.Println(err)
+				}
+			}
+		}
+	}()
+}
@@ -0,0 +1,231 @@
+package core
+
+import (
+	"common"
+	"fmt"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"sort"
+	"sync"
+	"time"
+	"yaml_config"
+)
+
+// ScheduleUser is the user of the processes run by schedule.yml
+const ScheduleUser = "schedule"
+
+const scheduleListedRuns = 5
+
+type scheduleEntry struct {
+	item   yaml_config.ScheduleItem
+	cron   *common.CronExpression
+	every  time.Duration
+	jitter time.Duration
+	// due is when the entry should run next, at is due delayed by the jitter
+	due           time.Time
+	at            time.Time
+	lastProcessId int
+	// pending is a run waiting for the previous one to finish, with the "queue" overlap
+	pending bool
+}
+
+// ScheduleEntry is what the schedule api tells about an entry of schedule.yml
+type ScheduleEntry struct {
+	Name string `json:"name"`
+	yaml_config.ScheduleItem
+	Enabled       bool        `json:"enabled"`
+	NextRunAt     *time.Time  `json:"next_run_at,omitempty"`
+	NextRuns      []time.Time `json:"next_runs"`
+	LastProcessId int         `json:"last_process_id,omitempty"`
+	Pending       bool        `json:"pending,omitempty"`
+}
+
+// Schedule runs the commands of schedule.yml through the scheduler at their times,
+// entries can be enabled and disabled at runtime, which survives reloading the file but not a restart
+type Schedule struct {
+	mutex     sync.Mutex
+	scheduler *Scheduler
+	entries   map[string]*scheduleEntry
+	enabled   map[string]bool
+}
+
+func NewSchedule(scheduler *Scheduler) *Schedule {
+	return &Schedule{
+		scheduler: scheduler,
+		entries:   map[string]*scheduleEntry{},
+		enabled:   map[string]bool{},
+	}
+}
+
+// Reload reads schedule.yml again, a missing file schedules nothing. An entry that has not changed keeps its next run,
+// an invalid one is left out and reported
+func (this *Schedule) Reload() error {
+	data, err := ioutil.ReadFile("config/schedule.yml")
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	items := map[string]yaml_config.ScheduleItem{}
+	err = yaml.Unmarshal(data, items)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	entries := map[string]*scheduleEntry{}
+	for name, item := range items {
+		cron, every, jitter, err := item.Parse()
+		if err != nil {
+			fmt.Printf("schedule %s: %s\n", name, err)
+			continue
+		}
+		entry := &scheduleEntry{item: item, cron: cron, every: every, jitter: jitter}
+		if previous, ok := this.entries[name]; ok {
+			entry.lastProcessId, entry.pending = previous.lastProcessId, previous.pending
+			if previous.item.Cron == item.Cron && previous.item.Every == item.Every && previous.item.Jitter == item.Jitter {
+				entry.due, entry.at = previous.due, previous.at
+			}
+		}
+		if entry.due.IsZero() {
+			entry.plan(now)
+		}
+		entries[name] = entry
+	}
+	this.entries = entries
+	return nil
+}
+
+// Start checks every second for entries to run
+func (this *Schedule) Start() {
+	go func() {
+		for now := range time.Tick(time.Second) {
+			this.tick(now)
+		}
+	}()
+}
+
+// plan sets the next run after now
+func (this *scheduleEntry) plan(now time.Time) {
+	if this.cron != nil {
+		this.due = this.cron.Next(now)
+	} else {
+		this.due = now.Add(this.every)
+	}
+	this.at = this.due
+	if this.jitter > 0 && !this.due.IsZero() {
+		this.at = this.due.Add(time.Duration(rand.Int63n(int64(this.jitter))))
+	}
+}
+
+// nextRuns returns the next count runs without their jitter
+func (this *scheduleEntry) nextRuns(count int) []time.Time {
+	runs := []time.Time{}
+	for due := this.due; !due.IsZero() && len(runs) < count; {
+		runs = append(runs, due)
+		if this.cron != nil {
+			due = this.cron.Next(due)
+		} else {
+			due = due.Add(this.every)
+		}
+	}
+	return runs
+}
+
+func (this *Schedule) isEnabled(name string, entry *scheduleEntry) bool {
+	if enabled, ok := this.enabled[name]; ok {
+		return enabled
+	}
+	return entry.item.IsEnabled()
+}
+
+// isActive tells whether the last run of the entry is queued or running
+func (this *Schedule) isActive(entry *scheduleEntry) bool {
+	if entry.lastProcessId == 0 {
+		return false
+	}
+	process, ok := this.scheduler.registry.Get(entry.lastProcessId)
+	return ok && !process.IsFinished()
+}
+
+func (this *Schedule) tick(now time.Time) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	for name, entry := range this.entries {
+		if !this.isEnabled(name, entry) {
+			continue
+		}
+		if entry.pending && !this.isActive(entry) {
+			entry.pending = false
+			this.run(name, entry)
+		}
+		if entry.at.IsZero() || now.Before(entry.at) {
+			continue
+		}
+		// runs missed while the server was down or asleep are not caught up
+		entry.plan(now)
+		if !this.isActive(entry) || entry.item.GetOverlap() == yaml_config.ScheduleOverlapAllow {
+			this.run(name, entry)
+			continue
+		}
+		if entry.item.GetOverlap() == yaml_config.ScheduleOverlapQueue {
+			entry.pending = true
+			continue
+		}
+		fmt.Printf("schedule %s: skipped, process %d is still running\n", name, entry.lastProcessId)
+	}
+}
+
+func (this *Schedule) run(name string, entry *scheduleEntry) {
+	processId, err := this.scheduler.SubmitScheduled(name, entry.item.Command, entry.item.Param)
+	if err != nil {
+		fmt.Printf("schedule %s: %s\n", name, err)
+	}
+	entry.lastProcessId = processId
+}
+
+// List returns every entry with its next runs, sorted by name
+func (this *Schedule) List() []ScheduleEntry {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	output := []ScheduleEntry{}
+	for name, entry := range this.entries {
+		item := ScheduleEntry{
+			Name:          name,
+			ScheduleItem:  entry.item,
+			Enabled:       this.isEnabled(name, entry),
+			NextRuns:      []time.Time{},
+			LastProcessId: entry.lastProcessId,
+			Pending:       entry.pending,
+		}
+		if item.Enabled && !entry.at.IsZero() {
+			at := entry.at
+			item.NextRunAt = &at
+			item.NextRuns = entry.nextRuns(scheduleListedRuns)
+		}
+		output = append(output, item)
+	}
+	sort.Slice(output, func(i, j int) bool {
+		return output[i].Name < output[j].Name
+	})
+	return output
+}
+
+// SetEnabled enables or disables an entry until the server restarts, an enabled entry runs next from now on
+func (this *Schedule) SetEnabled(name string, enabled bool) error {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	entry, ok := this.entries[name]
+	if !ok {
+		return fmt.Errorf("schedule %s does not exist", name)
+	}
+	if enabled && !this.isEnabled(name, entry) {
+		entry.plan(time.Now())
+	}
+	this.enabled[name] = enabled
+	if !enabled {
+		entry.pending = false
+	}
+	return nil
+}
@@ -0,0 +1,247 @@
+package core
+
+import (
+	"common"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+	"yaml_config"
+)
+
+const (
+	SingletonModeReject = "reject"
+	SingletonModeQueue  = "queue"
+)
+
+var ErrAlreadyRunning = fmt.Errorf("command is already running")
+
+type scheduledRun struct {
+	processId int
+	command   string
+	param     string
+	user      string
+	handler   common.CommandHandler
+	options   CommandOptions
+	session   *common.Session
+}
+
+// Scheduler sits in front of the command center, it queues runs and only starts them
+// when the global concurrency limit, singleton mode and resource locks allow it
+type Scheduler struct {
+	mutex         sync.Mutex
+	registry      *ProcessRegistry
+	commandCenter *CommandCenter
+	config        yaml_config.IConfig
+	queue         []*scheduledRun
+	running       map[int]*scheduledRun
+	locks         map[string]int
+}
+
+func NewScheduler(registry *ProcessRegistry, commandCenter *CommandCenter, config yaml_config.IConfig) *Scheduler {
+	return &Scheduler{
+		registry:      registry,
+		commandCenter: commandCenter,
+		config:        config,
+		running:       map[int]*scheduledRun{},
+		locks:         map[string]int{},
+	}
+}
+
+// Submit queues a command on behalf of user and starts it as soon as possible, it returns the new process id.
+// param is the text typed after "command:", the declared params of the command are read from it
+func (this *Scheduler) Submit(command string, param string, user string) (int, error) {
+	return this.submit(ProcessOrigin{}, command, param, user)
+}
+
+// SubmitChild is Submit for a node of the workflow run as process parentId
+func (this *Scheduler) SubmitChild(parentId int, command string, param string, user string) (int, error) {
+	return this.submit(ProcessOrigin{ParentId: parentId}, command, param, user)
+}
+
+// SubmitScheduled is Submit for an entry of schedule.yml, the process is tagged with its name
+func (this *Scheduler) SubmitScheduled(schedule string, command string, param string) (int, error) {
+	return this.submit(ProcessOrigin{Schedule: schedule}, command, param, ScheduleUser)
+}
+
+func (this *Scheduler) submit(origin ProcessOrigin, command string, param string, user string) (int, error) {
+	handler, err := this.commandCenter.GetCommandInfo(command)
+	if err != nil {
+		return this.registry.AddFailed(origin, command, param, user, err), err
+	}
+	options := this.commandCenter.GetCommandOptions(command)
+	params, err := yaml_config.ParseParams(options.Params, param)
+	if err != nil {
+		return this.registry.AddFailed(origin, command, param, user, err), err
+	}
+	env := this.config.GetEnv()
+	if err = yaml_config.NewTemplate(this.config).WithParams(params).RenderValue(&env); err != nil {
+		err = fmt.Errorf("env of config.yml: %w", err)
+		return this.registry.AddFailed(origin, command, param, user, err), err
+	}
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	if options.Singleton == SingletonModeReject && this.isCommandActive(command) {
+		err := fmt.Errorf("%w: %s", ErrAlreadyRunning, command)
+		return this.registry.AddFailed(origin, command, param, user, err), err
+	}
+	processId, session := this.registry.Queue(origin, command, param, user, options.Pty)
+	session.Params = params
+	session.Env = yaml_config.EnvList(env)
+	this.queue = append(this.queue, &scheduledRun{
+		processId: processId,
+		command:   command,
+		param:     param,
+		user:      user,
+		handler:   handler,
+		options:   options,
+		session:   session,
+	})
+	this.dispatch()
+	return processId, nil
+}
+
+// Close stops a running process, drops a queued one or forgets a finished one
+func (this *Scheduler) Close(processId int) error {
+	this.mutex.Lock()
+	for k, run := range this.queue {
+		if run.processId == processId {
+			this.queue = append(this.queue[:k], this.queue[k+1:]...)
+			break
+		}
+	}
+	this.mutex.Unlock()
+	err := this.registry.Close(processId)
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	this.dispatch()
+	return err
+}
+
+func (this *Scheduler) isCommandActive(command string) bool {
+	for _, run := range this.running {
+		if run.command == command {
+			return true
+		}
+	}
+	for _, run := range this.queue {
+		if run.command == command {
+			return true
+		}
+	}
+	return false
+}
+
+func (this *Scheduler) getMaxConcurrency() int {
+	str, err := this.config.GetStringByKey("maximum concurrent processes")
+	if err != nil {
+		return 0
+	}
+	max, err := strconv.Atoi(str)
+	if err != nil {
+		return 0
+	}
+	return max
+}
+
+func (this *Scheduler) canStart(run *scheduledRun) bool {
+	max := this.getMaxConcurrency()
+	if max > 0 && run.options.Workflow == nil {
+		// a workflow only waits for its nodes, it does not take the place of one
+		count := 0
+		for _, v := range this.running {
+			if v.options.Workflow == nil {
+				count++
+			}
+		}
+		if count >= max {
+			return false
+		}
+	}
+	if run.options.Singleton != "" {
+		for _, v := range this.running {
+			if v.command == run.command {
+				return false
+			}
+		}
+	}
+	for _, lock := range run.options.Locks {
+		if _, ok := this.locks[lock]; ok {
+			return false
+		}
+	}
+	return true
+}
+
+// dispatch starts every queued run that is allowed to start, in queue order, mutex must be held
+func (this *Scheduler) dispatch() {
+	var waiting []*scheduledRun
+	for _, run := range this.queue {
+		if !this.canStart(run) {
+			waiting = append(waiting, run)
+			continue
+		}
+		// a queued process may have been closed in the meantime
+		if this.registry.MarkRunning(run.processId) {
+			this.start(run)
+		}
+	}
+	this.queue = waiting
+	positions := map[int]int{}
+	for k, run := range this.queue {
+		positions[run.processId] = k + 1
+	}
+	this.registry.SetQueuePositions(positions)
+}
+
+func (this *Scheduler) start(run *scheduledRun) {
+	this.running[run.processId] = run
+	for _, lock := range run.options.Locks {
+		this.locks[lock] = run.processId
+	}
+	go func() {
+		err := this.execute(run)
+		this.registry.Finish(run.processId, err)
+		this.mutex.Lock()
+		defer this.mutex.Unlock()
+		delete(this.running, run.processId)
+		for _, lock := range run.options.Locks {
+			if this.locks[lock] == run.processId {
+				delete(this.locks, lock)
+			}
+		}
+		this.dispatch()
+	}()
+}
+
+func (this *Scheduler) execute(run *scheduledRun) error {
+	fullCommand := run.command
+	if run.param != "" {
+		fullCommand += ":" + run.param
+	}
+	output := common.IChunkWriter(func(chunk common.Chunk) {
+		this.registry.WriteChunk(run.processId, chunk)
+	})
+	run.session.Output = output
+	writer := output.Stream(common.StreamSystem)
+	if run.options.Timeout > 0 {
+		timer := time.AfterFunc(run.options.Timeout, func() {
+			writer(fmt.Sprintf(">>> TIMED OUT after %s\n", run.options.Timeout))
+			this.registry.TimeOut(run.processId)
+		})
+		defer timer.Stop()
+	}
+	fmt.Printf("START command %s\n", fullCommand)
+	writer(">>> RUNNING COMMAND " + fullCommand + "\n")
+	var err error
+	if run.options.Workflow != nil {
+		err = this.runWorkflow(run, output.Stream(common.StreamOutput))
+	} else {
+		err = run.handler(output.Stream(common.StreamOutput), run.param, run.session)
+	}
+	writer(fmt.Sprintf(">>> END COMMAND command %s\n", fullCommand))
+	if err != nil {
+		writer("ERROR: " + err.Error() + "\n")
+	}
+	return err
+}
@@ -0,0 +1,81 @@
+package core
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"os"
+	"sync"
+	"yaml_config"
+)
+
+// TriggerUser is the user of the processes run by trigger.yml, followed by the name of the trigger
+const TriggerUser = "trigger"
+
+// Triggers are the entries of trigger.yml, they let other tools run a command with a token
+type Triggers struct {
+	mutex  sync.Mutex
+	config yaml_config.IConfig
+	items  map[string]yaml_config.TriggerItem
+}
+
+func NewTriggers(config yaml_config.IConfig) *Triggers {
+	return &Triggers{
+		config: config,
+		items:  map[string]yaml_config.TriggerItem{},
+	}
+}
+
+// Reload reads trigger.yml again, a missing file triggers nothing. An invalid entry is left out and reported,
+// as are entries sharing a token
+func (this *Triggers) Reload() error {
+	data, err := ioutil.ReadFile("config/trigger.yml")
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	items := map[string]yaml_config.TriggerItem{}
+	err = yaml.Unmarshal(data, items)
+	if err != nil {
+		return err
+	}
+	err = yaml_config.NewTemplate(this.config).RenderValue(&items)
+	if err != nil {
+		return fmt.Errorf("config/trigger.yml: %w", err)
+	}
+	tokens := map[string]string{}
+	for name, item := range items {
+		if err := item.Check(); err != nil {
+			fmt.Printf("trigger %s: %s\n", name, err)
+			delete(items, name)
+			continue
+		}
+		if other, ok := tokens[item.Token]; ok {
+			fmt.Printf("trigger %s: same token as trigger %s, both are left out\n", name, other)
+			delete(items, name)
+			delete(items, other)
+			continue
+		}
+		tokens[item.Token] = name
+	}
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	this.items = items
+	return nil
+}
+
+// Find returns the trigger with token and its name, every token is compared in constant time
+func (this *Triggers) Find(token string) (string, yaml_config.TriggerItem, bool) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	found := ""
+	for name, item := range this.items {
+		if subtle.ConstantTimeCompare([]byte(item.Token), []byte(token)) == 1 {
+			found = name
+		}
+	}
+	if found == "" || token == "" {
+		return "", yaml_config.TriggerItem{}, false
+	}
+	return found, this.items[found], true
+}
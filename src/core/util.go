@@ -5,7 +5,6 @@ import (
 	"fmt"
 	"gopkg.in/yaml.v2"
 	"io/ioutil"
-	"os"
 	"os/user"
 	"repository"
 	"yaml_config"
@@ -16,8 +15,7 @@ func GetConfig() (*yaml_config.Config, error) {
 	if err != nil {
 		return nil, err
 	}
-	configData := map[string]string{}
-	err = yaml.Unmarshal(b, configData)
+	configData, env, err := yaml_config.ParseConfig(b)
 	if err != nil {
 		return nil, err
 	}
@@ -27,33 +25,21 @@ func GetConfig() (*yaml_config.Config, error) {
 	}
 	b, err = ioutil.ReadFile("config/config."+u.Username+".yml")
 	if err == nil {
-		additionalConfigData := map[string]string{}
-		err := yaml.Unmarshal(b, additionalConfigData)
+		additionalConfigData, additionalEnv, err := yaml_config.ParseConfig(b)
 		if err != nil {
 			return nil, err
 		}
 		configData = common.StringArrayMerge(configData, additionalConfigData)
+		env = common.StringArrayMerge(env, additionalEnv)
 	}
 	config, err := yaml_config.NewConfig(configData)
 	if err != nil {
 		return nil, err
 	}
+	config.SetEnv(env)
 	return config, nil
 }
 
-func RunBashScriptFromWorkingDirectoryConfig(config yaml_config.IConfig, script string, workingDirectoryConfig string, writer common.IWriter, forceStop chan bool) error {
-	wd, err := os.Getwd()
-	common.PanicOnError(err)
-	if workingDirectoryConfig != "" {
-		workDir, err := config.GetStringByKey(workingDirectoryConfig)
-		if err != nil {
-			return err
-		}
-		wd = workDir
-	}
-	return common.RunBashScript(script, wd, writer, forceStop)
-}
-
 func GetCurlItemFromConfig(configKey string) *yaml_config.CurlItem {
 	b, err := ioutil.ReadFile("config/curl.yml")
 	common.PanicOnError(err)
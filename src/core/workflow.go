@@ -0,0 +1,225 @@
+package core
+
+import (
+	"common"
+	"fmt"
+	"sort"
+	"strings"
+	"yaml_config"
+)
+
+const (
+	workflowNodeSkipped = "skipped"
+	workflowNodeNotRun  = "not run"
+)
+
+type workflowResult struct {
+	node    string
+	process Process
+}
+
+// nodeFailure is the failure of the process of a node, its exit code tells whether "on exit codes" retries it
+type nodeFailure struct {
+	process Process
+}
+
+func (this *nodeFailure) Error() string {
+	reason := this.process.State
+	if this.process.Error != "" {
+		reason += ": " + this.process.Error
+	}
+	return reason
+}
+
+func (this *nodeFailure) ExitCode() int {
+	if this.process.ExitCode == nil {
+		return -1
+	}
+	return *this.process.ExitCode
+}
+
+// runWorkflow runs the nodes of a workflow as child processes of run, a node starts as soon as the nodes
+// it depends on are done, closing the workflow closes the nodes that are still running.
+// A node retried by its retry policy counts as done once its last attempt is
+func (this *Scheduler) runWorkflow(run *scheduledRun, w common.IWriter) error {
+	workflow := run.options.Workflow
+	template := yaml_config.NewTemplate(this.config).WithParams(run.session.Params)
+	states := map[string]string{}
+	// satisfied nodes let their dependents start, blocked ones make them skipped
+	satisfied := map[string]bool{}
+	blocked := map[string]bool{}
+	running := map[string]bool{}
+	results := make(chan workflowResult, len(workflow.Nodes))
+	halted := false
+	var failed []string
+
+	finish := func(name string, process Process) {
+		delete(running, name)
+		states[name] = process.State
+		if process.State == ProcessStateSucceeded {
+			satisfied[name] = true
+			w(fmt.Sprintf("node %s succeeded (process %d)\n", name, process.Id))
+			return
+		}
+		w(fmt.Sprintf("node %s %s (process %d)\n", name, (&nodeFailure{process: process}).Error(), process.Id))
+		switch workflow.Nodes[name].OnFailure {
+		case yaml_config.WorkflowOnFailureIgnore:
+			satisfied[name] = true
+			return
+		case yaml_config.WorkflowOnFailureContinue:
+			satisfied[name] = true
+		case yaml_config.WorkflowOnFailureSkipDependents:
+			blocked[name] = true
+		default:
+			halted = true
+		}
+		failed = append(failed, name)
+	}
+
+	for {
+		for started := true; started && !halted; {
+			started = false
+			for _, name := range workflow.NodeNames() {
+				if _, ok := states[name]; ok {
+					continue
+				}
+				node := workflow.Nodes[name]
+				ready, skip := true, ""
+				for _, dependency := range node.DependsOn {
+					if blocked[dependency] {
+						skip = dependency
+					}
+					ready = ready && satisfied[dependency]
+				}
+				if skip != "" {
+					states[name] = workflowNodeSkipped
+					blocked[name] = true
+					started = true
+					w(fmt.Sprintf("node %s skipped, %s did not succeed\n", name, skip))
+					continue
+				}
+				if !ready {
+					continue
+				}
+				started = true
+				states[name] = ProcessStateQueued
+				running[name] = true
+				go func(name string, node yaml_config.WorkflowNode) {
+					results <- workflowResult{node: name, process: this.runWorkflowNode(run, name, node, template, w)}
+				}(name, node)
+			}
+		}
+		if len(running) == 0 {
+			break
+		}
+		select {
+		case result := <-results:
+			finish(result.node, result.process)
+		case <-run.session.ForceStop:
+			// every node closes its own process
+			for len(running) > 0 {
+				result := <-results
+				finish(result.node, result.process)
+			}
+			return common.ErrForceStopped
+		}
+	}
+
+	var notRun []string
+	for _, name := range workflow.NodeNames() {
+		if _, ok := states[name]; !ok {
+			states[name] = workflowNodeNotRun
+			notRun = append(notRun, name)
+		}
+	}
+	if len(notRun) > 0 {
+		w(fmt.Sprintf("nodes not run: %s\n", strings.Join(notRun, ", ")))
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("workflow failed, nodes that did not succeed: %s", strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// runWorkflowNode runs the command of a node as a child process of run, once more for every retry of its policy,
+// and returns the process of the last attempt. Stopping the workflow closes the process that is running
+func (this *Scheduler) runWorkflowNode(run *scheduledRun, name string, node yaml_config.WorkflowNode, template *yaml_config.Template, w common.IWriter) Process {
+	var process Process
+	_ = node.Retry.Run("node "+name, w, run.session, func() (string, error) {
+		param, err := template.Render(node.Param)
+		var processId int
+		if err == nil {
+			processId, err = this.SubmitChild(run.processId, node.Command, param, run.user)
+		}
+		if err != nil && processId == 0 {
+			processId = this.registry.AddFailed(ProcessOrigin{ParentId: run.processId}, node.Command, node.Param, run.user, err)
+		}
+		w(fmt.Sprintf("node %s started %s (process %d)\n", name, node.Command, processId))
+		process = this.waitChild(processId, run.session.ForceStop)
+		if process.State == ProcessStateSucceeded {
+			return "", nil
+		}
+		return "", &nodeFailure{process: process}
+	})
+	return process
+}
+
+// waitChild waits for a child process to finish, it is closed when stop is closed first
+func (this *Scheduler) waitChild(processId int, stop chan bool) Process {
+	done := make(chan Process, 1)
+	go func() {
+		process, _ := this.registry.Wait(processId)
+		done <- process
+	}()
+	select {
+	case process := <-done:
+		return process
+	case <-stop:
+		_ = this.Close(processId)
+		return <-done
+	}
+}
+
+// findWorkflowCycles follows the commands of the nodes from workflow to workflow, it returns the workflows that
+// end up running themselves, each with the path of one of the cycles it is in, e.g. a -> b -> a
+func findWorkflowCycles(workflows map[string]*yaml_config.WorkflowItem) map[string]string {
+	names := make([]string, 0, len(workflows))
+	for name := range workflows {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	cycles := map[string]string{}
+	done := map[string]bool{}
+	var path []string
+	onPath := map[string]int{}
+	var visit func(name string)
+	visit = func(name string) {
+		if start, ok := onPath[name]; ok {
+			cycle := strings.Join(append(append([]string{}, path[start:]...), name), " -> ")
+			for _, member := range path[start:] {
+				if _, ok := cycles[member]; !ok {
+					cycles[member] = cycle
+				}
+			}
+			return
+		}
+		if done[name] {
+			return
+		}
+		onPath[name] = len(path)
+		path = append(path, name)
+		workflow := workflows[name]
+		for _, nodeName := range workflow.NodeNames() {
+			if _, ok := workflows[workflow.Nodes[nodeName].Command]; ok {
+				visit(workflow.Nodes[nodeName].Command)
+			}
+		}
+		path = path[:len(path)-1]
+		delete(onPath, name)
+		done[name] = true
+	}
+	for _, name := range names {
+		visit(name)
+	}
+	return cycles
+}
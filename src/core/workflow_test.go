@@ -0,0 +1,67 @@
+package core
+
+import (
+	"reflect"
+	"testing"
+	"yaml_config"
+)
+
+// workflowRunning builds a workflow with one node per command
+func workflowRunning(commands ...string) *yaml_config.WorkflowItem {
+	nodes := map[string]yaml_config.WorkflowNode{}
+	for _, command := range commands {
+		nodes["run "+command] = yaml_config.WorkflowNode{Command: command}
+	}
+	return &yaml_config.WorkflowItem{Nodes: nodes}
+}
+
+func TestFindWorkflowCycles(t *testing.T) {
+	tests := []struct {
+		name      string
+		workflows map[string]*yaml_config.WorkflowItem
+		want      map[string]string
+	}{
+		{
+			name: "workflows running commands and other workflows",
+			workflows: map[string]*yaml_config.WorkflowItem{
+				"a": workflowRunning("b", "c", "echo"),
+				"b": workflowRunning("c"),
+				"c": workflowRunning("echo"),
+			},
+			want: map[string]string{},
+		},
+		{
+			name: "two workflows running each other",
+			workflows: map[string]*yaml_config.WorkflowItem{
+				"a": workflowRunning("b"),
+				"b": workflowRunning("a"),
+			},
+			want: map[string]string{"a": "a -> b -> a", "b": "a -> b -> a"},
+		},
+		{
+			name: "a longer cycle leaves out the workflow leading to it",
+			workflows: map[string]*yaml_config.WorkflowItem{
+				"a": workflowRunning("b"),
+				"b": workflowRunning("c"),
+				"c": workflowRunning("d"),
+				"d": workflowRunning("b", "echo"),
+			},
+			want: map[string]string{"b": "b -> c -> d -> b", "c": "b -> c -> d -> b", "d": "b -> c -> d -> b"},
+		},
+		{
+			name: "a workflow running itself",
+			workflows: map[string]*yaml_config.WorkflowItem{
+				"a": workflowRunning("a"),
+			},
+			want: map[string]string{"a": "a -> a"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := findWorkflowCycles(test.workflows)
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+		})
+	}
+}
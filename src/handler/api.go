@@ -0,0 +1,311 @@
+package handler
+
+import (
+	"core"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"yaml_config"
+)
+
+const (
+	apiPrefix           = "/api/v1/"
+	defaultApiLogLength = 64 * 1024
+	maxApiLogLength     = 1024 * 1024
+)
+
+// the codes of apiError, a caller tells errors apart with them rather than with the message
+const (
+	apiErrorBadRequest       = "bad_request"
+	apiErrorNotFound         = "not_found"
+	apiErrorConflict         = "conflict"
+	apiErrorMethodNotAllowed = "method_not_allowed"
+	apiErrorInternal         = "internal"
+)
+
+//go:embed openapi.json
+var openApiDocument []byte
+
+type apiError struct {
+	Code      string `json:"code"`
+	Message   string `json:"message"`
+	ProcessId int    `json:"process_id,omitempty"`
+}
+
+// apiCommand is a command in the list of commands, Describe tells more about one of them
+type apiCommand struct {
+	Name     string                        `json:"name"`
+	Params   []yaml_config.ParamDefinition `json:"params"`
+	Tags     []string                      `json:"tags"`
+	Workflow bool                          `json:"workflow"`
+}
+
+// apiRunRequest is the body of POST /api/v1/runs, either the whole "param" text or the "params" values
+type apiRunRequest struct {
+	Command string            `json:"command"`
+	Param   string            `json:"param"`
+	Params  map[string]string `json:"params"`
+	User    string            `json:"user"`
+}
+
+type apiRun struct {
+	ProcessId int    `json:"process_id"`
+	State     string `json:"state"`
+}
+
+// Api serves the versioned json api under /api/v1, openapi.json describes every route of it.
+// Every answer is json, an error is {"error": {"code", "message"}} with the matching http status
+func Api(commandCenter *core.CommandCenter, scheduler *core.Scheduler, registry *core.ProcessRegistry, reload func() error) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		path := strings.Trim(strings.TrimPrefix(r.URL.Path, apiPrefix), "/")
+		parts := strings.Split(path, "/")
+		switch {
+		case path == "openapi.json":
+			if !allowMethod(w, r, http.MethodGet) {
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(200)
+			_, _ = w.Write(openApiDocument)
+		case path == "commands":
+			if allowMethod(w, r, http.MethodGet) {
+				apiListCommands(w, r, commandCenter)
+			}
+		case parts[0] == "commands":
+			if allowMethod(w, r, http.MethodGet) {
+				apiDescribeCommand(w, commandCenter, strings.TrimPrefix(path, "commands/"))
+			}
+		case path == "runs":
+			if allowMethod(w, r, http.MethodPost) {
+				apiStartRun(w, r, commandCenter, scheduler, registry)
+			}
+		case path == "processes":
+			if allowMethod(w, r, http.MethodGet) {
+				writeApiJson(w, 200, registry.List())
+			}
+		case parts[0] == "processes" && len(parts) <= 3:
+			apiProcess(w, r, scheduler, registry, parts[1:])
+		case path == "reload":
+			if !allowMethod(w, r, http.MethodPost) {
+				return
+			}
+			if err := reload(); err != nil {
+				writeApiError(w, 500, apiError{Code: apiErrorInternal, Message: err.Error()})
+				return
+			}
+			writeApiJson(w, 200, map[string]bool{"reloaded": true})
+		default:
+			writeApiError(w, 404, apiError{Code: apiErrorNotFound, Message: "no such route: " + r.URL.Path})
+		}
+	}
+}
+
+// apiListCommands lists the commands sorted by name, "query" keeps those containing every one of its words
+func apiListCommands(w http.ResponseWriter, r *http.Request, commandCenter *core.CommandCenter) {
+	names, err := commandCenter.GetCommandNames()
+	if err != nil {
+		writeApiError(w, 500, apiError{Code: apiErrorInternal, Message: err.Error()})
+		return
+	}
+	sort.Strings(names)
+	words := strings.Fields(strings.ToLower(r.URL.Query().Get("query")))
+	commands := []apiCommand{}
+	for _, name := range names {
+		if !containsAllWords(strings.ToLower(name), words) {
+			continue
+		}
+		options := commandCenter.GetCommandOptions(name)
+		command := apiCommand{
+			Name:     name,
+			Params:   options.Params,
+			Tags:     options.Tags,
+			Workflow: options.Workflow != nil,
+		}
+		if command.Params == nil {
+			command.Params = []yaml_config.ParamDefinition{}
+		}
+		if command.Tags == nil {
+			command.Tags = []string{}
+		}
+		commands = append(commands, command)
+	}
+	writeApiJson(w, 200, commands)
+}
+
+func containsAllWords(text string, words []string) bool {
+	for _, word := range words {
+		if !strings.Contains(text, word) {
+			return false
+		}
+	}
+	return true
+}
+
+func apiDescribeCommand(w http.ResponseWriter, commandCenter *core.CommandCenter, name string) {
+	description, err := commandCenter.Describe(name)
+	if err != nil {
+		writeApiError(w, 404, apiError{Code: apiErrorNotFound, Message: fmt.Sprintf("command %s does not exist", name)})
+		return
+	}
+	writeApiJson(w, 200, description)
+}
+
+func apiStartRun(w http.ResponseWriter, r *http.Request, commandCenter *core.CommandCenter, scheduler *core.Scheduler, registry *core.ProcessRegistry) {
+	request := apiRunRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		writeApiError(w, 400, apiError{Code: apiErrorBadRequest, Message: "the body must be a json object: " + err.Error()})
+		return
+	}
+	if request.Command == "" {
+		writeApiError(w, 400, apiError{Code: apiErrorBadRequest, Message: "command is missing"})
+		return
+	}
+	if request.Param != "" && len(request.Params) > 0 {
+		writeApiError(w, 400, apiError{Code: apiErrorBadRequest, Message: "param cannot be given with params"})
+		return
+	}
+	if _, err := commandCenter.GetCommandInfo(request.Command); err != nil {
+		writeApiError(w, 404, apiError{Code: apiErrorNotFound, Message: fmt.Sprintf("command %s does not exist", request.Command)})
+		return
+	}
+	param := request.Param
+	if len(request.Params) > 0 {
+		param = yaml_config.FormatParams(request.Params)
+	}
+	user := request.User
+	if user == "" {
+		user = getUser(r)
+	}
+	apiSubmit(w, scheduler, registry, request.Command, param, user)
+}
+
+// apiSubmit runs a command and answers with the new process, which is there even when it failed to start
+func apiSubmit(w http.ResponseWriter, scheduler *core.Scheduler, registry *core.ProcessRegistry, command string, param string, user string) {
+	processId, err := scheduler.Submit(command, param, user)
+	if err != nil {
+		answer := apiError{Code: apiErrorInternal, Message: err.Error(), ProcessId: processId}
+		status := 500
+		if errors.Is(err, core.ErrAlreadyRunning) {
+			answer.Code, status = apiErrorConflict, 409
+		} else if errors.Is(err, yaml_config.ErrInvalidParams) {
+			answer.Code, status = apiErrorBadRequest, 400
+		}
+		writeApiError(w, status, answer)
+		return
+	}
+	process, _ := registry.Get(processId)
+	writeApiJson(w, 201, apiRun{ProcessId: processId, State: process.State})
+}
+
+// apiProcess serves /processes/{id} and its actions, parts is what follows "processes" in the path
+func apiProcess(w http.ResponseWriter, r *http.Request, scheduler *core.Scheduler, registry *core.ProcessRegistry, parts []string) {
+	processId, err := strconv.Atoi(parts[0])
+	if err != nil {
+		writeApiError(w, 400, apiError{Code: apiErrorBadRequest, Message: fmt.Sprintf("%s is not a process id", strconv.Quote(parts[0]))})
+		return
+	}
+	process, ok := registry.Get(processId)
+	if !ok {
+		writeApiError(w, 404, apiError{Code: apiErrorNotFound, Message: fmt.Sprintf("process %d does not exist", processId)})
+		return
+	}
+	action := ""
+	if len(parts) > 1 {
+		action = parts[1]
+	}
+	switch action {
+	case "":
+		if allowMethod(w, r, http.MethodGet) {
+			writeApiJson(w, 200, process)
+		}
+	case "stop":
+		if !allowMethod(w, r, http.MethodPost) {
+			return
+		}
+		// closing a finished process would forget it, the api keeps that to the ui
+		if process.IsFinished() {
+			writeApiError(w, 409, apiError{Code: apiErrorConflict, Message: fmt.Sprintf("process %d has already %s", processId, process.State), ProcessId: processId})
+			return
+		}
+		if err := scheduler.Close(processId); err != nil {
+			writeApiError(w, 500, apiError{Code: apiErrorInternal, Message: err.Error(), ProcessId: processId})
+			return
+		}
+		process, _ = registry.Get(processId)
+		writeApiJson(w, 200, process)
+	case "rerun":
+		if allowMethod(w, r, http.MethodPost) {
+			apiSubmit(w, scheduler, registry, process.Command, process.Param, getUser(r))
+		}
+	case "log":
+		if allowMethod(w, r, http.MethodGet) {
+			apiReadLog(w, r, registry, processId)
+		}
+	default:
+		writeApiError(w, 404, apiError{Code: apiErrorNotFound, Message: "no such route: " + r.URL.Path})
+	}
+}
+
+// apiReadLog returns "length" bytes of the log from "offset" on, the next part starts at offset plus the length of the text
+func apiReadLog(w http.ResponseWriter, r *http.Request, registry *core.ProcessRegistry, processId int) {
+	offset, err := parseApiInt(r, "offset", 0)
+	if err != nil {
+		writeApiError(w, 400, apiError{Code: apiErrorBadRequest, Message: err.Error()})
+		return
+	}
+	length, err := parseApiInt(r, "length", defaultApiLogLength)
+	if err != nil {
+		writeApiError(w, 400, apiError{Code: apiErrorBadRequest, Message: err.Error()})
+		return
+	}
+	if length > maxApiLogLength {
+		length = maxApiLogLength
+	}
+	part, err := registry.ReadLog(processId, offset, length)
+	if err != nil {
+		writeApiError(w, 404, apiError{Code: apiErrorNotFound, Message: err.Error()})
+		return
+	}
+	writeApiJson(w, 200, part)
+}
+
+func parseApiInt(r *http.Request, key string, defaultValue int64) (int64, error) {
+	input := r.URL.Query().Get(key)
+	if input == "" {
+		return defaultValue, nil
+	}
+	value, err := strconv.ParseInt(input, 10, 64)
+	if err != nil || value < 0 {
+		return 0, fmt.Errorf("%s must be a positive number, got %s", key, strconv.Quote(input))
+	}
+	return value, nil
+}
+
+func allowMethod(w http.ResponseWriter, r *http.Request, method string) bool {
+	if r.Method == method {
+		return true
+	}
+	w.Header().Set("Allow", method)
+	writeApiError(w, 405, apiError{Code: apiErrorMethodNotAllowed, Message: fmt.Sprintf("%s %s is not allowed, use %s", r.Method, r.URL.Path, method)})
+	return false
+}
+
+func writeApiError(w http.ResponseWriter, status int, answer apiError) {
+	writeApiJson(w, status, map[string]apiError{"error": answer})
+}
+
+func writeApiJson(w http.ResponseWriter, status int, data interface{}) {
+	b, err := json.Marshal(data)
+	if err != nil {
+		handleError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_, _ = w.Write(b)
+}
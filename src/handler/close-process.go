@@ -1,11 +1,12 @@
 package handler
 
 import (
+	"core"
 	"net/http"
 	"strconv"
 )
 
-func CloseProcess(runningProcesses *map[int]string, finishedProcesses *map[int]string, storedLogs *map[int]string, forceStopChannels *map[int]chan bool) func(w http.ResponseWriter, r *http.Request) {
+func CloseProcess(scheduler *core.Scheduler) func(w http.ResponseWriter, r *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 		param := r.PostFormValue("process_id")
 		processId, err := strconv.Atoi(param)
@@ -14,15 +15,8 @@ func CloseProcess(runningProcesses *map[int]string, finishedProcesses *map[int]s
 			w.Write([]byte(err.Error()))
 			return
 		}
-		if _, ok := (*finishedProcesses)[processId]; ok {
-			delete(*finishedProcesses, processId)
-			delete(*storedLogs, processId)
-		}
-		if _, ok := (*runningProcesses)[processId]; ok {
-			(*finishedProcesses)[processId] = (*runningProcesses)[processId]
-			delete(*runningProcesses, processId)
-			(*forceStopChannels)[processId] <- true
-		}
+		// closing an unknown process is a no-op, the UI may send it twice
+		_ = scheduler.Close(processId)
 		w.WriteHeader(200)
 	}
 }
@@ -0,0 +1,19 @@
+package handler
+
+import (
+	"core"
+	"net/http"
+)
+
+// Describe returns the params of "command" so the ui can show a form before running it
+func Describe(commandCenter *core.CommandCenter) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		description, err := commandCenter.Describe(r.URL.Query().Get("command"))
+		if err != nil {
+			w.WriteHeader(404)
+			_, _ = w.Write([]byte(err.Error()))
+			return
+		}
+		writeJson(w, description)
+	}
+}
@@ -0,0 +1,76 @@
+package handler
+
+import (
+	"compress/gzip"
+	"core"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// DownloadLog sends the whole output of a process ("process_id") or a log file kept from an earlier run ("file"),
+// as plain text or gzipped with "format=gzip"
+func DownloadLog(registry *core.ProcessRegistry, logStore *core.LogStore) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		name := query.Get("file")
+		var reader io.Reader
+		if input := query.Get("process_id"); input != "" {
+			processId, err := strconv.Atoi(input)
+			if err != nil {
+				handleError(w, err)
+				return
+			}
+			process, ok := registry.Get(processId)
+			if !ok {
+				w.WriteHeader(404)
+				_, _ = w.Write([]byte(fmt.Sprintf("process %d does not exist", processId)))
+				return
+			}
+			name = process.LogFile
+			if name == "" {
+				// the process has no log file, only what is still in memory can be sent
+				text, _ := registry.GetLog(processId)
+				name = fmt.Sprintf("%d.log", processId)
+				reader = strings.NewReader(text)
+			}
+		}
+		if reader == nil {
+			path, err := logStore.Path(name)
+			if err != nil {
+				w.WriteHeader(400)
+				_, _ = w.Write([]byte(err.Error()))
+				return
+			}
+			f, err := os.Open(path)
+			if os.IsNotExist(err) {
+				w.WriteHeader(404)
+				_, _ = w.Write([]byte(fmt.Sprintf("log file %s does not exist", name)))
+				return
+			}
+			if err != nil {
+				handleError(w, err)
+				return
+			}
+			defer f.Close()
+			reader = f
+		}
+
+		if query.Get("format") == "gzip" {
+			w.Header().Set("Content-Type", "application/gzip")
+			w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name+".gz"))
+			w.WriteHeader(200)
+			gz := gzip.NewWriter(w)
+			defer gz.Close()
+			_, _ = io.Copy(gz, reader)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+		w.WriteHeader(200)
+		_, _ = io.Copy(w, reader)
+	}
+}
@@ -0,0 +1,121 @@
+package handler
+
+import (
+	"core"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const eventsHeartbeatInterval = 15 * time.Second
+
+// Events streams process output and process changes as server-sent events,
+// "process_id" limits the output to some processes and "include_log=false" leaves it out entirely, "format=html" renders it as html,
+// a reconnecting client sends Last-Event-ID (or "last_event_id") and gets every event it has missed
+func Events(registry *core.ProcessRegistry) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		f, ok := w.(http.Flusher)
+		if !ok {
+			handleError(w, fmt.Errorf("streaming is not supported"))
+			return
+		}
+		query := r.URL.Query()
+		var processIds []int
+		if input := query.Get("process_id"); input != "" {
+			for _, v := range strings.Split(input, ",") {
+				id, err := strconv.Atoi(v)
+				if err != nil {
+					handleError(w, err)
+					return
+				}
+				processIds = append(processIds, id)
+			}
+		}
+		renderers, err := newHtmlLogRenderers(query.Get("format"))
+		if err != nil {
+			writeBadRequest(w, err)
+			return
+		}
+		includeLog := query.Get("include_log") != "false"
+		if !includeLog {
+			processIds = nil
+		}
+		lastEventIdInput := r.Header.Get("Last-Event-ID")
+		if lastEventIdInput == "" {
+			lastEventIdInput = query.Get("last_event_id")
+		}
+		var lastEventId int64
+		if lastEventIdInput != "" {
+			var err error
+			lastEventId, err = strconv.ParseInt(lastEventIdInput, 10, 64)
+			if err != nil {
+				handleError(w, err)
+				return
+			}
+		}
+
+		fmt.Println("new event stream opened")
+		ctx := r.Context()
+		backlog, subscription := registry.SubscribeEvents(ctx, includeLog && len(processIds) == 0, processIds, lastEventId)
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Connection", "keep-alive")
+		w.Header().Set("X-Accel-Buffering", "no")
+		w.WriteHeader(200)
+		_, _ = w.Write([]byte("retry: 1000\n\n"))
+		for _, event := range backlog {
+			if err := writeServerSentEvent(w, event, renderers); err != nil {
+				return
+			}
+		}
+		f.Flush()
+
+		heartbeat := time.NewTicker(eventsHeartbeatInterval)
+		defer heartbeat.Stop()
+		for {
+			var err error
+			select {
+			case <-ctx.Done():
+				fmt.Println("event stream is closed")
+				return
+			case <-heartbeat.C:
+				_, err = w.Write([]byte(": heartbeat\n\n"))
+			case <-subscription.Ready():
+				for _, event := range subscription.Take() {
+					if err == nil {
+						err = writeServerSentEvent(w, event, renderers)
+					}
+				}
+			}
+			if err != nil {
+				return
+			}
+			f.Flush()
+		}
+	}
+}
+
+func writeServerSentEvent(w http.ResponseWriter, event core.Event, renderers htmlLogRenderers) error {
+	var data interface{}
+	switch {
+	case event.Log != nil:
+		frame := newLogEventFrame(event)
+		frame.NextOffset = frame.Offset + int64(len(frame.Text)) + frame.Skipped
+		renderers.renderFrame(&frame)
+		data = frame
+	case event.Process != nil:
+		data = event.Process
+	default:
+		data = map[string]interface{}{"timestamp": event.Time}
+	}
+	b, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.Id, event.Type, b)
+	return err
+}
@@ -0,0 +1,80 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"repository"
+	"strconv"
+	"time"
+)
+
+// History searches the run history by "command", "text", "state" and a "from" / "to" date, newest runs first
+func History(history *repository.RunHistory) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		filter := repository.RunFilter{
+			Command: query.Get("command"),
+			Text:    query.Get("text"),
+			State:   query.Get("state"),
+			Limit:   100,
+		}
+		var err error
+		if input := query.Get("limit"); input != "" {
+			if filter.Limit, err = strconv.Atoi(input); err != nil {
+				writeBadRequest(w, err)
+				return
+			}
+		}
+		if filter.From, err = parseHistoryDate(query.Get("from"), false); err != nil {
+			writeBadRequest(w, err)
+			return
+		}
+		if filter.To, err = parseHistoryDate(query.Get("to"), true); err != nil {
+			writeBadRequest(w, err)
+			return
+		}
+		writeJson(w, history.Search(filter))
+	}
+}
+
+// HistoryStats returns success rate, average and p95 duration and the last run of every command, or of "command" only
+func HistoryStats(history *repository.RunHistory) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJson(w, history.Stats(r.URL.Query().Get("command")))
+	}
+}
+
+// parseHistoryDate accepts a day or a full RFC 3339 time, a day used as upper bound includes the whole day
+func parseHistoryDate(input string, endOfDay bool) (time.Time, error) {
+	if input == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, input); err == nil {
+		return t, nil
+	}
+	t, err := time.ParseInLocation("2006-01-02", input, time.Local)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid date %s, expecting 2006-01-02 or RFC 3339", input)
+	}
+	if endOfDay {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t, nil
+}
+
+func writeJson(w http.ResponseWriter, data interface{}) {
+	b, err := json.Marshal(data)
+	if err != nil {
+		handleError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	_, _ = w.Write(b)
+}
+
+func writeBadRequest(w http.ResponseWriter, err error) {
+	w.WriteHeader(400)
+	_, _ = w.Write([]byte(err.Error()))
+}
@@ -0,0 +1,61 @@
+package handler
+
+import (
+	"common"
+	"fmt"
+)
+
+const (
+	LogFormatText = "text"
+	LogFormatHtml = "html"
+)
+
+// htmlLogRenderers renders the output of each process with its own renderer, a nil value means raw text is wanted
+type htmlLogRenderers map[int]*common.AnsiHtmlRenderer
+
+// newHtmlLogRenderers reads the "format" a subscriber asked for, text by default
+func newHtmlLogRenderers(format string) (htmlLogRenderers, error) {
+	switch format {
+	case "", LogFormatText:
+		return nil, nil
+	case LogFormatHtml:
+		return htmlLogRenderers{}, nil
+	}
+	return nil, fmt.Errorf("unknown log format %s, it should be %s or %s", format, LogFormatText, LogFormatHtml)
+}
+
+func (this htmlLogRenderers) render(processId int, text string) string {
+	renderer, ok := this[processId]
+	if !ok {
+		renderer = common.NewAnsiHtmlRenderer()
+		this[processId] = renderer
+	}
+	return renderer.Render(text)
+}
+
+// reset forgets the state of a process, e.g. when the output it was in the middle of has been skipped
+func (this htmlLogRenderers) reset(processId int) {
+	delete(this, processId)
+}
+
+// renderText returns text as it is when raw text is wanted
+func (this htmlLogRenderers) renderText(processId int, text string) string {
+	if this == nil {
+		return text
+	}
+	return this.render(processId, text)
+}
+
+// renderFrame moves the text of a log frame to its html field
+func (this htmlLogRenderers) renderFrame(frame *LogFrame) {
+	if this == nil {
+		return
+	}
+	switch frame.Type {
+	case "log":
+		frame.Html = this.render(frame.ProcessId, frame.Text)
+		frame.Text = ""
+	case "skipped":
+		this.reset(frame.ProcessId)
+	}
+}
@@ -0,0 +1,228 @@
+package handler
+
+import (
+	"context"
+	"core"
+	"fmt"
+	"github.com/gorilla/websocket"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+type LogFrame struct {
+	Type       string    `json:"type"`
+	ProcessId  int       `json:"process_id,omitempty"`
+	Stream     string    `json:"stream,omitempty"`
+	Timestamp  time.Time `json:"timestamp"`
+	Offset     int64     `json:"offset"`
+	NextOffset int64     `json:"next_offset,omitempty"`
+	Text       string    `json:"text,omitempty"`
+	Html       string    `json:"html,omitempty"`
+	Skipped    int64     `json:"skipped,omitempty"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// LogSocketMessage is sent by the client, "offsets" maps process ids to the offset it has already received
+// so a reconnecting client gets the rest of the output without duplicates.
+// The "input" and "resize" actions act on the running process "process_id"
+type LogSocketMessage struct {
+	Action     string           `json:"action"`
+	ProcessIds []int            `json:"process_ids"`
+	All        bool             `json:"all"`
+	Offsets    map[string]int64 `json:"offsets"`
+	ProcessId  int              `json:"process_id"`
+	Text       string           `json:"text"`
+	Close      bool             `json:"close"`
+	Rows       uint16           `json:"rows"`
+	Cols       uint16           `json:"cols"`
+}
+
+var logSocketUpgrader = websocket.Upgrader{
+	CheckOrigin: func(r *http.Request) bool {
+		return true
+	},
+}
+
+// LogSocket streams process logs as json frames over a websocket,
+// the client sends subscribe / unsubscribe messages to choose which processes to watch,
+// with "format=html" log frames carry the output rendered as html instead of raw text
+func LogSocket(registry *core.ProcessRegistry) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		renderers, err := newHtmlLogRenderers(r.URL.Query().Get("format"))
+		if err != nil {
+			writeBadRequest(w, err)
+			return
+		}
+		conn, err := logSocketUpgrader.Upgrade(w, r, nil)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		defer conn.Close()
+		fmt.Println("new websocket opened for viewing log")
+		// the context ends when either the client or this handler goes away, the registry then drops the subscription
+		ctx, cancel := context.WithCancel(r.Context())
+		defer cancel()
+		subscription := registry.Subscribe(ctx, nil)
+		index := subscription.Id
+
+		// the reader goroutine only parses messages, the loop below handles them so backlog and live output stay in order
+		messages := make(chan LogSocketMessage)
+		go func() {
+			defer cancel()
+			for {
+				message := LogSocketMessage{}
+				err := conn.ReadJSON(&message)
+				if err != nil {
+					return
+				}
+				select {
+				case messages <- message:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+
+		sent := map[int]int64{}
+		send := func(frame LogFrame) error {
+			switch frame.Type {
+			case "log":
+				// skip what the client already has, e.g. a live chunk overlapping the backlog
+				end := frame.Offset + int64(len(frame.Text))
+				if end <= sent[frame.ProcessId] {
+					return nil
+				}
+				if frame.Offset < sent[frame.ProcessId] {
+					frame.Text = frame.Text[sent[frame.ProcessId]-frame.Offset:]
+					frame.Offset = sent[frame.ProcessId]
+				}
+				sent[frame.ProcessId] = end
+				frame.NextOffset = end
+			case "skipped":
+				end := frame.Offset + frame.Skipped
+				if end <= sent[frame.ProcessId] {
+					return nil
+				}
+				if frame.Offset < sent[frame.ProcessId] {
+					frame.Skipped = end - sent[frame.ProcessId]
+					frame.Offset = sent[frame.ProcessId]
+				}
+				sent[frame.ProcessId] = end
+				frame.NextOffset = end
+			}
+			renderers.renderFrame(&frame)
+			return conn.WriteJSON(frame)
+		}
+		handle := func(message LogSocketMessage) error {
+			switch message.Action {
+			case "subscribe":
+				if message.All {
+					registry.SetWatchAll(index, true)
+				}
+				// the client tells what it already has, anything else is sent again from the start
+				processIds := message.ProcessIds
+				for _, processId := range processIds {
+					sent[processId] = 0
+				}
+				for key, offset := range message.Offsets {
+					processId, err := strconv.Atoi(key)
+					if err != nil {
+						return send(LogFrame{Type: "error", Timestamp: time.Now(), Error: err.Error()})
+					}
+					sent[processId] = offset
+					if !containsInt(processIds, processId) {
+						processIds = append(processIds, processId)
+					}
+				}
+				for _, processId := range processIds {
+					renderers.reset(processId)
+				}
+				for _, processId := range processIds {
+					var sendErr error
+					err := registry.Watch(index, processId, sent[processId], func(item core.LogItem) error {
+						sendErr = send(newLogFrame(item))
+						return sendErr
+					})
+					if err != nil && sendErr == nil {
+						sendErr = send(LogFrame{Type: "error", ProcessId: processId, Timestamp: time.Now(), Error: err.Error()})
+					}
+					if sendErr != nil {
+						return sendErr
+					}
+				}
+			case "unsubscribe":
+				if message.All {
+					registry.SetWatchAll(index, false)
+				}
+				for _, processId := range message.ProcessIds {
+					registry.Unwatch(index, processId)
+				}
+			case "input":
+				err := registry.WriteInput(message.ProcessId, message.Text)
+				if err == nil && message.Close {
+					err = registry.CloseInput(message.ProcessId)
+				}
+				if err != nil {
+					return send(LogFrame{Type: "error", ProcessId: message.ProcessId, Timestamp: time.Now(), Error: err.Error()})
+				}
+			case "resize":
+				if err := registry.Resize(message.ProcessId, message.Rows, message.Cols); err != nil {
+					return send(LogFrame{Type: "error", ProcessId: message.ProcessId, Timestamp: time.Now(), Error: err.Error()})
+				}
+			default:
+				return send(LogFrame{Type: "error", Timestamp: time.Now(), Error: "unknown action " + message.Action})
+			}
+			return nil
+		}
+		for {
+			var err error
+			select {
+			case <-ctx.Done():
+				fmt.Println("websocket for viewing log is closed")
+				return
+			case message := <-messages:
+				err = handle(message)
+			case <-subscription.Ready():
+				for _, event := range subscription.Take() {
+					if err == nil {
+						err = send(newLogEventFrame(event))
+					}
+				}
+			}
+			if err != nil {
+				return
+			}
+		}
+	}
+}
+
+func newLogFrame(item core.LogItem) LogFrame {
+	return LogFrame{
+		Type:      "log",
+		ProcessId: item.ProcessId,
+		Stream:    item.Stream,
+		Timestamp: item.Time,
+		Offset:    item.Offset,
+		Text:      item.Log,
+	}
+}
+
+func newLogEventFrame(event core.Event) LogFrame {
+	frame := newLogFrame(*event.Log)
+	if event.Type == core.EventLogSkipped {
+		frame.Type = "skipped"
+		frame.Skipped = event.Skipped
+	}
+	return frame
+}
+
+func containsInt(haystack []int, needle int) bool {
+	for _, v := range haystack {
+		if v == needle {
+			return true
+		}
+	}
+	return false
+}
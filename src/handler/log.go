@@ -1,20 +1,23 @@
 package handler
 
 import (
+	"core"
 	"fmt"
 	"net/http"
 	"strconv"
 	"strings"
 )
 
-func Log(runningProcesses *map[int]string,
-	finishedProcesses *map[int]string,
-	storedLogs *map[int]string,
-	logWatcherAutoIncrementId *int,
-	logWatcherChannels *map[int]chan LogItem) func(w http.ResponseWriter, r *http.Request) {
+// Log streams the output of some processes, or of all of them, as plain text or with "format=html" as an html page
+func Log(registry *core.ProcessRegistry) func(w http.ResponseWriter, r *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 		fmt.Println("new connection opened for viewing log")
 		query := r.URL.Query()
+		renderers, err := newHtmlLogRenderers(query.Get("format"))
+		if err != nil {
+			writeBadRequest(w, err)
+			return
+		}
 		input := query.Get("process_id")
 		var processIdsForWatching []int
 		if input != "" {
@@ -22,89 +25,79 @@ func Log(runningProcesses *map[int]string,
 			for _, v := range tmp {
 				id, err := strconv.Atoi(v)
 				if err != nil {
-					handleError(w, err, *logWatcherChannels, 0)
+					handleError(w, err)
+					return
 				}
 				processIdsForWatching = append(processIdsForWatching, id)
 			}
 		}
 
-
 		// filter invalid processIds
 		var filtered []int
 		for _, processIdForWatching := range processIdsForWatching {
-			found := false
-			for runningProcessId := range *runningProcesses {
-				if runningProcessId == processIdForWatching {
-					found = true
-					break
-				}
-			}
-			if found {
-				filtered = append(filtered, processIdForWatching)
-			}
-		}
-		for _, processIdForWatching := range processIdsForWatching {
-			found := false
-			for finishedProcessId := range *finishedProcesses {
-				if finishedProcessId == processIdForWatching {
-					found = true
-					break
-				}
-			}
-			if found {
+			if registry.Exists(processIdForWatching) {
 				filtered = append(filtered, processIdForWatching)
 			}
 		}
 		processIdsForWatching = filtered
 
+		if renderers != nil {
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		}
 		w.WriteHeader(200)
 		if f, ok := w.(http.Flusher); ok {
 
 			// work-around for buffering
 			_, _ = w.Write([]byte(strings.Repeat(" ", 5000)))
+			if renderers != nil {
+				_, _ = w.Write([]byte(`<pre style="white-space: pre-wrap">`))
+			}
 			f.Flush()
 
-			logChannel := make(chan LogItem, 10000)
-			*logWatcherAutoIncrementId++
-			index := *logWatcherAutoIncrementId
-			(*logWatcherChannels)[index] = logChannel
-			notify := w.(http.CloseNotifier).CloseNotify()
-			go func() {
-				<-notify
-				fmt.Println("connection for viewing log is closed")
-				delete(*logWatcherChannels, index)
-			}()
+			// the subscription is dropped by the registry once the request context is done
+			ctx := r.Context()
+			var subscription *core.Subscription
+			if len(processIdsForWatching) == 0 {
+				subscription = registry.SubscribeAll(ctx)
+			} else {
+				subscription = registry.Subscribe(ctx, nil)
+			}
 
 			// display stored log for this log channel
 			for _, v := range processIdsForWatching {
-				if val, ok := (*storedLogs)[v]; ok {
-					_,_ = w.Write([]byte(val))
-				}
+				_ = registry.Watch(subscription.Id, v, 0, func(item core.LogItem) error {
+					_, err := w.Write([]byte(renderers.renderText(v, item.Log)))
+					return err
+				})
 			}
 			f.Flush()
 
 			for {
-				logItem := <- logChannel
-				// subscribe to all process logs
-				if len(processIdsForWatching) == 0 {
-					_, err := w.Write([]byte(logItem.Log))
-					if err != nil {
-						return
-					}
-					f.Flush()
-					continue
-				}
-				// subscribe to only selected process logs
-				for _, v := range processIdsForWatching {
-					if v == logItem.ProcessId {
-						_, err := w.Write([]byte(logItem.Log))
+				select {
+				case <-ctx.Done():
+					fmt.Println("connection for viewing log is closed")
+					return
+				case <-subscription.Ready():
+					for _, event := range subscription.Take() {
+						if event.Type == core.EventLogSkipped {
+							renderers.reset(event.Log.ProcessId)
+						}
+						_, err := w.Write([]byte(renderers.renderText(event.Log.ProcessId, getLogText(event))))
 						if err != nil {
 							return
 						}
-						f.Flush()
 					}
+					f.Flush()
 				}
 			}
 		}
 	}
 }
+
+// getLogText returns the output carried by a log event, or a marker telling how much output a slow watcher has missed
+func getLogText(event core.Event) string {
+	if event.Type == core.EventLogSkipped {
+		return fmt.Sprintf("\n... %d bytes skipped ...\n", event.Skipped)
+	}
+	return event.Log.Log
+}
@@ -1,98 +1,51 @@
 package handler
 
 import (
-	"common"
 	"core"
-	"fmt"
+	"errors"
+	"net"
 	"net/http"
-	"os"
 	"strings"
 	"yaml_config"
 )
 
-func RunCommand(processAutoIncrementId *int,
-	commandCenter *core.CommandCenter,
-	finishedProcesses *map[int]string,
-	logWatcherChannels *map[int]chan LogItem,
-	runningProcesses *map[int]string,
-	storedLogs *map[int]string,
-	config *yaml_config.Config,
-	forceStopChannels *map[int]chan bool) func(w http.ResponseWriter, r *http.Request) {
-	maxStoredLogCharacters, err := config.GetIntByKey("maximum stdout characters to be stored for a process")
-	PanicOnError(err)
+// RunCommand runs "command", written as "name" or "name: params", only the first ":" separates them.
+// The params can also be sent alone in "param", "command" is then only the name
+func RunCommand(scheduler *core.Scheduler) func(w http.ResponseWriter, r *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
-		fullCommand := r.PostFormValue("command")
-		command := fullCommand
-		param := ""
-		if strings.Contains(command, ":") {
-			pieces := strings.Split(command, ":")
-			command = pieces[0]
-			param = pieces[1]
+		command := r.PostFormValue("command")
+		param := r.PostFormValue("param")
+		if _, ok := r.PostForm["param"]; !ok {
+			if k := strings.Index(command, ":"); k >= 0 {
+				command, param = command[:k], command[k+1:]
+			}
 		}
-		*processAutoIncrementId++
-		processId := *processAutoIncrementId
-		commandToBeExecuted, err := commandCenter.GetCommandInfo(command)
-		if err != nil {
-			(*finishedProcesses)[processId] = "NOT FOUND: " + fullCommand
-			handleError(w, err, *logWatcherChannels, 0)
+		_, err := scheduler.Submit(command, param, getUser(r))
+		if errors.Is(err, core.ErrAlreadyRunning) {
+			w.WriteHeader(409)
+			_, _ = w.Write([]byte(err.Error()))
 			return
 		}
-		(*runningProcesses)[processId] = fullCommand
-		fmt.Printf("START command %s\n", fullCommand)
-
-		// append file
-		f, err := os.OpenFile("history.txt", os.O_APPEND|os.O_WRONLY, 0777)
-		if err != nil {
-			panic(err)
-		}
-		defer f.Close()
-		if _, err = f.WriteString(fullCommand + "\n"); err != nil {
-			panic(err)
+		if errors.Is(err, yaml_config.ErrInvalidParams) {
+			writeBadRequest(w, err)
+			return
 		}
-
-		writer := func(text string) {
-			(*storedLogs)[processId] += text // WARNING: should mutex lock to prevent concurrent write
-			if len((*storedLogs)[processId]) > maxStoredLogCharacters {
-				(*storedLogs)[processId] = (*storedLogs)[processId][int(maxStoredLogCharacters / 5):]
-			}
-			item := LogItem{
-				ProcessId: processId,
-				Log:       text,
-			}
-			for _, ch := range *logWatcherChannels {
-				ch <- item
-			}
+		if err != nil {
+			handleError(w, err)
+			return
 		}
-		forceStopChan := make(chan bool)
-		(*forceStopChannels)[processId] = forceStopChan
-		go func() {
-			writer(">>> RUNNING COMMAND " + fullCommand + "\n")
-			err = commandToBeExecuted(writer, param, forceStopChan)
-			writer(fmt.Sprintf(">>> END COMMAND command %s\n", fullCommand))
-			delete((*forceStopChannels), processId)
-			delete(*runningProcesses, processId)
-			(*finishedProcesses)[processId] = fullCommand
-			if err != nil {
-
-				// store error log
-				// @todo duplicate with store stdout log from /run route
-				maxStoredLogCharacters, err2 := config.GetIntByKey("maximum stdout characters to be stored for a process")
-				common.PanicOnError(err2)
-				(*storedLogs)[processId] += err.Error()
-				if len((*storedLogs)[processId]) > maxStoredLogCharacters {
-					(*storedLogs)[processId] = (*storedLogs)[processId][int(maxStoredLogCharacters / 5):]
-				}
-
-				item := LogItem{
-					ProcessId: processId,
-					Log:       "ERROR: " + err.Error(),
-				}
-				for _, ch := range *logWatcherChannels {
-					ch <- item
-				}
-				return
-			}
-		}()
 		w.WriteHeader(200)
 	}
 }
+
+// getUser returns who sent the request, there is no login so it is the "user" field or the client address
+func getUser(r *http.Request) string {
+	if user := r.PostFormValue("user"); user != "" {
+		return user
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,29 @@
+package handler
+
+import (
+	"core"
+	"net/http"
+)
+
+// Schedule lists the entries of schedule.yml with their next runs
+func Schedule(schedule *core.Schedule) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJson(w, schedule.List())
+	}
+}
+
+// EnableSchedule enables or disables the entry "name" of schedule.yml until the server restarts
+func EnableSchedule(schedule *core.Schedule, enabled bool) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(405)
+			return
+		}
+		if err := schedule.SetEnabled(r.PostFormValue("name"), enabled); err != nil {
+			w.WriteHeader(404)
+			_, _ = w.Write([]byte(err.Error()))
+			return
+		}
+		w.WriteHeader(200)
+	}
+}
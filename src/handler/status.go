@@ -1,42 +1,39 @@
 package handler
 
 import (
+	"core"
 	"encoding/json"
 	"net/http"
-	"sort"
-	"strings"
 )
 
-func Status(runningProcceses *map[int]string,
-	finishedProcesses *map[int]string) func(w http.ResponseWriter, r *http.Request) {
+func Status(registry *core.ProcessRegistry) func(w http.ResponseWriter, r *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
+		// ResultItem is what running_process_ids has always held, the whole processes are in running_processes
 		type ResultItem struct {
-			Command string `json:"command"`
-			ProcessId int `json:"process_id"`
+			Command   string `json:"command"`
+			ProcessId int    `json:"process_id"`
 		}
 		type Result struct {
-			RunningJobs  []ResultItem `json:"running_process_ids"`
-			FinishedJobs []ResultItem `json:"finished_jobs"`
+			RunningJobs      []ResultItem   `json:"running_process_ids"`
+			RunningProcesses []core.Process `json:"running_processes"`
+			FinishedJobs     []core.Process `json:"finished_jobs"`
 		}
-		res := &Result{}
-		for k := range *finishedProcesses {
-			res.FinishedJobs = append(res.FinishedJobs, ResultItem{
-				Command:   (*finishedProcesses)[k],
-				ProcessId: k,
-			})
+		res := &Result{
+			RunningJobs:      []ResultItem{},
+			RunningProcesses: []core.Process{},
+			FinishedJobs:     []core.Process{},
 		}
-		for k := range *runningProcceses {
-			res.RunningJobs = append(res.RunningJobs, ResultItem{
-				Command:   (*runningProcceses)[k],
-				ProcessId: k,
-			})
+		// processes are already sorted by start time, running_processes also has the queued ones
+		for _, process := range registry.List() {
+			if process.IsFinished() {
+				res.FinishedJobs = append(res.FinishedJobs, process)
+				continue
+			}
+			res.RunningProcesses = append(res.RunningProcesses, process)
+			if process.State != core.ProcessStateQueued {
+				res.RunningJobs = append(res.RunningJobs, ResultItem{Command: process.Command, ProcessId: process.Id})
+			}
 		}
-		sort.Slice(res.RunningJobs, func(i, j int) bool {
-			return strings.Compare(res.RunningJobs[i].Command, res.RunningJobs[j].Command) > 0
-		})
-		sort.Slice(res.FinishedJobs, func(i, j int) bool {
-			return strings.Compare(res.FinishedJobs[i].Command, res.FinishedJobs[j].Command) > 0
-		})
 		b, err := json.Marshal(res)
 		if err != nil {
 			w.WriteHeader(500)
@@ -0,0 +1,36 @@
+package handler
+
+import (
+	"core"
+	"net/http"
+	"strconv"
+)
+
+// Stdin writes "text" to the standard input of a running process, "close=1" sends end of file afterwards
+func Stdin(registry *core.ProcessRegistry) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		processId, err := strconv.Atoi(r.PostFormValue("process_id"))
+		if err != nil {
+			writeBadRequest(w, err)
+			return
+		}
+		if text := r.PostFormValue("text"); text != "" {
+			if err := registry.WriteInput(processId, text); err != nil {
+				writeConflict(w, err)
+				return
+			}
+		}
+		if closeInput, _ := strconv.ParseBool(r.PostFormValue("close")); closeInput {
+			if err := registry.CloseInput(processId); err != nil {
+				writeConflict(w, err)
+				return
+			}
+		}
+		w.WriteHeader(200)
+	}
+}
+
+func writeConflict(w http.ResponseWriter, err error) {
+	w.WriteHeader(409)
+	_, _ = w.Write([]byte(err.Error()))
+}
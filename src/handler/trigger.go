@@ -0,0 +1,138 @@
+package handler
+
+import (
+	"core"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"yaml_config"
+)
+
+// triggerAnswer tells a caller of a trigger about the run it started, ExitCode and LogTail are set once it waited
+type triggerAnswer struct {
+	ProcessId int    `json:"process_id"`
+	State     string `json:"state"`
+	ExitCode  *int   `json:"exit_code,omitempty"`
+	Error     string `json:"error,omitempty"`
+	LogTail   string `json:"log_tail,omitempty"`
+}
+
+// Trigger runs the command of the trigger.yml entry whose token ends the path, /trigger/<token>, with the params
+// sent as form values. With "wait" set to true, or to a duration, the answer only comes once the run has finished,
+// or the wait timeout of the trigger is over, with the exit code and the tail of the log.
+// The answer is 200 for a finished run, 202 for one still queued or running
+func Trigger(triggers *core.Triggers, commandCenter *core.CommandCenter, scheduler *core.Scheduler, registry *core.ProcessRegistry) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(405)
+			return
+		}
+		name, trigger, ok := triggers.Find(strings.TrimPrefix(r.URL.Path, "/trigger/"))
+		if !ok {
+			w.WriteHeader(404)
+			_, _ = w.Write([]byte("unknown trigger"))
+			return
+		}
+		if err := r.ParseForm(); err != nil {
+			writeBadRequest(w, err)
+			return
+		}
+		wait, err := parseTriggerWait(r.Form.Get("wait"), trigger.GetWaitTimeout())
+		if err != nil {
+			writeBadRequest(w, err)
+			return
+		}
+		given := map[string]string{}
+		for key := range r.Form {
+			if key != "wait" {
+				given[key] = r.Form.Get(key)
+			}
+		}
+		param, err := trigger.ParamText(given, commandCenter.GetCommandOptions(trigger.Command).Params)
+		if err != nil {
+			writeBadRequest(w, err)
+			return
+		}
+		processId, err := scheduler.Submit(trigger.Command, param, core.TriggerUser+" "+name)
+		if err != nil {
+			status := 500
+			if errors.Is(err, core.ErrAlreadyRunning) {
+				status = 409
+			} else if errors.Is(err, yaml_config.ErrInvalidParams) {
+				status = 400
+			}
+			writeTriggerAnswer(w, status, triggerAnswer{ProcessId: processId, State: core.ProcessStateFailed, Error: err.Error()})
+			return
+		}
+		process, _ := registry.Get(processId)
+		answer := triggerAnswer{ProcessId: processId, State: process.State}
+		if wait > 0 {
+			process = waitForProcess(registry, processId, wait, r)
+			answer = triggerAnswer{
+				ProcessId: processId,
+				State:     process.State,
+				ExitCode:  process.ExitCode,
+				Error:     process.Error,
+				LogTail:   registry.GetLogTail(processId, trigger.GetLogLines()),
+			}
+		}
+		status := 202
+		if process.IsFinished() {
+			status = 200
+		}
+		writeTriggerAnswer(w, status, answer)
+	}
+}
+
+// parseTriggerWait reads how long a caller waits: nothing or false for not at all, true for the longest allowed
+func parseTriggerWait(input string, longest time.Duration) (time.Duration, error) {
+	if input == "" {
+		return 0, nil
+	}
+	if wait, err := strconv.ParseBool(input); err == nil {
+		if wait {
+			return longest, nil
+		}
+		return 0, nil
+	}
+	wait, err := time.ParseDuration(input)
+	if err != nil || wait < 0 {
+		return 0, fmt.Errorf("wait must be true, false or a duration, got %s", strconv.Quote(input))
+	}
+	if wait > longest {
+		wait = longest
+	}
+	return wait, nil
+}
+
+// waitForProcess returns the process once it has finished, or as it is when timeout is over or the caller went away
+func waitForProcess(registry *core.ProcessRegistry, processId int, timeout time.Duration, r *http.Request) core.Process {
+	done := make(chan core.Process, 1)
+	go func() {
+		process, _ := registry.Wait(processId)
+		done <- process
+	}()
+	select {
+	case process := <-done:
+		return process
+	case <-time.After(timeout):
+	case <-r.Context().Done():
+	}
+	process, _ := registry.Get(processId)
+	return process
+}
+
+func writeTriggerAnswer(w http.ResponseWriter, status int, answer triggerAnswer) {
+	b, err := json.Marshal(answer)
+	if err != nil {
+		handleError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_, _ = w.Write(b)
+}
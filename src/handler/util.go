@@ -3,14 +3,11 @@ package handler
 import (
 	"common"
 	"core"
-	"encoding/json"
 	"fmt"
 	"github.com/fsnotify/fsnotify"
-	"gopkg.in/yaml.v2"
 	"io/ioutil"
 	"net/http"
 	"os/user"
-	"strings"
 	"sync"
 	"time"
 	"yaml_config"
@@ -44,8 +41,7 @@ func GetConfig() (*yaml_config.Config, error) {
 	if err != nil {
 		return nil, err
 	}
-	configData := map[string]string{}
-	err = yaml.Unmarshal(b, configData)
+	configData, env, err := yaml_config.ParseConfig(b)
 	if err != nil {
 		return nil, err
 	}
@@ -55,17 +51,18 @@ func GetConfig() (*yaml_config.Config, error) {
 	}
 	b, err = ioutil.ReadFile("config/config."+u.Username+".yml")
 	if err == nil {
-		additionalConfigData := map[string]string{}
-		err := yaml.Unmarshal(b, additionalConfigData)
+		additionalConfigData, additionalEnv, err := yaml_config.ParseConfig(b)
 		if err != nil {
 			return nil, err
 		}
 		configData = common.StringArrayMerge(configData, additionalConfigData)
+		env = common.StringArrayMerge(env, additionalEnv)
 	}
 	config, err := yaml_config.NewConfig(configData)
 	if err != nil {
 		return nil, err
 	}
+	config.SetEnv(env)
 	return config, nil
 }
 
@@ -107,47 +104,10 @@ func GetFuzzySearch(commandCenter *core.CommandCenter) (*common.FuzzySearch, err
 	return common.NewFuzzySearch(lines), nil
 }
 
-func handleError(w http.ResponseWriter, err error, logWatcherChannels map[int]chan LogItem, processId int) {
+func handleError(w http.ResponseWriter, err error) {
 	fmt.Println(err)
 	w.WriteHeader(500)
-	if processId == 0 {
-		_,_ = w.Write([]byte(err.Error()))
-		return
-	}
-	item := LogItem{
-		ProcessId: processId,
-		Log:       err.Error(),
-	}
-	for k := range logWatcherChannels {
-		logWatcherChannels[k] <- item
-	}
-}
-
-func getHistory() ([]byte, error) {
-	b, err := ioutil.ReadFile("history.txt")
-	if err != nil {
-		return nil, err
-	}
-	history := string(b)
-	lines := strings.Split(history, "\n")
-	m := map[string]bool{}
-	var res []string
-	for a := len(lines)  - 1;a >= 0;a-- {
-		v := lines[a]
-		if v == "" {
-			continue
-		}
-		if _, ok := m[v]; !ok {
-			m[v] = true
-			res = append(res, v)
-		}
-	}
-	return json.Marshal(res)
-}
-
-type LogItem struct {
-	ProcessId int
-	Log string
+	_,_ = w.Write([]byte(err.Error()))
 }
 
 func StartWatcher(reloadFn func()) {
@@ -158,16 +118,17 @@ func StartWatcher(reloadFn func()) {
 	err = watcher.Add("formula")
 	common.PanicOnError(err)
 	var changes []string
+	var mutex sync.Mutex
 
 	// consumer
 	go func() {
-		var mutex sync.Mutex
 		for {
 			time.Sleep(time.Second)
-			if len(changes) > 0 {
-				mutex.Lock()
-				changes = nil
-				mutex.Unlock()
+			mutex.Lock()
+			changed := len(changes) > 0
+			changes = nil
+			mutex.Unlock()
+			if changed {
 				reloadFn()
 			}
 		}
@@ -178,7 +139,9 @@ func StartWatcher(reloadFn func()) {
 		for {
 			select {
 			case event := <-watcher.Events:
+				mutex.Lock()
 				changes = append(changes, event.Name)
+				mutex.Unlock()
 				fmt.Printf("EVENT! %#v\n", event)
 			case err := <-watcher.Errors:
 				fmt.Println("ERROR", err)
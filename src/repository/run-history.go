@@ -0,0 +1,252 @@
+package repository
+
+import (
+	"bufio"
+	"common"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RunRecord is one finished run, records imported from the old history.txt only have a command and a param
+type RunRecord struct {
+	ProcessId  int               `json:"process_id"`
+	Command    string            `json:"command"`
+	Param      string            `json:"param"`
+	User       string            `json:"user,omitempty"`
+	State      string            `json:"state"`
+	ExitCode   *int              `json:"exit_code"`
+	Error      string            `json:"error,omitempty"`
+	StartedAt  time.Time         `json:"started_at"`
+	FinishedAt *time.Time        `json:"finished_at"`
+	Duration   int64             `json:"duration_ms"`
+	LogFile    string            `json:"log_file,omitempty"`
+	Variables  map[string]string `json:"variables,omitempty"`
+	Schedule   string            `json:"schedule,omitempty"`
+	Attempts   []common.Attempt  `json:"attempts,omitempty"`
+}
+
+func (this *RunRecord) FullCommand() string {
+	if this.Param == "" {
+		return this.Command
+	}
+	return this.Command + ":" + this.Param
+}
+
+// RunFilter selects runs for RunHistory.Search, empty fields match everything
+type RunFilter struct {
+	Command string
+	Text    string
+	State   string
+	From    time.Time
+	To      time.Time
+	Limit   int
+}
+
+func (this *RunFilter) match(record RunRecord) bool {
+	if this.Command != "" && record.Command != this.Command {
+		return false
+	}
+	if this.State != "" && record.State != this.State {
+		return false
+	}
+	if !this.From.IsZero() && record.StartedAt.Before(this.From) {
+		return false
+	}
+	if !this.To.IsZero() && !record.StartedAt.Before(this.To) {
+		return false
+	}
+	if this.Text != "" {
+		text := strings.ToLower(this.Text)
+		haystack := strings.ToLower(record.FullCommand() + "\n" + record.User + "\n" + record.Error)
+		if !strings.Contains(haystack, text) {
+			return false
+		}
+	}
+	return true
+}
+
+type CommandStats struct {
+	Command         string     `json:"command"`
+	Runs            int        `json:"runs"`
+	Succeeded       int        `json:"succeeded"`
+	SuccessRate     float64    `json:"success_rate"`
+	AverageDuration int64      `json:"average_duration_ms"`
+	P95Duration     int64      `json:"p95_duration_ms"`
+	LastRunAt       *time.Time `json:"last_run_at"`
+	LastState       string     `json:"last_state"`
+}
+
+// RunHistory keeps every finished run in a json lines file, one record per line, and in memory for searching
+type RunHistory struct {
+	mutex   sync.Mutex
+	path    string
+	records []RunRecord
+}
+
+func NewRunHistory(path string) (*RunHistory, error) {
+	history := &RunHistory{path: path}
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return history, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		record := RunRecord{}
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			fmt.Printf("skipping broken line in %s: %s\n", path, err)
+			continue
+		}
+		history.records = append(history.records, record)
+	}
+	return history, scanner.Err()
+}
+
+// ImportLegacy adds the commands of the old history.txt when the run history is still empty
+func (this *RunHistory) ImportLegacy(path string) error {
+	this.mutex.Lock()
+	empty := len(this.records) == 0
+	this.mutex.Unlock()
+	if !empty {
+		return nil
+	}
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			continue
+		}
+		record := RunRecord{Command: line}
+		if k := strings.Index(line, ":"); k >= 0 {
+			record.Command = line[:k]
+			record.Param = line[k+1:]
+		}
+		if err := this.Add(record); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+func (this *RunHistory) Add(record RunRecord) error {
+	b, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	f, err := os.OpenFile(this.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err = f.Write(append(b, '\n')); err != nil {
+		return err
+	}
+	this.records = append(this.records, record)
+	return nil
+}
+
+// Search returns the matching runs, newest first
+func (this *RunHistory) Search(filter RunFilter) []RunRecord {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	output := []RunRecord{}
+	for k := len(this.records) - 1; k >= 0; k-- {
+		if filter.Limit > 0 && len(output) >= filter.Limit {
+			break
+		}
+		if filter.match(this.records[k]) {
+			output = append(output, this.records[k])
+		}
+	}
+	return output
+}
+
+// RecentCommands returns the distinct full commands that have been run, newest first
+func (this *RunHistory) RecentCommands(limit int) []string {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	output := []string{}
+	seen := map[string]bool{}
+	for k := len(this.records) - 1; k >= 0; k-- {
+		if limit > 0 && len(output) >= limit {
+			break
+		}
+		command := this.records[k].FullCommand()
+		if !seen[command] {
+			seen[command] = true
+			output = append(output, command)
+		}
+	}
+	return output
+}
+
+// Stats returns the statistics of every command, or only of the given one, ordered by command
+func (this *RunHistory) Stats(command string) []CommandStats {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	durations := map[string][]int64{}
+	stats := map[string]*CommandStats{}
+	for _, record := range this.records {
+		// imported records do not know how the run went
+		if record.State == "" || (command != "" && record.Command != command) {
+			continue
+		}
+		item, ok := stats[record.Command]
+		if !ok {
+			item = &CommandStats{Command: record.Command}
+			stats[record.Command] = item
+		}
+		item.Runs++
+		if record.State == "succeeded" {
+			item.Succeeded++
+		}
+		if item.LastRunAt == nil || !record.StartedAt.Before(*item.LastRunAt) {
+			startedAt := record.StartedAt
+			item.LastRunAt = &startedAt
+			item.LastState = record.State
+		}
+		durations[record.Command] = append(durations[record.Command], record.Duration)
+	}
+	output := []CommandStats{}
+	for name, item := range stats {
+		list := durations[name]
+		sort.Slice(list, func(i, j int) bool {
+			return list[i] < list[j]
+		})
+		var total int64
+		for _, v := range list {
+			total += v
+		}
+		item.AverageDuration = total / int64(len(list))
+		item.P95Duration = list[int(math.Ceil(float64(len(list))*0.95))-1]
+		item.SuccessRate = float64(item.Succeeded) / float64(item.Runs)
+		output = append(output, *item)
+	}
+	sort.Slice(output, func(i, j int) bool {
+		return output[i].Command < output[j].Command
+	})
+	return output
+}
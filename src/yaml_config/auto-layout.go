@@ -39,7 +39,7 @@ func (this *AutoLayout) ProcessInput(formula string, input string) string {
 	var info map[string]AutoLayoutItem
 	var ok bool
 	if info, ok = this.info[formula]; !ok {
-		panic(fmt.Errorf("no auto layout formula " + formula))
+		panic(fmt.Errorf("no auto layout formula %s", formula))
 	}
 	// save input to file
 	for _,v := range info {
@@ -73,7 +73,7 @@ func (this *AutoLayout) Render(formula string) (string, error) {
 	var info map[string]AutoLayoutItem
 	var ok bool
 	if info, ok = this.info[formula]; !ok {
-		return "", fmt.Errorf("no auto layout formula " + formula)
+		return "", fmt.Errorf("no auto layout formula %s", formula)
 	}
 	for name,v := range info {
 		if v.Type == "input" {
@@ -12,17 +12,17 @@ import (
 	"os/exec"
 	"path/filepath"
 	"strings"
+	"sync"
 )
 
 type IAutomatedCheckWriter func(text string)
 
+// AutomatedCheckCollection is shared by every run, Replace swaps in the checks of a reloaded automated-check.yml
 type AutomatedCheckCollection struct {
+	mutex                sync.RWMutex
 	yml                  map[string]AutomatedCheckItem
 	config               IConfig
 	curl                 ICurl
-	res                  *http.Response
-	body                 string
-	writer               IAutomatedCheckWriter
 }
 
 type AutomatedCheckCurlItem struct {
@@ -48,16 +48,20 @@ type AutomatedCheckItemStep struct {
 	QueryDatabase *string                          `yaml:"query database"`
 	RunIntegrationTestFor *string                  `yaml:"run integration test for"`
 	SeeJsonStringFor *JsonItem                     `yaml:"see json string for"`
+	Retry *RetryPolicy                             `yaml:"retry"`
 }
 
+// AutomatedCheckItem is one entry of automated-check.yml, its steps are rendered by a Template when it runs
 type AutomatedCheckItem struct {
 	StepsToVerify []AutomatedCheckItemStep `yaml:"steps to verify"`
 	Group *string                          `yaml:"group"`
+	Params []ParamDefinition               `yaml:"params"`
 	config               IConfig
 	curl                 ICurl
 	res                  *http.Response
 	body                 string
 	writer               IAutomatedCheckWriter
+	session              *common.Session
 	hybrisAdminCookie    string
 	hybrisAdminCsrfToken string
 }
@@ -68,31 +72,35 @@ func NewAutomatedCheckCollection(config IConfig, curl ICurl, data []byte) (*Auto
 	if err != nil {
 		return nil, err
 	}
-	curl.DisableVerbose()
 	return &AutomatedCheckCollection{
 		yml: yml,
 		config: config,
 		curl:   curl,
-		res:    nil,
-		body:   "",
-		writer: func(text string) {
-			fmt.Printf(text)
-		},
 	}, nil
 }
 
+// Replace takes the checks of other, an automated-check.yml read again
+func (this *AutomatedCheckCollection) Replace(other *AutomatedCheckCollection) {
+	other.mutex.RLock()
+	yml := other.yml
+	other.mutex.RUnlock()
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	this.yml = yml
+}
+
 func (this *AutomatedCheckCollection) GetItem(itemKey string) *AutomatedCheckItem {
+	this.mutex.RLock()
+	defer this.mutex.RUnlock()
 	if v, ok := this.yml[itemKey]; ok {
 		return &v
 	}
 	return nil
 }
 
-func (this *AutomatedCheckCollection) SetWriter(writer IAutomatedCheckWriter) {
-	this.writer = writer
-}
-
 func (this *AutomatedCheckCollection) GetKeys() []string {
+	this.mutex.RLock()
+	defer this.mutex.RUnlock()
 	output := make([]string, 0, len(this.yml))
 	for k := range this.yml {
 		output = append(output, k)
@@ -100,11 +108,15 @@ func (this *AutomatedCheckCollection) GetKeys() []string {
 	return output
 }
 
-func (this *AutomatedCheckCollection) RunGroup(group string) error {
-	for k, v := range this.yml {
+// RunGroup runs every check of group for the run of session, which gets their output in writer
+func (this *AutomatedCheckCollection) RunGroup(group string, writer IAutomatedCheckWriter, session *common.Session) error {
+	this.mutex.RLock()
+	yml := this.yml
+	this.mutex.RUnlock()
+	for k, v := range yml {
 		if v.Group != nil && *v.Group == group {
-			this.writer(fmt.Sprintf(">>> %s\n", k))
-			err := v.Run(this.writer, this)
+			writer(fmt.Sprintf(">>> %s\n", k))
+			err := v.Run(writer, session, this)
 			if err != nil {
 				return err
 			}
@@ -129,11 +141,11 @@ send request headers:
 	if err != nil {
 		return false, err
 	}
-	err = this.curl.RunItem(&item)
+	res, err := this.curl.RunItem(&item, false, ICurlWriter(this.writer), this.session)
 	if err != nil {
 		return false, err
 	}
-	return this.curl.GetResponse().StatusCode == 200, nil
+	return res.StatusCode == 200, nil
 }
 
 func (this *AutomatedCheckItem) getCsrfTokenFromLoginPage() (string, error) {
@@ -150,11 +162,11 @@ use method: get
 	if err != nil {
 		return "", err
 	}
-	err = this.curl.RunItem(&item)
+	res, err := this.curl.RunItem(&item, false, ICurlWriter(this.writer), this.session)
 	if err != nil {
 		return "", err
 	}
-	doc, err := goquery.NewDocumentFromReader(this.curl.GetResponse().Body)
+	doc, err := goquery.NewDocumentFromReader(res.Body)
 	if err != nil {
 		return "", err
 	}
@@ -166,11 +178,11 @@ use method: get
 }
 
 func (this *AutomatedCheckItem) autoLoginToAdmin() error {
-	res, err := this.isLoggedIn()
+	loggedIn, err := this.isLoggedIn()
 	if err != nil {
 		return err
 	}
-	if res == true {
+	if loggedIn == true {
 		return nil
 	}
 	hybrisAdminUrl, err := this.config.GetStringByKey("hybris admin url")
@@ -199,16 +211,17 @@ send raw body: j_username=%s&j_password=%s&_spring_security_remember_me=on&_csrf
 	if err != nil {
 		return err
 	}
-	err = this.curl.RunItem(&item)
+	res, err := this.curl.RunItem(&item, false, ICurlWriter(this.writer), this.session)
 	if err != nil {
 		return err
 	}
 	this.hybrisAdminCsrfToken = csrf
-	location := this.curl.GetResponse().Header.Get("Location")
+	location := res.Header.Get("Location")
 	if location != "/admin/" {
-		return fmt.Errorf("login failed, got: %s", this.curl.GetResponseString())
+		body, _ := ioutil.ReadAll(res.Body)
+		return fmt.Errorf("login failed, got: %s", body)
 	}
-	cookie := this.curl.GetResponse().Header.Get("Set-Cookie")
+	cookie := res.Header.Get("Set-Cookie")
 	if !strings.Contains(cookie, "JSESSIONID") {
 		return fmt.Errorf("missing Set-Cookie header from response")
 	}
@@ -256,11 +269,10 @@ send encoded request body in java style:
 	if err != nil {
 		return err
 	}
-	err = this.curl.RunItem(&item)
+	res, err := this.curl.RunItem(&item, false, ICurlWriter(this.writer), this.session)
 	if err != nil {
 		return err
 	}
-	res := this.curl.GetResponse()
 	if res.StatusCode != 200 {
 		text, err := httputil.DumpResponse(res, true)
 		if err != nil {
@@ -278,172 +290,213 @@ send encoded request body in java style:
 	return nil
 }
 
-func (this *AutomatedCheckCollection) Run(formula string) error {
+// Run runs a check for the run of session, session stops the wait between two attempts of a step and keeps the attempts
+func (this *AutomatedCheckCollection) Run(formula string, writer IAutomatedCheckWriter, session *common.Session) error {
 	item := this.GetItem(formula)
 	if item == nil {
 		return fmt.Errorf("automated check config key %s does not exist", formula)
 	}
-	return item.Run(this.writer, this)
+	return item.Run(writer, session, this)
 }
 
-func (this *AutomatedCheckItem) Run(writer IAutomatedCheckWriter, parent *AutomatedCheckCollection) error {
+func (this *AutomatedCheckItem) Run(writer IAutomatedCheckWriter, session *common.Session, parent *AutomatedCheckCollection) error {
 	//var this AutomatedCheckItem
 	//var ok bool
 	//if this, ok = this.yml[formula]; !ok {
 	//	return fmt.Errorf("key does not exist")
 	//}
-	for _, step := range this.StepsToVerify {
-		if step.RunIntegrationTestFor != nil {
-			writer("- run integration test for: " + *step.RunIntegrationTestFor + "\n")
-			item := parent.GetItem(*step.RunIntegrationTestFor)
-			if item == nil {
-				return fmt.Errorf("automated check config key %s does not exist", *step.RunIntegrationTestFor)
-			}
-			err := item.Run(writer, parent)
-			if err != nil {
-				return err
+	// the items of the collection are copies without the collection they belong to nor the run they are run for
+	this.config, this.curl, this.writer, this.session = parent.config, parent.curl, writer, session
+	steps := this.StepsToVerify
+	if err := NewTemplate(this.config).WithParams(session.Params).RenderValue(&steps); err != nil {
+		return err
+	}
+	this.StepsToVerify = steps
+	var lastRequest *AutomatedCheckItemStep
+	for k := range this.StepsToVerify {
+		step := this.StepsToVerify[k]
+		request := lastRequest
+		attempt := 0
+		err := step.Retry.Run(fmt.Sprintf("step %d", k+1), common.IWriter(writer), session, func() (string, error) {
+			attempt++
+			// checking the same answer again is pointless, a check is retried after its request has been sent again,
+			// which may not be idempotent, e.g. a POST, so only a request that has a retry policy of its own is
+			if attempt > 1 && request != nil && request.Retry != nil && !step.isRequest() {
+				if err := this.runStep(*request, writer, parent); err != nil {
+					return "", err
+				}
 			}
+			return "", this.runStep(step, writer, parent)
+		})
+		if err != nil {
+			return err
 		}
-		if step.SeedingDataWithImpex != nil {
-			writer("- seeding data with impex\n")
-			err := this.seedingDataWithImpex(*step.SeedingDataWithImpex)
-			if err != nil {
-				return err
-			}
+		if step.isRequest() {
+			lastRequest = &this.StepsToVerify[k]
 		}
-		if step.RunAllImpexFromDirectory != nil {
-			writer("- run all impex from directory: " + *step.RunAllImpexFromDirectory + "\n")
-			err := filepath.Walk(*step.RunAllImpexFromDirectory, func(path string, info os.FileInfo, err error) error {
-				if err != nil {
-					return err
-				}
-				if info.IsDir() {
-					return nil
-				}
-				b, err := ioutil.ReadFile(path)
-				if err != nil {
-					return err
-				}
-				writer("- executing impex: " + path + "\n")
-				return this.seedingDataWithImpex(string(b))
-			})
-			if err != nil {
-				return err
-			}
+	}
+	return nil
+}
+
+// CheckTemplates tells which references of the steps cannot be resolved, before the check is ever run
+func (this *AutomatedCheckItem) CheckTemplates(config IConfig) error {
+	steps := this.StepsToVerify
+	return NewTemplate(config).WithDeclaredParams(this.Params).RenderValue(&steps)
+}
+
+func (this *AutomatedCheckItemStep) isRequest() bool {
+	return this.DoHttpRequestFromCurlConfig != nil || this.DoHttpRequestFromCurl != nil
+}
+
+func (this *AutomatedCheckItem) runStep(step AutomatedCheckItemStep, writer IAutomatedCheckWriter, parent *AutomatedCheckCollection) error {
+	if step.RunIntegrationTestFor != nil {
+		writer("- run integration test for: " + *step.RunIntegrationTestFor + "\n")
+		item := parent.GetItem(*step.RunIntegrationTestFor)
+		if item == nil {
+			return fmt.Errorf("automated check config key %s does not exist", *step.RunIntegrationTestFor)
 		}
-		// query database
-		if step.QueryDatabase != nil {
-			//err := this.autoLoginToAdmin()
-			//if err != nil {
-			//	return err
-			//}
-			writer("- query database\n")
-			hybrisAdminUrl, err := parent.config.GetStringByKey("hybris admin url")
-			if err != nil {
-				return err
-			}
-			str := *step.QueryDatabase
-			cmd := exec.Command("hsqldb-sqltool", "--autoCommit", "--inlineRc", "url=jdbc:hsqldb:hsql://127.0.0.1:9003/mydb,user=sa,password=", "--sql", str+";")
-			out, err := cmd.CombinedOutput()
-			if err != nil {
-				return fmt.Errorf("ERROR: %s, OUTPUT: %s\n", err.Error(), out)
-			}
-			err = parent.curl.RunItem(&CurlItem{
-				AccessUrl:                             "POST " + hybrisAdminUrl + "/monitoring/cache/regionCache/clear",
-				UseBasicAuthentication:                "",
-				UseBearerAuthorizationTokenFromConfig: "",
-				SendRawBody:                           "",
-				SendEncodedRequestBodyInJavaStyle:     nil,
-				SendFormData:                          nil,
-				SendAdditionalPathParams:              nil,
-			})
-			if err != nil {
-				return err
-			}
-			res := parent.curl.GetResponse()
-			if res.StatusCode != 200 {
-				dump, err := httputil.DumpResponse(res, true)
-				if err != nil {
-					return err
-				}
-				return fmt.Errorf("cannot clear hybris cache, dumping response: %s", string(dump))
-			}
+		err := item.Run(writer, this.session, parent)
+		if err != nil {
+			return err
 		}
-		if step.DoHttpRequestFromCurlConfig != nil {
-			parent.writer("- do http request from curl config\n")
-			err := parent.curl.RunForKey(*step.DoHttpRequestFromCurlConfig)
-			if err != nil {
-				return err
-			}
-			this.res = this.curl.GetResponse()
-			data, err := ioutil.ReadAll(this.res.Body)
-			if err != nil {
-				return err
-			}
-			this.body = fmt.Sprintf("%s", data)
-			continue
+	}
+	if step.SeedingDataWithImpex != nil {
+		writer("- seeding data with impex\n")
+		err := this.seedingDataWithImpex(*step.SeedingDataWithImpex)
+		if err != nil {
+			return err
 		}
-		if step.DoHttpRequestFromCurl != nil {
-			this.writer("- do http request from curl\n")
-			item, err := this.curl.GetItem(step.DoHttpRequestFromCurl.ConfigKey)
+	}
+	if step.RunAllImpexFromDirectory != nil {
+		writer("- run all impex from directory: " + *step.RunAllImpexFromDirectory + "\n")
+		err := filepath.Walk(*step.RunAllImpexFromDirectory, func(path string, info os.FileInfo, err error) error {
 			if err != nil {
 				return err
 			}
-			item.SendAdditionalPathParams = common.MapStringInterfaceToMapStringString(step.DoHttpRequestFromCurl.AddPathParams)
-			this.curl.DisableVerbose()
-			err = this.curl.RunItem(item)
-			if err != nil {
-				return err
+			if info.IsDir() {
+				return nil
 			}
-			this.res = this.curl.GetResponse()
-			data, err := ioutil.ReadAll(this.res.Body)
+			b, err := ioutil.ReadFile(path)
 			if err != nil {
 				return err
 			}
-			this.body = fmt.Sprintf("%s", data)
-			continue
+			writer("- executing impex: " + path + "\n")
+			return this.seedingDataWithImpex(string(b))
+		})
+		if err != nil {
+			return err
 		}
-		if step.SeeHttpResponseCode != nil {
-			this.writer("- see http response code\n")
-			if this.res.StatusCode != *step.SeeHttpResponseCode {
-				return fmt.Errorf("expected status code: %d, got: %d, response body: %s", *step.SeeHttpResponseCode, this.res.StatusCode, this.body)
-			}
+	}
+	// query database
+	if step.QueryDatabase != nil {
+		//err := this.autoLoginToAdmin()
+		//if err != nil {
+		//	return err
+		//}
+		writer("- query database\n")
+		hybrisAdminUrl, err := parent.config.GetStringByKey("hybris admin url")
+		if err != nil {
+			return err
 		}
-		if step.SeeSubstring != nil {
-			this.writer("- see substring\n")
-			if strings.Contains(this.body, *step.SeeSubstring) == false {
-				return fmt.Errorf("expected substring: %s, got response body: %s", *step.SeeSubstring, this.body)
-			}
+		str := *step.QueryDatabase
+		cmd := exec.Command("hsqldb-sqltool", "--autoCommit", "--inlineRc", "url=jdbc:hsqldb:hsql://127.0.0.1:9003/mydb,user=sa,password=", "--sql", str+";")
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("ERROR: %s, OUTPUT: %s\n", err.Error(), out)
+		}
+		res, err := parent.curl.RunItem(&CurlItem{
+			AccessUrl:                             "POST " + hybrisAdminUrl + "/monitoring/cache/regionCache/clear",
+			UseBasicAuthentication:                "",
+			UseBearerAuthorizationTokenFromConfig: "",
+			SendRawBody:                           "",
+			SendEncodedRequestBodyInJavaStyle:     nil,
+			SendFormData:                          nil,
+			SendAdditionalPathParams:              nil,
+		}, false, ICurlWriter(writer), this.session)
+		if err != nil {
+			return err
 		}
-		if step.SeeJsonString != nil {
-			this.writer("- see json string\n")
-			format, err := common.DiffJsonObjectString(*step.SeeJsonString, this.body)
+		if res.StatusCode != 200 {
+			dump, err := httputil.DumpResponse(res, true)
 			if err != nil {
 				return err
 			}
-			if format != "" {
-				return fmt.Errorf("expected json string: %s, got: %s", *step.SeeJsonString, format)
-			}
+			return fmt.Errorf("cannot clear hybris cache, dumping response: %s", string(dump))
 		}
-		if step.NotSeeSubstring != nil {
-			this.writer("- not see substring\n")
-			if strings.Contains(this.body, *step.NotSeeSubstring) != false {
-				return fmt.Errorf("expected not containing substring: %s, got response body: %s", *step.NotSeeSubstring, this.body)
-			}
+	}
+	if step.DoHttpRequestFromCurlConfig != nil {
+		writer("- do http request from curl config\n")
+		res, err := parent.curl.RunForKey(*step.DoHttpRequestFromCurlConfig, false, ICurlWriter(writer), this.session)
+		if err != nil {
+			return err
 		}
-		if step.SeeJsonStringFor != nil {
-			this.writer("- see json string for\n")
-			body, err := common.GetJsonValueFromKeyChain([]byte(this.body), step.SeeJsonStringFor.JsonKey)
-			if err != nil {
-				return err
-			}
-			format, err := common.DiffJsonObjectString(step.SeeJsonStringFor.ExpectedJsonString, string(body))
-			if err != nil {
-				return err
-			}
-			if len(format) > 0 {
-				return fmt.Errorf("expected json string: %s, got: %s", *step.SeeJsonString, format)
-			}
+		this.res = res
+		data, err := ioutil.ReadAll(this.res.Body)
+		if err != nil {
+			return err
+		}
+		this.body = fmt.Sprintf("%s", data)
+		return nil
+	}
+	if step.DoHttpRequestFromCurl != nil {
+		this.writer("- do http request from curl\n")
+		item, err := this.curl.GetItem(step.DoHttpRequestFromCurl.ConfigKey)
+		if err != nil {
+			return err
+		}
+		item.SendAdditionalPathParams = common.MapStringInterfaceToMapStringString(step.DoHttpRequestFromCurl.AddPathParams)
+		res, err := this.curl.RunItem(item, false, ICurlWriter(this.writer), this.session)
+		if err != nil {
+			return err
+		}
+		this.res = res
+		data, err := ioutil.ReadAll(this.res.Body)
+		if err != nil {
+			return err
+		}
+		this.body = fmt.Sprintf("%s", data)
+		return nil
+	}
+	if step.SeeHttpResponseCode != nil {
+		this.writer("- see http response code\n")
+		if this.res.StatusCode != *step.SeeHttpResponseCode {
+			return fmt.Errorf("expected status code: %d, got: %d, response body: %s", *step.SeeHttpResponseCode, this.res.StatusCode, this.body)
+		}
+	}
+	if step.SeeSubstring != nil {
+		this.writer("- see substring\n")
+		if strings.Contains(this.body, *step.SeeSubstring) == false {
+			return fmt.Errorf("expected substring: %s, got response body: %s", *step.SeeSubstring, this.body)
+		}
+	}
+	if step.SeeJsonString != nil {
+		this.writer("- see json string\n")
+		format, err := common.DiffJsonObjectString(*step.SeeJsonString, this.body)
+		if err != nil {
+			return err
+		}
+		if format != "" {
+			return fmt.Errorf("expected json string: %s, got: %s", *step.SeeJsonString, format)
+		}
+	}
+	if step.NotSeeSubstring != nil {
+		this.writer("- not see substring\n")
+		if strings.Contains(this.body, *step.NotSeeSubstring) != false {
+			return fmt.Errorf("expected not containing substring: %s, got response body: %s", *step.NotSeeSubstring, this.body)
+		}
+	}
+	if step.SeeJsonStringFor != nil {
+		this.writer("- see json string for\n")
+		body, err := common.GetJsonValueFromKeyChain([]byte(this.body), step.SeeJsonStringFor.JsonKey)
+		if err != nil {
+			return err
+		}
+		format, err := common.DiffJsonObjectString(step.SeeJsonStringFor.ExpectedJsonString, string(body))
+		if err != nil {
+			return err
+		}
+		if len(format) > 0 {
+			return fmt.Errorf("expected json string: %s, got: %s", *step.SeeJsonString, format)
 		}
 	}
 	return nil
@@ -0,0 +1,68 @@
+package yaml_config
+
+import (
+	"common"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// fakeCurl answers pending to the first requests for a key and ready from then on
+type fakeCurl struct {
+	ICurl
+	readyAt int
+	sent    map[string]int
+}
+
+func (this *fakeCurl) RunForKey(formula string, verbose bool, writer ICurlWriter, session *common.Session) (*http.Response, error) {
+	this.sent[formula]++
+	body := "pending"
+	if this.sent[formula] >= this.readyAt {
+		body = "ready"
+	}
+	return &http.Response{StatusCode: 200, Body: ioutil.NopCloser(strings.NewReader(body))}, nil
+}
+
+func TestAutomatedCheckRetrySendsTheRequestAgainOnlyWithItsOwnPolicy(t *testing.T) {
+	tests := []struct {
+		name     string
+		request  string
+		wantSent int
+		wantErr  bool
+	}{
+		{
+			name:     "a request without retry is sent once",
+			request:  "- do http request from curl config: create\n",
+			wantSent: 1,
+			wantErr:  true,
+		},
+		{
+			name:     "a request with retry is sent again for the check",
+			request:  "- do http request from curl config: create\n  retry: 3\n",
+			wantSent: 2,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			curl := &fakeCurl{readyAt: 2, sent: map[string]int{}}
+			yml := "check:\n  steps to verify:\n" + indentLines(test.request, "  ") +
+				"  - see substring: ready\n    retry:\n      attempts: 3\n      delay: 1ms\n"
+			collection, err := NewAutomatedCheckCollection(nil, curl, []byte(yml))
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = collection.Run("check", func(text string) {}, common.NewSession(nil))
+			if (err != nil) != test.wantErr {
+				t.Errorf("got %v, want an error: %t", err, test.wantErr)
+			}
+			if curl.sent["create"] != test.wantSent {
+				t.Errorf("the request has been sent %d times, want %d", curl.sent["create"], test.wantSent)
+			}
+		})
+	}
+}
+
+func indentLines(text string, prefix string) string {
+	return prefix + strings.ReplaceAll(strings.TrimSuffix(text, "\n"), "\n", "\n"+prefix) + "\n"
+}
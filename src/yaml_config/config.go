@@ -2,22 +2,98 @@ package yaml_config
 
 import (
 	"fmt"
+	"gopkg.in/yaml.v2"
+	"sort"
 	"strconv"
+	"sync"
 )
 
 type IConfig interface {
 	GetStringByKey(string) (string, error)
+	GetEnv() map[string]string
 }
 
+// Config is shared by every run, Replace swaps in the values of a reloaded config while runs read it
 type Config struct {
-	yml map[string]string
+	mutex sync.RWMutex
+	yml   map[string]string
+	env   map[string]string
 }
 
 func NewConfig(m map[string]string) (*Config, error) {
-	return &Config{m}, nil
+	return &Config{yml: m}, nil
+}
+
+// configValue is a value of config.yml, a block such as "env" is read on its own and kept as nil here
+type configValue struct {
+	value *string
+}
+
+func (this *configValue) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var value string
+	err := unmarshal(&value)
+	if err == nil {
+		this.value = &value
+		return nil
+	}
+	var block map[string]interface{}
+	if unmarshal(&block) == nil {
+		return nil
+	}
+	return err
+}
+
+// ParseConfig reads a config file, env is its "env" block of environment variables given to every command
+func ParseConfig(data []byte) (values map[string]string, env map[string]string, err error) {
+	var blocks struct {
+		Env map[string]string `yaml:"env"`
+	}
+	err = yaml.Unmarshal(data, &blocks)
+	if err != nil {
+		return nil, nil, err
+	}
+	raw := map[string]configValue{}
+	err = yaml.Unmarshal(data, raw)
+	if err != nil {
+		return nil, nil, err
+	}
+	values = map[string]string{}
+	for key, value := range raw {
+		if value.value != nil {
+			values[key] = *value.value
+		} else if key != "env" {
+			return nil, nil, fmt.Errorf("config key %s should have a value, not a block", key)
+		}
+	}
+	return values, blocks.Env, nil
+}
+
+// SetEnv sets the environment variables every command gets, they may refer to templates like {{secret.name}}
+func (config *Config) SetEnv(env map[string]string) {
+	config.mutex.Lock()
+	defer config.mutex.Unlock()
+	config.env = env
+}
+
+// Replace takes the values of other, a config read again from the files
+func (config *Config) Replace(other *Config) {
+	other.mutex.RLock()
+	yml, env := other.yml, other.env
+	other.mutex.RUnlock()
+	config.mutex.Lock()
+	defer config.mutex.Unlock()
+	config.yml, config.env = yml, env
+}
+
+func (config *Config) GetEnv() map[string]string {
+	config.mutex.RLock()
+	defer config.mutex.RUnlock()
+	return config.env
 }
 
 func (config *Config) GetStringByKey(key string) (string, error) {
+	config.mutex.RLock()
+	defer config.mutex.RUnlock()
 	if val, ok := config.yml[key]; ok {
 		return val, nil
 	}
@@ -25,6 +101,8 @@ func (config *Config) GetStringByKey(key string) (string, error) {
 }
 
 func (config *Config) GetIntByKey(key string) (int, error) {
+	config.mutex.RLock()
+	defer config.mutex.RUnlock()
 	if val, ok := config.yml[key]; ok {
 		res, err := strconv.Atoi(val)
 		if err != nil {
@@ -34,3 +112,13 @@ func (config *Config) GetIntByKey(key string) (int, error) {
 	}
 	return 0, fmt.Errorf("key does not exist")
 }
+
+// EnvList turns a map of environment variables into NAME=value entries sorted by name
+func EnvList(env map[string]string) []string {
+	var list []string
+	for name, value := range env {
+		list = append(list, name+"="+value)
+	}
+	sort.Strings(list)
+	return list
+}
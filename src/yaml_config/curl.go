@@ -8,7 +8,6 @@ import (
 	"fmt"
 	"gopkg.in/yaml.v2"
 	"io"
-	"io/ioutil"
 	"log"
 	"mime/multipart"
 	"net/http"
@@ -17,22 +16,23 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
-	"time"
+	"sync"
 )
 
+// ICurl sends the requests of curl.yml, the writer and session of the run a request is sent for are given on every call
+// as runs share the collection
 type ICurl interface {
-	RunForKey(string) error
-	GetResponse() *http.Response
-	GetResponseString() string
+	RunForKey(formula string, verbose bool, writer ICurlWriter, session *common.Session) (*http.Response, error)
+	RunForKeyWithParams(formula string, params map[string]string, verbose bool, writer ICurlWriter, session *common.Session) (*http.Response, error)
 	GetItem(string) (*CurlItem, error)
-	RunItem(item *CurlItem) error
-	DisableVerbose()
-	EnableVerbose()
-	SetWriter(fn func(text string))
+	GetItemWithParams(string, map[string]string) (*CurlItem, error)
+	RunItem(item *CurlItem, verbose bool, writer ICurlWriter, session *common.Session) (*http.Response, error)
 }
 
 type ICurlWriter func(text string)
 
+// CurlItem is one entry of curl.yml, with a "retry" policy a request that fails to get an answer,
+// or gets one of the "on status codes" of the policy, is sent again
 type CurlItem struct {
 	AccessUrl                             string            `yaml:"access url"`
 	UseBasicAuthentication                string                 `yaml:"use basic authentication"`
@@ -44,16 +44,19 @@ type CurlItem struct {
 	SendFormData                          map[string]interface{} `yaml:"send form data"`
 	SendAdditionalPathParams              map[string]string      `yaml:"send additional params"`
 	PatchBodyWithTheFollowingValues map[string]string `yaml:"patch body with the following values"`
+	Params []ParamDefinition `yaml:"params"`
+	Retry *RetryPolicy `yaml:"retry"`
 	FinalRequestBody string
+	// name is the key of the item in curl.yml
+	name string
 }
 
+// CurlCollection is shared by every run, Replace swaps in the items of a reloaded curl.yml while runs read them
 type CurlCollection struct {
+	mutex             sync.RWMutex
 	yml               map[string]CurlItem
 	config            IConfig
-	response          *http.Response
 	additionalHeaders map[string]string
-	verbose           bool
-	writer            ICurlWriter
 }
 
 func NewCurlCollection(configService IConfig, data []byte) (*CurlCollection, error) {
@@ -65,20 +68,23 @@ func NewCurlCollection(configService IConfig, data []byte) (*CurlCollection, err
 	return &CurlCollection{
 		yml:               yml,
 		config:            configService,
-		response:          nil,
 		additionalHeaders: nil,
-		verbose:           true,
-		writer: func(text string) {
-			fmt.Printf(text)
-		},
 	}, nil
 }
 
-func (this *CurlCollection) SetWriter(fn func(text string)) {
-	this.writer = fn
+// Replace takes the items of other, a curl.yml read again
+func (this *CurlCollection) Replace(other *CurlCollection) {
+	other.mutex.RLock()
+	yml := other.yml
+	other.mutex.RUnlock()
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	this.yml = yml
 }
 
 func (this *CurlCollection) GetKeys() []string {
+	this.mutex.RLock()
+	defer this.mutex.RUnlock()
 	output := make([]string, 0, len(this.yml))
 	for k := range this.yml {
 		output = append(output, k)
@@ -86,14 +92,6 @@ func (this *CurlCollection) GetKeys() []string {
 	return output
 }
 
-func (this *CurlCollection) DisableVerbose() {
-	this.verbose = false
-}
-
-func (this *CurlCollection) EnableVerbose() {
-	this.verbose = true
-}
-
 func (this *CurlItem) Run(config IConfig, verbose bool, writer ICurlWriter) (*http.Response, error) {
 	http.DefaultTransport.(*http.Transport).TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
 	var req *http.Request
@@ -153,11 +151,11 @@ func (this *CurlItem) Run(config IConfig, verbose bool, writer ICurlWriter) (*ht
 			rules := strings.Split(rule, ",")
 			replacing := ""
 			for _, v := range rules {
+				// [timestamp] is what {{now.timestamp}} was written before templates
 				if v == "[timestamp]" {
-					replacing += fmt.Sprintf("%d", time.Now().Unix())
-				} else {
-					replacing += v
+					v, _ = NewTemplate(config).Render("{{now.timestamp}}")
 				}
+				replacing += v
 			}
 			body = bytes.NewBufferString(strings.Replace(body.String(), replaced, replacing, -1))
 		}
@@ -212,6 +210,40 @@ func (this *CurlItem) Run(config IConfig, verbose bool, writer ICurlWriter) (*ht
 	return this.exec(verbose, writer, req)
 }
 
+// RunWithRetry runs the item with its retry policy, the answer is the one to the last attempt
+func (this *CurlItem) RunWithRetry(config IConfig, verbose bool, writer ICurlWriter, session *common.Session) (*http.Response, error) {
+	if this.Retry == nil {
+		return this.Run(config, verbose, writer)
+	}
+	what := "http request"
+	if this.name != "" {
+		what = "curl " + this.name
+	}
+	var res *http.Response
+	err := this.Retry.Run(what, common.IWriter(writer), session, func() (string, error) {
+		if res != nil {
+			_ = res.Body.Close()
+		}
+		// Run takes the lists out of the java style body, every attempt sends the same request
+		attempt := *this
+		attempt.SendEncodedRequestBodyInJavaStyle = nil
+		if this.SendEncodedRequestBodyInJavaStyle != nil {
+			attempt.SendEncodedRequestBodyInJavaStyle = map[string]interface{}{}
+			for k, v := range this.SendEncodedRequestBodyInJavaStyle {
+				attempt.SendEncodedRequestBodyInJavaStyle[k] = v
+			}
+		}
+		var err error
+		res, err = attempt.Run(config, verbose, writer)
+		this.FinalRequestBody = attempt.FinalRequestBody
+		if err == nil && this.Retry.failsOnStatus(res.StatusCode) {
+			return "", fmt.Errorf("answered %s", res.Status)
+		}
+		return "", err
+	})
+	return res, err
+}
+
 func (this *CurlItem) exec(verbose bool, writer ICurlWriter, req *http.Request) (*http.Response, error) {
 	client := &http.Client{
 		CheckRedirect: func(req *http.Request, via []*http.Request) error {
@@ -239,39 +271,65 @@ func (this *CurlItem) exec(verbose bool, writer ICurlWriter, req *http.Request)
 	return res, nil
 }
 
+// GetItem returns a copy of a curl item with its templates rendered, its params take their default values
 func (this *CurlCollection) GetItem(formula string) (*CurlItem, error) {
+	return this.getItem(formula, nil)
+}
+
+// GetItemWithParams is GetItem with the values of some params given, e.g. by the "http request" step of a formula
+func (this *CurlCollection) GetItemWithParams(formula string, params map[string]string) (*CurlItem, error) {
+	return this.getItem(formula, params)
+}
+
+func (this *CurlCollection) getItem(formula string, params map[string]string) (*CurlItem, error) {
 	var info CurlItem
 	var ok bool
-	if info, ok = this.yml[formula]; !ok {
+	this.mutex.RLock()
+	info, ok = this.yml[formula]
+	this.mutex.RUnlock()
+	if !ok {
 		return nil, fmt.Errorf("curl formula %s does not exist", formula)
 	}
+	values := map[string]string{}
+	for _, definition := range info.Params {
+		value, ok := params[definition.Name]
+		if !ok && definition.Required {
+			return nil, fmt.Errorf("%w: param %s of curl formula %s is required", ErrInvalidParams, definition.Name, formula)
+		}
+		if !ok {
+			value = definition.Default
+		}
+		values[definition.Name] = value
+	}
+	err := NewTemplate(this.config).WithParams(values).RenderValue(&info)
+	if err != nil {
+		return nil, fmt.Errorf("curl formula %s: %w", formula, err)
+	}
+	info.name = formula
 	return &info, nil
 }
 
-func (this *CurlCollection) RunItem(item *CurlItem) error {
-	res, err := item.Run(this.config, this.verbose, this.writer)
-	this.response = res
-	return err
+// RunItem sends the request of item for the run of session, the request and the answer are written when verbose is set
+func (this *CurlCollection) RunItem(item *CurlItem, verbose bool, writer ICurlWriter, session *common.Session) (*http.Response, error) {
+	return item.RunWithRetry(this.config, verbose, writer, session)
+}
+
+func (this *CurlCollection) RunForKey(formula string, verbose bool, writer ICurlWriter, session *common.Session) (*http.Response, error) {
+	return this.RunForKeyWithParams(formula, nil, verbose, writer, session)
 }
 
-func (this *CurlCollection) RunForKey(formula string) error {
-	item, err := this.GetItem(formula)
+// RunForKeyWithParams runs a curl item after rendering its templates, e.g. {{param.name}} in its url, headers or body
+func (this *CurlCollection) RunForKeyWithParams(formula string, params map[string]string, verbose bool, writer ICurlWriter, session *common.Session) (*http.Response, error) {
+	item, err := this.getItem(formula, params)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	res, err := item.Run(this.config, this.verbose, this.writer)
-	this.response = res
-	return err
+	return item.RunWithRetry(this.config, verbose, writer, session)
 }
 
-func (this *CurlCollection) GetResponse() *http.Response {
-	return this.response
+// CheckTemplates tells which references of the item cannot be resolved, before it is ever sent
+func (this *CurlItem) CheckTemplates(config IConfig) error {
+	item := *this
+	return NewTemplate(config).WithDeclaredParams(this.Params).RenderValue(&item)
 }
 
-func (this *CurlCollection) GetResponseString() string {
-	b, err := ioutil.ReadAll(this.response.Body)
-	if err != nil {
-		return ""
-	}
-	return string(b)
-}
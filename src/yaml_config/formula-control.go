@@ -0,0 +1,307 @@
+package yaml_config
+
+import (
+	"common"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const defaultWaitTimeout = time.Minute
+
+const waitInterval = 500 * time.Millisecond
+
+// FormulaCondition is the "if" of a step. With "command" it holds when the command exits with "exit code", 0 by default,
+// or when its output matches the regular expression "output matches". With "config" it holds when the config key
+// has the value "equals", or any value but "" and "false" when "equals" is not given. "not" negates it
+type FormulaCondition struct {
+	Command       string  `yaml:"command"`
+	ExitCode      string  `yaml:"exit code"`
+	OutputMatches string  `yaml:"output matches"`
+	Config        string  `yaml:"config"`
+	Equals        *string `yaml:"equals"`
+	Not           bool    `yaml:"not"`
+}
+
+func (this *FormulaCondition) String() string {
+	text := ""
+	switch {
+	case this.Command != "" && this.OutputMatches != "":
+		text = fmt.Sprintf("output of %s matches %s", this.Command, this.OutputMatches)
+	case this.Command != "":
+		exitCode := this.ExitCode
+		if exitCode == "" {
+			exitCode = "0"
+		}
+		text = fmt.Sprintf("%s exits with %s", this.Command, exitCode)
+	case this.Equals != nil:
+		text = fmt.Sprintf("config %s equals %s", this.Config, *this.Equals)
+	default:
+		text = fmt.Sprintf("config %s is set", this.Config)
+	}
+	if this.Not {
+		return "not " + text
+	}
+	return text
+}
+
+func (this *FormulaCondition) evaluate(config IConfig, session *common.Session) (bool, error) {
+	result := false
+	switch {
+	case this.Command != "":
+		output := ""
+		err := common.RunLinuxCommand(this.Command, func(text string) {
+			output += text
+		}, session.ForCapture())
+		exitCode := 0
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			exitCode = exitErr.ExitCode()
+		} else if err != nil {
+			return false, err
+		}
+		if this.OutputMatches != "" {
+			pattern, err := regexp.Compile(this.OutputMatches)
+			if err != nil {
+				return false, err
+			}
+			result = pattern.MatchString(output)
+		} else {
+			expected := 0
+			if this.ExitCode != "" {
+				expected, err = strconv.Atoi(this.ExitCode)
+				if err != nil {
+					return false, fmt.Errorf("exit code must be an integer, got %s", strconv.Quote(this.ExitCode))
+				}
+			}
+			result = exitCode == expected
+		}
+	case this.Config != "":
+		value, err := config.GetStringByKey(this.Config)
+		if this.Equals != nil {
+			result = err == nil && value == *this.Equals
+		} else {
+			result = err == nil && value != "" && value != "false"
+		}
+	default:
+		return false, fmt.Errorf("a condition needs a command or a config key")
+	}
+	return result != this.Not, nil
+}
+
+// FormulaLoop is the "for each" of a step, its "do" steps run once for every value of "items"
+// or every non empty line of "lines of file", they see the value as {{var.item}} or the name given by "as"
+type FormulaLoop struct {
+	Items       []string `yaml:"items"`
+	LinesOfFile string   `yaml:"lines of file"`
+	As          string   `yaml:"as"`
+}
+
+func (this *FormulaStep) runLoop(config IConfig, curl ICurl, w common.IWriter, session *common.Session) error {
+	items := this.ForEach.Items
+	if this.ForEach.LinesOfFile != "" {
+		data, err := ioutil.ReadFile(this.path(this.ForEach.LinesOfFile))
+		if err != nil {
+			return fmt.Errorf("for each: %w", err)
+		}
+		for _, line := range strings.Split(string(data), "\n") {
+			if line = strings.TrimSpace(line); line != "" {
+				items = append(items, line)
+			}
+		}
+	}
+	name := this.ForEach.As
+	if name == "" {
+		name = "item"
+	}
+	for _, item := range items {
+		w(fmt.Sprintf("for each %s = %s\n", name, item))
+		err := runSteps(this.Do, config, curl, w, session.WithVar(name, item))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// wait polls until the port accepts connections or the url answers 200, for the "timeout" of the step or a minute
+func (this *FormulaStep) wait(w common.IWriter, session *common.Session) error {
+	timeout, _ := common.ParseDuration(this.Timeout)
+	if timeout == 0 {
+		timeout = defaultWaitTimeout
+	}
+	what, check := "", func() error { return nil }
+	if this.WaitForPort != "" {
+		address := this.WaitForPort
+		if !strings.Contains(address, ":") {
+			address = "localhost:" + address
+		}
+		what = "port " + address
+		check = func() error {
+			conn, err := net.DialTimeout("tcp", address, 2*time.Second)
+			if err != nil {
+				return err
+			}
+			return conn.Close()
+		}
+	} else {
+		url := this.WaitForHttp200
+		what = url
+		client := &http.Client{Timeout: 5 * time.Second}
+		check = func() error {
+			res, err := client.Get(url)
+			if err != nil {
+				return err
+			}
+			res.Body.Close()
+			if res.StatusCode != http.StatusOK {
+				return fmt.Errorf("status %s", res.Status)
+			}
+			return nil
+		}
+	}
+	var stop chan bool
+	if session != nil {
+		stop = session.ForceStop
+	}
+	w("waiting for " + what + "\n")
+	deadline := time.Now().Add(timeout)
+	for {
+		err := check()
+		if err == nil {
+			w(what + " is ready\n")
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("%s is not ready after %s: %s", what, timeout, err)
+		}
+		select {
+		case <-stop:
+			return common.ErrForceStopped
+		case <-time.After(waitInterval):
+		}
+	}
+}
+
+// FormulaWriteFile is the "write file" of a step, "mode" is octal and 0644 by default
+type FormulaWriteFile struct {
+	Path    string `yaml:"path"`
+	Content string `yaml:"content"`
+	Append  bool   `yaml:"append"`
+	Mode    string `yaml:"mode"`
+}
+
+func (this *FormulaWriteFile) write(path string) error {
+	if this.Path == "" {
+		return fmt.Errorf("write file needs a path")
+	}
+	mode := uint64(0644)
+	if this.Mode != "" {
+		var err error
+		mode, err = strconv.ParseUint(this.Mode, 8, 32)
+		if err != nil {
+			return fmt.Errorf("mode of write file must be octal, got %s", strconv.Quote(this.Mode))
+		}
+	}
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return err
+	}
+	flag := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if this.Append {
+		flag = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	}
+	file, err := os.OpenFile(path, flag, os.FileMode(mode))
+	if err != nil {
+		return err
+	}
+	_, err = file.WriteString(this.Content)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// FormulaHttpRequest is the "http request" of a step, the name of a curl.yml item or a map with "curl" and its "params".
+// An answer with a status of 400 or more fails the step
+type FormulaHttpRequest struct {
+	Curl   string            `yaml:"curl"`
+	Params map[string]string `yaml:"params"`
+}
+
+func (this *FormulaHttpRequest) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	if err := unmarshal(&this.Curl); err == nil {
+		return nil
+	}
+	type plain FormulaHttpRequest
+	return unmarshal((*plain)(this))
+}
+
+// send runs the curl item with its retry policy and returns the body of the answer
+func (this *FormulaHttpRequest) send(config IConfig, curl ICurl, w common.IWriter, session *common.Session) (string, error) {
+	if curl == nil {
+		return "", fmt.Errorf("http request: curl.yml is not loaded")
+	}
+	item, err := curl.GetItemWithParams(this.Curl, this.Params)
+	if err != nil {
+		return "", err
+	}
+	res, err := item.RunWithRetry(config, true, ICurlWriter(w), session)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return "", err
+	}
+	if res.StatusCode >= 400 {
+		return "", fmt.Errorf("curl %s answered %s", this.Curl, res.Status)
+	}
+	return string(body), nil
+}
+
+// extract returns what "save output as" keeps of the output of the step: the first group matched by "extract regex",
+// or the whole match when it has no group, the value at the key chain "extract json", e.g. data.items.0.id,
+// or else the whole output without its trailing line breaks
+func (this *FormulaStep) extract(output string) (string, error) {
+	switch {
+	case this.ExtractRegex != "":
+		pattern, err := regexp.Compile(this.ExtractRegex)
+		if err != nil {
+			return "", err
+		}
+		match := pattern.FindStringSubmatch(output)
+		if match == nil {
+			return "", fmt.Errorf("output does not match %s", this.ExtractRegex)
+		}
+		if len(match) > 1 {
+			return match[1], nil
+		}
+		return match[0], nil
+	case this.ExtractJson != "":
+		value, err := common.GetJsonValueFromKeyChain([]byte(output), this.ExtractJson)
+		if err != nil {
+			return "", err
+		}
+		if string(value) == "null" {
+			return "", fmt.Errorf("output has no %s", this.ExtractJson)
+		}
+		var text string
+		if json.Unmarshal(value, &text) == nil {
+			return text, nil
+		}
+		return string(value), nil
+	}
+	return strings.TrimRight(output, "\r\n"), nil
+}
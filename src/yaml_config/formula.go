@@ -0,0 +1,377 @@
+package yaml_config
+
+import (
+	"common"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+type FormulaBashScript struct {
+	Content                string `yaml:"content"`
+	WorkingDirectoryConfig string `yaml:"working directory config"`
+}
+
+// FormulaStep is one step of a formula, its commands get the variables of "env file" then those of "env"
+// on top of the environment of the server and run in "working directory" when it is given, relative paths
+// of the step are relative to it as well. A failing step stops the formula unless it says "continue on error".
+// "save output as" keeps what the step printed on stdout, or the body answered to its http request,
+// as a variable of the run that the next steps refer to as {{var.name}}, see FormulaStep.extract.
+// A step with a "retry" policy is run again when it fails, the "timeout" is that of every attempt
+type FormulaStep struct {
+	OpenUrl              string              `yaml:"open url"`
+	Output               string              `yaml:"output"`
+	RunLinuxCommand      string              `yaml:"run linux command"`
+	RunLinuxCommandByCsv string              `yaml:"run linux command by csv"`
+	RunBashScript        *FormulaBashScript  `yaml:"run bash script"`
+	If                   *FormulaCondition   `yaml:"if"`
+	Then                 []FormulaStep       `yaml:"then"`
+	Else                 []FormulaStep       `yaml:"else"`
+	ForEach              *FormulaLoop        `yaml:"for each"`
+	Do                   []FormulaStep       `yaml:"do"`
+	WaitForPort          string              `yaml:"wait for port"`
+	WaitForHttp200       string              `yaml:"wait for http 200"`
+	WriteFile            *FormulaWriteFile   `yaml:"write file"`
+	HttpRequest          *FormulaHttpRequest `yaml:"http request"`
+	ContinueOnError      bool                `yaml:"continue on error"`
+	Retry                *RetryPolicy        `yaml:"retry"`
+	SaveOutputAs         string              `yaml:"save output as"`
+	ExtractRegex         string              `yaml:"extract regex"`
+	ExtractJson          string              `yaml:"extract json"`
+	Timeout              string              `yaml:"timeout"`
+	Env                  map[string]string   `yaml:"env"`
+	EnvFile              string              `yaml:"env file"`
+	WorkingDirectory     string              `yaml:"working directory"`
+}
+
+// FormulaItem is one entry of formula.yml, written either as a plain list of steps
+// or as a map with "steps" and options such as "timeout", "pty" runs the commands in a pseudo terminal.
+// Steps are rendered with a Template when they run, they refer to the declared "params" as {{param.name}},
+// which commands run through a shell get single quoted, e.g. grep {{param.text}} file rather than grep "{{param.text}}" file.
+// The "finally" steps run after the steps whether they failed or not, "tags" are matched by the rules of notification.yml
+type FormulaItem struct {
+	Timeout   string            `yaml:"timeout"`
+	Singleton string            `yaml:"singleton"`
+	Locks     []string          `yaml:"locks"`
+	Pty       bool              `yaml:"pty"`
+	Tags      []string          `yaml:"tags"`
+	Params    []ParamDefinition `yaml:"params"`
+	Steps     []FormulaStep     `yaml:"steps"`
+	Finally   []FormulaStep     `yaml:"finally"`
+}
+
+func (this *FormulaItem) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var steps []FormulaStep
+	if err := unmarshal(&steps); err == nil {
+		this.Steps = steps
+		return nil
+	}
+	type plain FormulaItem
+	return unmarshal((*plain)(this))
+}
+
+func (this *FormulaItem) GetTimeout() (time.Duration, error) {
+	return common.ParseDuration(this.Timeout)
+}
+
+// CheckTemplates tells which references of the steps cannot be resolved, before the item is ever run
+func (this *FormulaItem) CheckTemplates(config IConfig) error {
+	steps := append(append([]FormulaStep{}, this.Steps...), this.Finally...)
+	return NewTemplate(config).WithParams(emptyParams(this.Params)).AnyVars().RenderValue(&steps)
+}
+
+// CheckRetries tells what is wrong with the retry policies of the steps, nested steps included
+func (this *FormulaItem) CheckRetries() error {
+	return checkRetries(append(append([]FormulaStep{}, this.Steps...), this.Finally...))
+}
+
+func checkRetries(steps []FormulaStep) error {
+	for k := range steps {
+		if err := steps[k].Retry.Check(); err != nil {
+			return fmt.Errorf("retry: %w", err)
+		}
+		for _, nested := range [][]FormulaStep{steps[k].Then, steps[k].Else, steps[k].Do} {
+			if err := checkRetries(nested); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func emptyParams(definitions []ParamDefinition) map[string]string {
+	params := map[string]string{}
+	for _, definition := range definitions {
+		params[definition.Name] = ""
+	}
+	return params
+}
+
+// Run runs the steps then the finally steps, curl is where "http request" steps find their curl.yml items
+func (this *FormulaItem) Run(config IConfig, curl ICurl, w common.IWriter, session *common.Session) error {
+	err := runSteps(this.Steps, config, curl, w, session)
+	if len(this.Finally) == 0 {
+		return err
+	}
+	if session.Stopped() {
+		// the commands of a stopped run would be stopped at once, the finally steps get a grace period instead
+		stop, _, release := common.WithTimeout(nil, common.StopGracePeriod)
+		defer release()
+		session = session.WithForceStop(stop)
+	}
+	finallyErr := runSteps(this.Finally, config, curl, w, session)
+	if err == nil {
+		return finallyErr
+	}
+	if finallyErr != nil {
+		w(fmt.Sprintf("finally failed: %s\n", finallyErr))
+	}
+	return err
+}
+
+// runSteps runs steps in order until one fails, a step saying "continue on error" only reports its error
+func runSteps(steps []FormulaStep, config IConfig, curl ICurl, w common.IWriter, session *common.Session) error {
+	for k := range steps {
+		if session.Stopped() {
+			return common.ErrForceStopped
+		}
+		err := steps[k].runRetried(fmt.Sprintf("step %d", k+1), config, curl, w, session)
+		if err != nil && steps[k].ContinueOnError && !session.Stopped() {
+			w(fmt.Sprintf("step failed, continuing: %s\n", err))
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runRetried runs the step with its retry policy, what the step prints is matched by "on error matching" as well
+func (this *FormulaStep) runRetried(what string, config IConfig, curl ICurl, w common.IWriter, session *common.Session) error {
+	if this.Retry == nil {
+		return this.Run(config, curl, w, session)
+	}
+	return this.Retry.Run(what, w, session, func() (string, error) {
+		printed := &strings.Builder{}
+		transcript := func(text string) {
+			printed.WriteString(text)
+		}
+		output := func(text string) {
+			w(text)
+			transcript(text)
+		}
+		err := this.Run(config, curl, output, session.WithTranscript(transcript))
+		return printed.String(), err
+	})
+}
+
+func (this *FormulaStep) Run(config IConfig, curl ICurl, w common.IWriter, session *common.Session) error {
+	timeout, err := common.ParseDuration(this.Timeout)
+	if err != nil {
+		return err
+	}
+	if timeout > 0 {
+		stop, timedOut, release := common.WithTimeout(session.ForceStop, timeout)
+		defer release()
+		err = this.run(config, curl, w, session.WithForceStop(stop))
+		if timedOut() {
+			return fmt.Errorf("step timed out after %s", timeout)
+		}
+		return err
+	}
+	return this.run(config, curl, w, session)
+}
+
+func (this *FormulaStep) run(config IConfig, curl ICurl, w common.IWriter, session *common.Session) error {
+	var params map[string]string
+	if session != nil {
+		params = session.Params
+	}
+	template := NewTemplate(config).WithParams(params).WithVars(session.GetVars())
+	// nested steps are rendered when they run, they may refer to variables set by this one
+	own := *this
+	own.Then, own.Else, own.Do = nil, nil, nil
+	// the step is shown with its secrets masked and run with them
+	shown, _ := own.render(template.Masked())
+	step, err := own.render(template)
+	if err != nil {
+		return err
+	}
+	step.Then, step.Else, step.Do = this.Then, this.Else, this.Do
+	session, err = step.withEnv(session)
+	if err != nil {
+		return err
+	}
+	if step.SaveOutputAs == "" {
+		return step.runRendered(config, curl, &shown, w, nil, session)
+	}
+	if session == nil || session.Variables == nil {
+		return fmt.Errorf("save output as %s: the run keeps no variables", step.SaveOutputAs)
+	}
+	captured := &strings.Builder{}
+	capture := func(text string) {
+		captured.WriteString(text)
+	}
+	err = step.runRendered(config, curl, &shown, w, capture, session.WithCapture(capture))
+	if err != nil {
+		return err
+	}
+	value, err := step.extract(captured.String())
+	if err != nil {
+		return fmt.Errorf("save output as %s: %w", step.SaveOutputAs, err)
+	}
+	session.Variables.Set(step.SaveOutputAs, value)
+	w(fmt.Sprintf("saved output as %s\n", step.SaveOutputAs))
+	return nil
+}
+
+// render returns a copy of the step rendered with template, the commands it runs through a shell get
+// the values of params quoted so that a value cannot run as shell code, the other fields get them as they are
+func (this *FormulaStep) render(template *Template) (FormulaStep, error) {
+	step := *this
+	err := template.RenderValue(&step)
+	quoted := template.Quoted()
+	commands := step.shellCommands()
+	for k, command := range this.shellCommands() {
+		// the references that cannot be resolved are those of the whole step, already in err
+		*commands[k], _ = quoted.Render(*command)
+	}
+	return step, err
+}
+
+// shellCommands points to the fields of the step that run through a shell
+func (this *FormulaStep) shellCommands() []*string {
+	commands := []*string{&this.RunLinuxCommand, &this.RunLinuxCommandByCsv}
+	if this.RunBashScript != nil {
+		commands = append(commands, &this.RunBashScript.Content)
+	}
+	if this.If != nil {
+		commands = append(commands, &this.If.Command)
+	}
+	return commands
+}
+
+func (this *FormulaStep) withEnv(session *common.Session) (*common.Session, error) {
+	if this.EnvFile == "" && len(this.Env) == 0 && this.WorkingDirectory == "" {
+		return session, nil
+	}
+	var env []string
+	if this.EnvFile != "" {
+		fileEnv, err := common.ReadEnvFile(this.path(this.EnvFile))
+		if err != nil {
+			return nil, fmt.Errorf("env file: %w", err)
+		}
+		env = append(env, fileEnv...)
+	}
+	env = append(env, EnvList(this.Env)...)
+	return session.WithEnv(env, this.WorkingDirectory), nil
+}
+
+// path makes a relative path of the step relative to its working directory
+func (this *FormulaStep) path(path string) string {
+	if this.WorkingDirectory == "" || filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(this.WorkingDirectory, path)
+}
+
+// runRendered runs the step, what its commands print goes to capture as well when it is not nil
+func (this *FormulaStep) runRendered(config IConfig, curl ICurl, shown *FormulaStep, w common.IWriter, capture common.IWriter, session *common.Session) error {
+	output := w
+	if capture != nil {
+		output = func(text string) {
+			w(text)
+			capture(text)
+		}
+	}
+	if this.If != nil {
+		ok, err := this.If.evaluate(config, session)
+		if err != nil {
+			return fmt.Errorf("if: %w", err)
+		}
+		w(fmt.Sprintf("condition %s is %t\n", shown.If, ok))
+		steps := this.Else
+		if ok {
+			steps = this.Then
+		}
+		err = runSteps(steps, config, curl, w, session)
+		if err != nil {
+			return err
+		}
+	}
+	if this.ForEach != nil {
+		err := this.runLoop(config, curl, w, session)
+		if err != nil {
+			return err
+		}
+	}
+	if this.WaitForPort != "" || this.WaitForHttp200 != "" {
+		err := this.wait(w, session)
+		if err != nil {
+			return err
+		}
+	}
+	if this.WriteFile != nil {
+		path := this.path(this.WriteFile.Path)
+		w("writing " + path + "\n")
+		err := this.WriteFile.write(path)
+		if err != nil {
+			return err
+		}
+	}
+	if this.RunLinuxCommand != "" {
+		w("executing " + shown.RunLinuxCommand + "\n")
+		err := common.RunLinuxCommand(this.RunLinuxCommand, output, session)
+		if err != nil {
+			return err
+		}
+	}
+	if this.RunBashScript != nil {
+		w("running bash script... \n")
+		wd := ""
+		if this.RunBashScript.WorkingDirectoryConfig != "" {
+			var err error
+			wd, err = config.GetStringByKey(this.RunBashScript.WorkingDirectoryConfig)
+			if err != nil {
+				return err
+			}
+		}
+		err := common.RunBashScript(this.RunBashScript.Content, wd, output, session)
+		if err != nil {
+			return err
+		}
+	}
+	if this.RunLinuxCommandByCsv != "" {
+		w("executing " + shown.RunLinuxCommandByCsv + "\n")
+		err := common.RunLinuxCommandByCsvWithDirectory("", this.RunLinuxCommandByCsv, output, session)
+		if err != nil {
+			return err
+		}
+	}
+	if this.Output != "" {
+		w(shown.Output)
+		if capture != nil {
+			capture(this.Output)
+		}
+	}
+	if this.OpenUrl != "" {
+		cmd := exec.Command("sudo", "-u", "namph12", "firefox", "-new-tab", "-url", this.OpenUrl)
+		err := cmd.Run()
+		if err != nil {
+			return err
+		}
+	}
+	if this.HttpRequest != nil {
+		body, err := this.HttpRequest.send(config, curl, w, session)
+		if err != nil {
+			return err
+		}
+		if capture != nil {
+			capture(body)
+		}
+	}
+	return nil
+}
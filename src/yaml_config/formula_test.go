@@ -0,0 +1,59 @@
+package yaml_config
+
+import (
+	"common"
+	"strings"
+	"testing"
+)
+
+// a param is one word of a command whatever characters it holds, it never runs as shell code
+func TestFormulaStepQuotesParamsOfCommands(t *testing.T) {
+	value := "x; echo injected $(echo sub) `echo tick` 'single' \"double\" \\ $HOME > out | cat &"
+	tests := []struct {
+		name string
+		step FormulaStep
+		want string
+	}{
+		{name: "run linux command", step: FormulaStep{RunLinuxCommand: `printf '%s\n' {{param.text}}`}, want: value},
+		{name: "run linux command by csv", step: FormulaStep{RunLinuxCommandByCsv: `printf '%s\n' {{param.text}}`}, want: value},
+		{name: "run bash script", step: FormulaStep{RunBashScript: &FormulaBashScript{Content: "#!/bin/bash\nprintf '%s\\n' {{param.text}}\n"}}, want: value},
+		{
+			name: "if command",
+			step: FormulaStep{
+				If:   &FormulaCondition{Command: `test "$(printf '%s' {{param.text}})" = "$EXPECTED"`},
+				Then: []FormulaStep{{Output: "quoted\n"}},
+				Else: []FormulaStep{{Output: "not quoted\n"}},
+			},
+			want: "quoted",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			session := common.NewSession(nil)
+			session.Params = map[string]string{"text": value}
+			session.Dir = t.TempDir()
+			session.Env = []string{"EXPECTED=" + value}
+			output := &strings.Builder{}
+			if err := test.step.Run(nil, nil, func(text string) { output.WriteString(text) }, session); err != nil {
+				t.Fatalf("%s\n%s", err, output.String())
+			}
+			lines := strings.Split(strings.TrimSuffix(output.String(), "\n"), "\n")
+			if got := lines[len(lines)-1]; got != test.want {
+				t.Errorf("the command printed %q, want %q\n%s", got, test.want, output.String())
+			}
+		})
+	}
+}
+
+func TestFormulaStepKeepsParamsOfOtherFields(t *testing.T) {
+	session := common.NewSession(nil)
+	session.Params = map[string]string{"text": "it's $HOME"}
+	output := &strings.Builder{}
+	step := FormulaStep{Output: "{{param.text}}\n"}
+	if err := step.Run(nil, nil, func(text string) { output.WriteString(text) }, session); err != nil {
+		t.Fatal(err)
+	}
+	if output.String() != "it's $HOME\n" {
+		t.Errorf("got %q", output.String())
+	}
+}
@@ -14,6 +14,8 @@ type MysqlItem struct {
 	Port int `yaml:"port"`
 	DockerContainer string `yaml:"docker container"`
 	RemoteServerFromSshConfig string `yaml:"remote server from ssh config"`
+	Locks []string `yaml:"locks"`
+	Params []ParamDefinition `yaml:"params"`
 }
 
 func (this *MysqlItem) CanExport() bool {
@@ -24,7 +26,7 @@ func (this *MysqlItem) CanImport() bool {
 	return this.DatabaseName != "" && this.User != "" && this.DockerContainer != ""
 }
 
-func (this *MysqlItem) Export(getSshItemByKey func(key string) (*SshItem, error), w common.IWriter, forceStop chan bool) error {
+func (this *MysqlItem) Export(getSshItemByKey func(key string) (*SshItem, error), w common.IWriter, session *common.Session) error {
 	userOrEmpty := this.User
 	if userOrEmpty != "" {
 		userOrEmpty = "-u " + userOrEmpty
@@ -45,15 +47,15 @@ func (this *MysqlItem) Export(getSshItemByKey func(key string) (*SshItem, error)
 			userOrEmpty,
 			passOrEmpty,
 			this.DatabaseName,
-			), w, forceStop)
+			), w, session)
 		if err != nil {
 			return err
 		}
-		err = sshItem.Exec(fmt.Sprintf("docker cp %s:/db.sql db.sql", this.DockerContainer), w, forceStop)
+		err = sshItem.Exec(fmt.Sprintf("docker cp %s:/db.sql db.sql", this.DockerContainer), w, session)
 		if err != nil {
 			return err
 		}
-		err = sshItem.CopyFromRemoteToLocal(this.DatabaseName, w, forceStop)
+		err = sshItem.CopyFromRemoteToLocal(this.DatabaseName, w, session)
 		if err != nil {
 			return err
 		}
@@ -71,7 +73,7 @@ func (this *MysqlItem) Export(getSshItemByKey func(key string) (*SshItem, error)
 			passOrEmpty,
 			this.DatabaseName,
 			this.DockerContainer,
-			this.DatabaseName), "", w, forceStop)
+			this.DatabaseName), "", w, session)
 	}
 	return fmt.Errorf("cannot export database because it does not satisfy criteria for exporting")
 }
@@ -98,7 +100,7 @@ func (this *MysqlItem) FetchAll(db *sql.DB, query string, args ...interface{}) (
 	return common.FetchAll(db, query, args...)
 }
 
-func (this *MysqlItem) RunSql(getSshItemByKey func(key string) (*SshItem, error), sqlCommand string, w common.IWriter, forceStop chan bool) error {
+func (this *MysqlItem) RunSql(getSshItemByKey func(key string) (*SshItem, error), sqlCommand string, w common.IWriter, session *common.Session) error {
 	userOrEmpty := this.User
 	if userOrEmpty != "" {
 		userOrEmpty = "-u " + userOrEmpty
@@ -119,12 +121,12 @@ func (this *MysqlItem) RunSql(getSshItemByKey func(key string) (*SshItem, error)
 		if err != nil {
 			return err
 		}
-		return sshItem.Exec(command, w, forceStop)
+		return sshItem.Exec(command, w, session)
 	}
-	return common.RunLinuxCommand(command, w, forceStop)
+	return common.RunLinuxCommand(command, w, session)
 }
 
-func (this *MysqlItem) Import(w common.IWriter, forceStop chan bool) error {
+func (this *MysqlItem) Import(w common.IWriter, session *common.Session) error {
 	if !this.CanImport() {
 		return fmt.Errorf("cannot import database because it does not satisfy criteria for importing")
 	}
@@ -138,5 +140,5 @@ func (this *MysqlItem) Import(w common.IWriter, forceStop chan bool) error {
 		this.DockerContainer,
 		this.User,
 		this.Pass,
-		this.DatabaseName), "", w, forceStop)
+		this.DatabaseName), "", w, session)
 }
\ No newline at end of file
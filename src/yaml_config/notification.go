@@ -0,0 +1,146 @@
+package yaml_config
+
+import (
+	"common"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	NotifyOnSuccess   = "success"
+	NotifyOnFailure   = "failure"
+	NotifyOnCancelled = "cancelled"
+)
+
+const defaultNotificationLogLines = 20
+
+// NotificationConfig is notification.yml, it is rendered with a Template so the smtp password can be a secret.
+// "base url" is where the links back to a run point to, the server itself by default
+type NotificationConfig struct {
+	BaseUrl string                      `yaml:"base url"`
+	Smtp    *NotificationSmtp           `yaml:"smtp"`
+	Rules   map[string]NotificationRule `yaml:"rules"`
+}
+
+// NotificationSmtp is the mail server emails are sent through, "address" is host:port,
+// without a username the mail is sent without authentication
+type NotificationSmtp struct {
+	Address  string `yaml:"address"`
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+	From     string `yaml:"from"`
+}
+
+// NotificationRule tells who to notify when a run finishes. A run matches when its command is one of "commands",
+// names in which * stands for anything, e.g. "group test for *", or has one of "tags", with neither every command does.
+// "on" lists the endings notified of: success, failure (a failed or timed out run) and cancelled,
+// success and failure by default. A run quicker than "longer than" is not notified of.
+// The notification carries the last "log lines" lines of the log, 20 by default
+type NotificationRule struct {
+	Commands   []string             `yaml:"commands"`
+	Tags       []string             `yaml:"tags"`
+	On         []string             `yaml:"on"`
+	LongerThan string               `yaml:"longer than"`
+	LogLines   int                  `yaml:"log lines"`
+	Webhook    *NotificationWebhook `yaml:"webhook"`
+	Desktop    bool                 `yaml:"desktop"`
+	Email      *NotificationEmail   `yaml:"email"`
+}
+
+// NotificationWebhook is the url a json payload is posted to, written either as the url or as a map with "headers"
+type NotificationWebhook struct {
+	Url     string            `yaml:"url"`
+	Headers map[string]string `yaml:"headers"`
+}
+
+func (this *NotificationWebhook) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	if err := unmarshal(&this.Url); err == nil {
+		return nil
+	}
+	type plain NotificationWebhook
+	return unmarshal((*plain)(this))
+}
+
+// NotificationEmail is who gets a mail, the subject tells the command and how it ended unless "subject" is given
+type NotificationEmail struct {
+	To      []string `yaml:"to"`
+	Subject string   `yaml:"subject"`
+}
+
+// Check tells what is wrong with the rule
+func (this *NotificationRule) Check(smtp *NotificationSmtp) error {
+	for _, on := range this.On {
+		switch on {
+		case NotifyOnSuccess, NotifyOnFailure, NotifyOnCancelled:
+		default:
+			return fmt.Errorf("on must be %s, %s or %s", NotifyOnSuccess, NotifyOnFailure, NotifyOnCancelled)
+		}
+	}
+	if _, err := common.ParseDuration(this.LongerThan); err != nil {
+		return fmt.Errorf("longer than: %w", err)
+	}
+	if this.Webhook == nil && !this.Desktop && this.Email == nil {
+		return fmt.Errorf("a rule needs a webhook, desktop or email")
+	}
+	if this.Webhook != nil && this.Webhook.Url == "" {
+		return fmt.Errorf("webhook needs a url")
+	}
+	if this.Email != nil && len(this.Email.To) == 0 {
+		return fmt.Errorf("email needs to")
+	}
+	if this.Email != nil && (smtp == nil || smtp.Address == "") {
+		return fmt.Errorf("email needs the smtp address of notification.yml")
+	}
+	return nil
+}
+
+// Matches tells whether a run of command with tags that ended as ending after duration is notified of
+func (this *NotificationRule) Matches(command string, tags []string, ending string, duration time.Duration) bool {
+	on := this.On
+	if len(on) == 0 {
+		on = []string{NotifyOnSuccess, NotifyOnFailure}
+	}
+	if !containsString(on, ending) {
+		return false
+	}
+	if longerThan, _ := common.ParseDuration(this.LongerThan); duration < longerThan {
+		return false
+	}
+	if len(this.Commands) == 0 && len(this.Tags) == 0 {
+		return true
+	}
+	for _, pattern := range this.Commands {
+		if commandPattern(pattern).MatchString(command) {
+			return true
+		}
+	}
+	for _, tag := range this.Tags {
+		if containsString(tags, tag) {
+			return true
+		}
+	}
+	return false
+}
+
+func (this *NotificationRule) GetLogLines() int {
+	if this.LogLines <= 0 {
+		return defaultNotificationLogLines
+	}
+	return this.LogLines
+}
+
+// commandPattern turns a command name in which * stands for anything into a regex
+func commandPattern(pattern string) *regexp.Regexp {
+	return regexp.MustCompile("^" + strings.Replace(regexp.QuoteMeta(pattern), `\*`, ".*", -1) + "$")
+}
+
+func containsString(list []string, value string) bool {
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,117 @@
+package yaml_config
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNotificationRuleMatches(t *testing.T) {
+	tests := []struct {
+		name     string
+		rule     NotificationRule
+		command  string
+		tags     []string
+		ending   string
+		duration time.Duration
+		want     bool
+	}{
+		{
+			name:   "a rule without commands nor tags matches every command",
+			rule:   NotificationRule{},
+			ending: NotifyOnFailure, command: "anything",
+			want: true,
+		},
+		{
+			name:   "success and failure are notified of by default",
+			rule:   NotificationRule{},
+			ending: NotifyOnSuccess, command: "anything",
+			want: true,
+		},
+		{
+			name:   "cancelled is not notified of by default",
+			rule:   NotificationRule{},
+			ending: NotifyOnCancelled, command: "anything",
+			want: false,
+		},
+		{
+			name:   "on keeps the listed endings only",
+			rule:   NotificationRule{On: []string{NotifyOnCancelled}},
+			ending: NotifyOnFailure, command: "anything",
+			want: false,
+		},
+		{
+			name:   "on cancelled",
+			rule:   NotificationRule{On: []string{NotifyOnCancelled}},
+			ending: NotifyOnCancelled, command: "anything",
+			want: true,
+		},
+		{
+			name:   "a command by its name",
+			rule:   NotificationRule{Commands: []string{"deploy"}},
+			ending: NotifyOnFailure, command: "deploy",
+			want: true,
+		},
+		{
+			name:   "a command name is matched whole",
+			rule:   NotificationRule{Commands: []string{"deploy"}},
+			ending: NotifyOnFailure, command: "deploy staging",
+			want: false,
+		},
+		{
+			name:   "a star stands for anything",
+			rule:   NotificationRule{Commands: []string{"group test for *"}},
+			ending: NotifyOnFailure, command: "group test for checkout",
+			want: true,
+		},
+		{
+			name:   "other characters of a pattern are not regex",
+			rule:   NotificationRule{Commands: []string{"a.c"}},
+			ending: NotifyOnFailure, command: "abc",
+			want: false,
+		},
+		{
+			name:   "a command with one of the tags",
+			rule:   NotificationRule{Tags: []string{"prod", "nightly"}},
+			ending: NotifyOnFailure, command: "deploy", tags: []string{"nightly"},
+			want: true,
+		},
+		{
+			name:   "a command with none of the tags",
+			rule:   NotificationRule{Tags: []string{"prod"}},
+			ending: NotifyOnFailure, command: "deploy", tags: []string{"staging"},
+			want: false,
+		},
+		{
+			name:   "the tags add to the commands",
+			rule:   NotificationRule{Commands: []string{"build"}, Tags: []string{"prod"}},
+			ending: NotifyOnFailure, command: "deploy", tags: []string{"prod"},
+			want: true,
+		},
+		{
+			name:   "a run quicker than longer than",
+			rule:   NotificationRule{LongerThan: "1m"},
+			ending: NotifyOnSuccess, command: "build", duration: 59 * time.Second,
+			want: false,
+		},
+		{
+			name:   "a run as long as longer than",
+			rule:   NotificationRule{LongerThan: "1m"},
+			ending: NotifyOnSuccess, command: "build", duration: time.Minute,
+			want: true,
+		},
+		{
+			name:   "a long run of the wrong ending",
+			rule:   NotificationRule{LongerThan: "1m", On: []string{NotifyOnFailure}},
+			ending: NotifyOnSuccess, command: "build", duration: time.Hour,
+			want: false,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := test.rule.Matches(test.command, test.tags, test.ending, test.duration)
+			if got != test.want {
+				t.Errorf("Matches(%s, %v, %s, %s) = %v, want %v", test.command, test.tags, test.ending, test.duration, got, test.want)
+			}
+		})
+	}
+}
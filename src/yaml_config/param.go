@@ -0,0 +1,245 @@
+package yaml_config
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	ParamTypeString   = "string"
+	ParamTypeInt      = "int"
+	ParamTypeBool     = "bool"
+	ParamTypeEnum     = "enum"
+	ParamTypeFilePath = "file path"
+)
+
+var ErrInvalidParams = fmt.Errorf("invalid params")
+
+// ParamDefinition declares a named parameter of a command, e.g. in the "params" list of a formula.yml item.
+// ChoicesFrom computes the choices on the server when the command is described, e.g. "mysql tables of <name>",
+// "git branches of <name>", "docker-compose services of <name>" or "output of <linux command>", one choice per line
+type ParamDefinition struct {
+	Name        string   `yaml:"name" json:"name"`
+	Type        string   `yaml:"type" json:"type"`
+	Default     string   `yaml:"default" json:"default,omitempty"`
+	Required    bool     `yaml:"required" json:"required"`
+	Choices     []string `yaml:"choices" json:"choices,omitempty"`
+	ChoicesFrom string   `yaml:"choices from" json:"choices_from,omitempty"`
+	Description string   `yaml:"description" json:"description,omitempty"`
+}
+
+// CheckParamDefinitions tells what is wrong with the declared params of a command
+func CheckParamDefinitions(definitions []ParamDefinition) error {
+	seen := map[string]bool{}
+	for _, definition := range definitions {
+		if definition.Name == "" {
+			return fmt.Errorf("a param has no name")
+		}
+		if seen[definition.Name] {
+			return fmt.Errorf("param %s is declared twice", definition.Name)
+		}
+		seen[definition.Name] = true
+		switch definition.Type {
+		case "", ParamTypeString, ParamTypeInt, ParamTypeBool, ParamTypeFilePath:
+		case ParamTypeEnum:
+			if len(definition.Choices) == 0 && definition.ChoicesFrom == "" {
+				return fmt.Errorf("param %s is an enum without choices", definition.Name)
+			}
+		default:
+			return fmt.Errorf("param %s has unknown type %s", definition.Name, definition.Type)
+		}
+	}
+	return nil
+}
+
+// ParseParams reads the values of the declared params from the text typed after "command:".
+// Values are written as name=value, quoted with "" or '' when they contain spaces, a value without a name
+// goes to the first param not given yet. When a single param is declared the whole text is its value,
+// so "open page: http://host:80/?a=b" needs no quoting. Defaults are applied and every value is checked
+// against its type, all problems are reported at once. A param without value nor default is empty
+func ParseParams(definitions []ParamDefinition, text string) (map[string]string, error) {
+	values := map[string]string{}
+	if len(definitions) == 0 {
+		return values, nil
+	}
+	text = strings.TrimSpace(text)
+	var problems []string
+	if len(definitions) == 1 && !strings.HasPrefix(text, definitions[0].Name+"=") {
+		if text != "" {
+			values[definitions[0].Name] = text
+		}
+	} else {
+		tokens, err := splitParamText(text)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidParams, err)
+		}
+		declared := map[string]bool{}
+		for _, definition := range definitions {
+			declared[definition.Name] = true
+		}
+		var positional []string
+		for _, token := range tokens {
+			if k := strings.Index(token.text, "="); k > 0 && !token.quoted[0] && declared[token.text[:k]] {
+				if _, ok := values[token.text[:k]]; ok {
+					problems = append(problems, fmt.Sprintf("param %s is given twice", token.text[:k]))
+				}
+				values[token.text[:k]] = token.text[k+1:]
+				continue
+			}
+			positional = append(positional, token.text)
+		}
+		for _, definition := range definitions {
+			if len(positional) == 0 {
+				break
+			}
+			if _, ok := values[definition.Name]; !ok {
+				values[definition.Name] = positional[0]
+				positional = positional[1:]
+			}
+		}
+		if len(positional) > 0 {
+			problems = append(problems, fmt.Sprintf("unexpected value %s, params are %s", strconv.Quote(positional[0]), paramNames(definitions)))
+		}
+	}
+	for _, definition := range definitions {
+		value, ok := values[definition.Name]
+		if !ok {
+			if definition.Required {
+				problems = append(problems, fmt.Sprintf("param %s is required", definition.Name))
+				continue
+			}
+			if definition.Default == "" {
+				values[definition.Name] = ""
+				continue
+			}
+			value = definition.Default
+		}
+		value, err := definition.normalize(value)
+		if err != nil {
+			problems = append(problems, err.Error())
+			continue
+		}
+		values[definition.Name] = value
+	}
+	if len(problems) > 0 {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidParams, strings.Join(problems, "; "))
+	}
+	return values, nil
+}
+
+// FormatParams writes values as the text ParseParams reads back, sorted by name and quoted
+func FormatParams(values map[string]string) string {
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	escaper := strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+	var parts []string
+	for _, name := range names {
+		parts = append(parts, name+`="`+escaper.Replace(values[name])+`"`)
+	}
+	return strings.Join(parts, " ")
+}
+
+func (this *ParamDefinition) normalize(value string) (string, error) {
+	switch this.Type {
+	case ParamTypeInt:
+		if _, err := strconv.Atoi(value); err != nil {
+			return "", fmt.Errorf("param %s must be an integer, got %s", this.Name, strconv.Quote(value))
+		}
+	case ParamTypeBool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return "", fmt.Errorf("param %s must be true or false, got %s", this.Name, strconv.Quote(value))
+		}
+		return strconv.FormatBool(b), nil
+	case ParamTypeEnum:
+		// computed choices may have changed since the form was shown, they are not checked
+		if len(this.Choices) == 0 {
+			return value, nil
+		}
+		for _, choice := range this.Choices {
+			if value == choice {
+				return value, nil
+			}
+		}
+		return "", fmt.Errorf("param %s must be one of %s, got %s", this.Name, strings.Join(this.Choices, ", "), strconv.Quote(value))
+	case ParamTypeFilePath:
+		if strings.TrimSpace(value) == "" || strings.Contains(value, "\x00") {
+			return "", fmt.Errorf("param %s must be a file path, got %s", this.Name, strconv.Quote(value))
+		}
+		return filepath.Clean(value), nil
+	}
+	return value, nil
+}
+
+func paramNames(definitions []ParamDefinition) string {
+	var names []string
+	for _, definition := range definitions {
+		names = append(names, definition.Name)
+	}
+	return strings.Join(names, ", ")
+}
+
+type paramToken struct {
+	text string
+	// quoted tells for every byte of text whether it was inside quotes
+	quoted []bool
+}
+
+// splitParamText splits on spaces outside of quotes, a backslash escapes the next character except inside ''
+func splitParamText(text string) ([]paramToken, error) {
+	var tokens []paramToken
+	var current *paramToken
+	var quote byte
+	add := func(c byte, quoted bool) {
+		if current == nil {
+			current = &paramToken{}
+		}
+		current.text += string(c)
+		current.quoted = append(current.quoted, quoted)
+	}
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case quote == '\'':
+			if c == '\'' {
+				quote = 0
+			} else {
+				add(c, true)
+			}
+		case c == '\\' && i+1 < len(text):
+			i++
+			add(text[i], quote != 0)
+		case quote == '"':
+			if c == '"' {
+				quote = 0
+			} else {
+				add(c, true)
+			}
+		case c == '"' || c == '\'':
+			quote = c
+			if current == nil {
+				current = &paramToken{}
+			}
+		case c == ' ' || c == '\t' || c == '\n':
+			if current != nil {
+				tokens = append(tokens, *current)
+				current = nil
+			}
+		default:
+			add(c, false)
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("missing closing %c", quote)
+	}
+	if current != nil {
+		tokens = append(tokens, *current)
+	}
+	return tokens, nil
+}
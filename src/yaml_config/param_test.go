@@ -0,0 +1,245 @@
+package yaml_config
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func stringParams(names ...string) []ParamDefinition {
+	var definitions []ParamDefinition
+	for _, name := range names {
+		definitions = append(definitions, ParamDefinition{Name: name})
+	}
+	return definitions
+}
+
+func TestParseParams(t *testing.T) {
+	tests := []struct {
+		name        string
+		definitions []ParamDefinition
+		text        string
+		want        map[string]string
+		wantErr     bool
+	}{
+		{
+			name: "a command without params ignores the text",
+			text: "anything at all",
+			want: map[string]string{},
+		},
+		{
+			name:        "a single param takes the whole text",
+			definitions: stringParams("url"),
+			text:        "  http://host:80/?a=b&c=d e  ",
+			want:        map[string]string{"url": "http://host:80/?a=b&c=d e"},
+		},
+		{
+			name:        "a single param given by name",
+			definitions: stringParams("url"),
+			text:        `url="http://host/ x"`,
+			want:        map[string]string{"url": "http://host/ x"},
+		},
+		{
+			name:        "a single param given by name without value",
+			definitions: []ParamDefinition{{Name: "name", Default: "fallback"}},
+			text:        "name=",
+			want:        map[string]string{"name": ""},
+		},
+		{
+			name:        "a single param without text takes its default",
+			definitions: []ParamDefinition{{Name: "name", Default: "fallback"}},
+			text:        "",
+			want:        map[string]string{"name": "fallback"},
+		},
+		{
+			name:        "a single param starting with another name is the whole text",
+			definitions: stringParams("query"),
+			text:        "name=x",
+			want:        map[string]string{"query": "name=x"},
+		},
+		{
+			name:        "positional values fill the params in order",
+			definitions: stringParams("first", "second"),
+			text:        "one two",
+			want:        map[string]string{"first": "one", "second": "two"},
+		},
+		{
+			name:        "named values come first, positional ones fill the rest",
+			definitions: stringParams("first", "second", "third"),
+			text:        "second=2 one three",
+			want:        map[string]string{"first": "one", "second": "2", "third": "three"},
+		},
+		{
+			name:        "double quotes keep spaces",
+			definitions: stringParams("message", "count"),
+			text:        `message="hello   world" count=3`,
+			want:        map[string]string{"message": "hello   world", "count": "3"},
+		},
+		{
+			name:        "single quotes keep backslashes",
+			definitions: stringParams("path", "other"),
+			text:        `path='C:\dir\' other=x`,
+			want:        map[string]string{"path": `C:\dir\`, "other": "x"},
+		},
+		{
+			name:        "a backslash escapes a space outside quotes",
+			definitions: stringParams("first", "second"),
+			text:        `a\ b c`,
+			want:        map[string]string{"first": "a b", "second": "c"},
+		},
+		{
+			name:        "a backslash escapes a quote inside double quotes",
+			definitions: stringParams("message", "other"),
+			text:        `message="say \"hi\" \\ bye"`,
+			want:        map[string]string{"message": `say "hi" \ bye`, "other": ""},
+		},
+		{
+			name:        "a quoted name is a value",
+			definitions: stringParams("first", "second"),
+			text:        `"second=x"`,
+			want:        map[string]string{"first": "second=x", "second": ""},
+		},
+		{
+			name:        "an undeclared name is a value",
+			definitions: stringParams("first", "second"),
+			text:        "other=1",
+			want:        map[string]string{"first": "other=1", "second": ""},
+		},
+		{
+			name:        "an empty quoted value",
+			definitions: stringParams("first", "second"),
+			text:        `first="" second=x`,
+			want:        map[string]string{"first": "", "second": "x"},
+		},
+		{
+			name:        "types are normalized",
+			definitions: []ParamDefinition{{Name: "verbose", Type: ParamTypeBool}, {Name: "file", Type: ParamTypeFilePath}, {Name: "count", Type: ParamTypeInt, Default: "7"}},
+			text:        "verbose=1 file=a/../b/./c",
+			want:        map[string]string{"verbose": "true", "file": "b/c", "count": "7"},
+		},
+		{
+			name:        "an enum value among its choices",
+			definitions: []ParamDefinition{{Name: "env", Type: ParamTypeEnum, Choices: []string{"dev", "prod"}}, {Name: "other"}},
+			text:        "env=prod",
+			want:        map[string]string{"env": "prod", "other": ""},
+		},
+		{
+			name:        "a missing closing quote",
+			definitions: stringParams("first", "second"),
+			text:        `first="open`,
+			wantErr:     true,
+		},
+		{
+			name:        "a param given twice",
+			definitions: stringParams("first", "second"),
+			text:        "first=1 first=2",
+			wantErr:     true,
+		},
+		{
+			name:        "more values than params",
+			definitions: stringParams("first", "second"),
+			text:        "1 2 3",
+			wantErr:     true,
+		},
+		{
+			name:        "a required param missing",
+			definitions: []ParamDefinition{{Name: "first", Required: true}, {Name: "second"}},
+			text:        "second=2",
+			wantErr:     true,
+		},
+		{
+			name:        "an int that is not one",
+			definitions: []ParamDefinition{{Name: "count", Type: ParamTypeInt}},
+			text:        "many",
+			wantErr:     true,
+		},
+		{
+			name:        "an enum value out of its choices",
+			definitions: []ParamDefinition{{Name: "env", Type: ParamTypeEnum, Choices: []string{"dev", "prod"}}},
+			text:        "staging",
+			wantErr:     true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := ParseParams(test.definitions, test.text)
+			if test.wantErr {
+				if !errors.Is(err, ErrInvalidParams) {
+					t.Fatalf("got %v and %v, want an invalid params error", got, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestFormatParams(t *testing.T) {
+	got := FormatParams(map[string]string{"b": "x y", "a": `q"\`, "c": ""})
+	want := `a="q\"\\" b="x y" c=""`
+	if got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+// the api writes the params of a run with FormatParams, the scheduler reads them back with ParseParams
+func TestFormatParamsRoundTrip(t *testing.T) {
+	tests := []struct {
+		name        string
+		definitions []ParamDefinition
+		values      map[string]string
+	}{
+		{
+			name:        "plain values",
+			definitions: stringParams("first", "second"),
+			values:      map[string]string{"first": "one", "second": "two"},
+		},
+		{
+			name:        "spaces, quotes and backslashes",
+			definitions: stringParams("first", "second", "third"),
+			values:      map[string]string{"first": `  say "hi"  `, "second": `C:\dir\`, "third": `it's a \"test\"`},
+		},
+		{
+			name:        "empty values and equal signs",
+			definitions: stringParams("first", "second"),
+			values:      map[string]string{"first": "", "second": "a=b=c"},
+		},
+		{
+			name:        "line breaks and tabs",
+			definitions: stringParams("script", "other"),
+			values:      map[string]string{"script": "echo 1\n\techo 2\n", "other": "x"},
+		},
+		{
+			name:        "a value looking like another param",
+			definitions: stringParams("first", "second"),
+			values:      map[string]string{"first": "second=x", "second": "first=y"},
+		},
+		{
+			name:        "a single param",
+			definitions: stringParams("url"),
+			values:      map[string]string{"url": `http://host/?a="b c"&d=\e`},
+		},
+		{
+			name:        "a single empty param",
+			definitions: []ParamDefinition{{Name: "name", Default: "fallback"}},
+			values:      map[string]string{"name": ""},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			text := FormatParams(test.values)
+			got, err := ParseParams(test.definitions, text)
+			if err != nil {
+				t.Fatalf("%s: %s", text, err)
+			}
+			if !reflect.DeepEqual(got, test.values) {
+				t.Errorf("%s is read as %q, want %q", text, got, test.values)
+			}
+		})
+	}
+}
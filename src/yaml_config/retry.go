@@ -0,0 +1,161 @@
+package yaml_config
+
+import (
+	"common"
+	"errors"
+	"fmt"
+	"regexp"
+	"time"
+)
+
+const (
+	RetryBackoffFixed       = "fixed"
+	RetryBackoffExponential = "exponential"
+)
+
+const defaultRetryDelay = time.Second
+
+// RetryPolicy is the "retry" of a formula step, curl item, automated check step or workflow node, written either
+// as a number of attempts or as a map. The thing is run up to "attempts" times in all, waiting "delay" (1s by default)
+// between two attempts, doubled after every attempt with the "exponential" backoff up to "max delay".
+// Every failure is retried unless "on exit codes" or "on error matching" are given, then only the failures
+// with one of those exit codes or whose error or output matches the regex are. "on status codes" makes
+// an http answer with one of those statuses a failure, e.g. 502 and 503.
+// A retried automated check step sends the request step before it again, only when that step has a "retry" as well
+type RetryPolicy struct {
+	Attempts        int    `yaml:"attempts"`
+	Backoff         string `yaml:"backoff"`
+	Delay           string `yaml:"delay"`
+	MaxDelay        string `yaml:"max delay"`
+	OnExitCodes     []int  `yaml:"on exit codes"`
+	OnErrorMatching string `yaml:"on error matching"`
+	OnStatusCodes   []int  `yaml:"on status codes"`
+}
+
+func (this *RetryPolicy) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	if err := unmarshal(&this.Attempts); err == nil {
+		return nil
+	}
+	type plain RetryPolicy
+	return unmarshal((*plain)(this))
+}
+
+// Check tells what is wrong with the policy, a nil policy runs things once
+func (this *RetryPolicy) Check() error {
+	if this == nil {
+		return nil
+	}
+	_, _, _, err := this.parse()
+	return err
+}
+
+func (this *RetryPolicy) parse() (delay time.Duration, maxDelay time.Duration, pattern *regexp.Regexp, err error) {
+	if this.Attempts < 0 {
+		return 0, 0, nil, fmt.Errorf("attempts must not be negative")
+	}
+	switch this.Backoff {
+	case "", RetryBackoffFixed, RetryBackoffExponential:
+	default:
+		return 0, 0, nil, fmt.Errorf("backoff must be %s or %s", RetryBackoffFixed, RetryBackoffExponential)
+	}
+	delay = defaultRetryDelay
+	if this.Delay != "" {
+		if delay, err = common.ParseDuration(this.Delay); err != nil {
+			return 0, 0, nil, fmt.Errorf("delay: %w", err)
+		}
+	}
+	if maxDelay, err = common.ParseDuration(this.MaxDelay); err != nil {
+		return 0, 0, nil, fmt.Errorf("max delay: %w", err)
+	}
+	if this.OnErrorMatching != "" {
+		if pattern, err = regexp.Compile(this.OnErrorMatching); err != nil {
+			return 0, 0, nil, fmt.Errorf("on error matching: %w", err)
+		}
+	}
+	return delay, maxDelay, pattern, nil
+}
+
+// failsOnStatus tells whether an http answer with status is a failure to retry
+func (this *RetryPolicy) failsOnStatus(status int) bool {
+	if this == nil {
+		return false
+	}
+	for _, code := range this.OnStatusCodes {
+		if code == status {
+			return true
+		}
+	}
+	return false
+}
+
+// retries tells whether a failure is retried, output is what the failed attempt printed
+func (this *RetryPolicy) retries(err error, output string, pattern *regexp.Regexp) bool {
+	if len(this.OnExitCodes) == 0 && pattern == nil {
+		return true
+	}
+	var exitErr interface{ ExitCode() int }
+	if errors.As(err, &exitErr) {
+		for _, code := range this.OnExitCodes {
+			if code == exitErr.ExitCode() {
+				return true
+			}
+		}
+	}
+	return pattern != nil && (pattern.MatchString(err.Error()) || pattern.MatchString(output))
+}
+
+// Run calls attempt until it succeeds, its failure is not retried or every attempt has been made, and returns
+// the error of the last attempt. attempt returns what it printed, for "on error matching".
+// What tells which thing is run in the log and in the attempts of session, e.g. "step 2".
+// A nil policy calls attempt once without keeping it as an attempt
+func (this *RetryPolicy) Run(what string, w common.IWriter, session *common.Session, attempt func() (string, error)) error {
+	if this == nil {
+		_, err := attempt()
+		return err
+	}
+	delay, maxDelay, pattern, err := this.parse()
+	if err != nil {
+		return fmt.Errorf("retry of %s: %w", what, err)
+	}
+	attempts := this.Attempts
+	if attempts == 0 {
+		attempts = 1
+	}
+	var stop chan bool
+	var kept *common.Attempts
+	if session != nil {
+		stop, kept = session.ForceStop, session.Attempts
+	}
+	for number := 1; ; number++ {
+		startedAt := time.Now()
+		output, err := attempt()
+		record := common.Attempt{What: what, Number: number, Of: attempts, StartedAt: startedAt, FinishedAt: time.Now()}
+		if err == nil {
+			kept.Add(record)
+			if number > 1 {
+				w(fmt.Sprintf("%s succeeded at attempt %d/%d\n", what, number, attempts))
+			}
+			return nil
+		}
+		record.Error = err.Error()
+		kept.Add(record)
+		if number >= attempts || session.Stopped() || !this.retries(err, output, pattern) {
+			if attempts > 1 {
+				w(fmt.Sprintf("%s failed at attempt %d/%d: %s\n", what, number, attempts, err))
+			}
+			return err
+		}
+		w(fmt.Sprintf("%s failed at attempt %d/%d: %s, retrying in %s\n", what, number, attempts, err, delay))
+		select {
+		case <-time.After(delay):
+		case <-stop:
+			return common.ErrForceStopped
+		}
+		if this.Backoff == RetryBackoffExponential {
+			delay *= 2
+			if maxDelay > 0 && delay > maxDelay {
+				delay = maxDelay
+			}
+		}
+	}
+}
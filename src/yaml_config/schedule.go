@@ -0,0 +1,69 @@
+package yaml_config
+
+import (
+	"common"
+	"fmt"
+	"time"
+)
+
+const (
+	ScheduleOverlapSkip  = "skip"
+	ScheduleOverlapQueue = "queue"
+	ScheduleOverlapAllow = "allow"
+)
+
+// ScheduleItem is one entry of schedule.yml, it runs a command with its param at the times of the "cron" expression
+// or "every" interval. "overlap" tells what to do when the previous run is still going on: "skip" the new one
+// (the default), "queue" it until the previous one has finished or "allow" both. Every run is delayed by a random
+// duration up to "jitter", "enabled: false" keeps the entry without running it
+type ScheduleItem struct {
+	Command string `yaml:"command" json:"command"`
+	Param   string `yaml:"param" json:"param,omitempty"`
+	Cron    string `yaml:"cron" json:"cron,omitempty"`
+	Every   string `yaml:"every" json:"every,omitempty"`
+	Overlap string `yaml:"overlap" json:"overlap,omitempty"`
+	Jitter  string `yaml:"jitter" json:"jitter,omitempty"`
+	Enabled *bool  `yaml:"enabled" json:"-"`
+}
+
+// Parse checks the item and returns its cron expression, or else its interval, and its jitter
+func (this *ScheduleItem) Parse() (*common.CronExpression, time.Duration, time.Duration, error) {
+	if this.Command == "" {
+		return nil, 0, 0, fmt.Errorf("a schedule needs a command")
+	}
+	switch this.Overlap {
+	case "", ScheduleOverlapSkip, ScheduleOverlapQueue, ScheduleOverlapAllow:
+	default:
+		return nil, 0, 0, fmt.Errorf("overlap must be %s, %s or %s", ScheduleOverlapSkip, ScheduleOverlapQueue, ScheduleOverlapAllow)
+	}
+	jitter, err := common.ParseDuration(this.Jitter)
+	if err != nil {
+		return nil, 0, 0, fmt.Errorf("jitter: %w", err)
+	}
+	if (this.Cron == "") == (this.Every == "") {
+		return nil, 0, 0, fmt.Errorf("a schedule needs either cron or every")
+	}
+	if this.Cron != "" {
+		cron, err := common.ParseCron(this.Cron)
+		return cron, 0, jitter, err
+	}
+	every, err := common.ParseDuration(this.Every)
+	if err != nil {
+		return nil, 0, 0, fmt.Errorf("every: %w", err)
+	}
+	if every <= 0 {
+		return nil, 0, 0, fmt.Errorf("every must be positive")
+	}
+	return nil, every, jitter, nil
+}
+
+func (this *ScheduleItem) IsEnabled() bool {
+	return this.Enabled == nil || *this.Enabled
+}
+
+func (this *ScheduleItem) GetOverlap() string {
+	if this.Overlap == "" {
+		return ScheduleOverlapSkip
+	}
+	return this.Overlap
+}
@@ -12,20 +12,20 @@ type SshItem struct {
 	WorkingDirectory string `yaml:"working directory"`
 }
 
-func (this *SshItem) Exec(command string, writer common.IWriter, forceStop chan bool) error {
+func (this *SshItem) Exec(command string, writer common.IWriter, session *common.Session) error {
 	return common.RunLinuxCommand(fmt.Sprintf("ssh %s@%s -p %s \"%s\"",
 		this.User,
 		this.Host,
 		this.Port,
 		command,
-		), writer, forceStop)
+		), writer, session)
 }
 
-func (this *SshItem) CopyFromRemoteToLocal(localFileNameToBeSaved string, writer common.IWriter, forceStop chan bool) error {
+func (this *SshItem) CopyFromRemoteToLocal(localFileNameToBeSaved string, writer common.IWriter, session *common.Session) error {
 	return common.RunLinuxCommand(fmt.Sprintf("scp -P %s %s@%s:db.sql data/%s.sql",
 		this.Port,
 		this.User,
 		this.Host,
 		localFileNameToBeSaved,
-	), writer, forceStop)
+	), writer, session)
 }
@@ -0,0 +1,276 @@
+package yaml_config
+
+import (
+	"fmt"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const defaultSecretsFile = "config/secrets.yml"
+
+const maskedSecret = "******"
+
+// a reference is {{namespace.key}}, keys of config.yml may contain spaces, e.g. {{config.go root}}
+var templateReferencePattern = regexp.MustCompile(`\{\{\s*([\w-]+)\.([^{}]*?)\s*\}\}`)
+
+// UnresolvedError lists every reference a template could not resolve
+type UnresolvedError struct {
+	References []string
+}
+
+func (this *UnresolvedError) Error() string {
+	return "unresolved references: " + strings.Join(this.References, ", ")
+}
+
+// Template resolves the references found in yaml config values:
+// {{config.key}} a key of config.yml, {{env.NAME}} an environment variable, {{param.name}} a param of the command,
+// {{secret.name}} a key of the secrets file (config key "secrets file", config/secrets.yml by default),
+// {{var.name}} a variable of the run, e.g. the item of a "for each" step,
+// and {{now.timestamp}}, {{now.date}}, {{now.time}}, {{now.datetime}} or {{now.iso}}
+type Template struct {
+	config  IConfig
+	params  map[string]string
+	vars    map[string]string
+	anyVars bool
+	now     time.Time
+	masked  bool
+	quoted  bool
+}
+
+func NewTemplate(config IConfig) *Template {
+	return &Template{config: config, now: time.Now()}
+}
+
+// WithParams returns a template resolving {{param.name}} from params, a template without params resolves none
+func (this *Template) WithParams(params map[string]string) *Template {
+	template := *this
+	template.params = params
+	return &template
+}
+
+// WithDeclaredParams returns a template taking the declared params as given and empty, for what is checked before a run
+func (this *Template) WithDeclaredParams(definitions []ParamDefinition) *Template {
+	return this.WithParams(emptyParams(definitions))
+}
+
+// WithVars returns a template resolving {{var.name}} from vars
+func (this *Template) WithVars(vars map[string]string) *Template {
+	template := *this
+	template.vars = vars
+	return &template
+}
+
+// AnyVars returns a template taking every {{var.name}} as resolved, variables are only known once a run is going on
+func (this *Template) AnyVars() *Template {
+	template := *this
+	template.anyVars = true
+	return &template
+}
+
+// Masked returns a template writing secrets as stars, for showing what is being run
+func (this *Template) Masked() *Template {
+	template := *this
+	template.masked = true
+	return &template
+}
+
+// Quoted returns a template writing the values of params shell quoted, for commands run through a shell:
+// a value is then a single word whatever it holds, e.g. x; rm -rf ~ is written as 'x; rm -rf ~'
+func (this *Template) Quoted() *Template {
+	template := *this
+	template.quoted = true
+	return &template
+}
+
+// Render resolves every reference in text, the error lists all of those that could not be resolved
+func (this *Template) Render(text string) (string, error) {
+	var references []string
+	output := this.render(text, &references)
+	if len(references) > 0 {
+		return output, &UnresolvedError{References: references}
+	}
+	return output, nil
+}
+
+// RenderValue renders every string found in the struct, slice or map pointed to by ptr, maps and slices are
+// copied first so the value it has been copied from, e.g. an item of a parsed yaml file, is left as it is
+func (this *Template) RenderValue(ptr interface{}) error {
+	value := reflect.ValueOf(ptr)
+	if value.Kind() != reflect.Ptr || value.IsNil() {
+		return fmt.Errorf("a template renders a value through a pointer")
+	}
+	var references []string
+	value.Elem().Set(this.renderValue(value.Elem(), &references))
+	if len(references) > 0 {
+		return &UnresolvedError{References: references}
+	}
+	return nil
+}
+
+func (this *Template) renderValue(value reflect.Value, references *[]string) reflect.Value {
+	switch value.Kind() {
+	case reflect.String:
+		output := reflect.New(value.Type()).Elem()
+		output.SetString(this.render(value.String(), references))
+		return output
+	case reflect.Ptr:
+		if value.IsNil() {
+			return value
+		}
+		output := reflect.New(value.Type().Elem())
+		output.Elem().Set(this.renderValue(value.Elem(), references))
+		return output
+	case reflect.Interface:
+		if value.IsNil() {
+			return value
+		}
+		output := reflect.New(value.Type()).Elem()
+		output.Set(this.renderValue(value.Elem(), references))
+		return output
+	case reflect.Struct:
+		output := reflect.New(value.Type()).Elem()
+		output.Set(value)
+		for k := 0; k < value.NumField(); k++ {
+			if output.Field(k).CanSet() {
+				output.Field(k).Set(this.renderValue(value.Field(k), references))
+			}
+		}
+		return output
+	case reflect.Slice:
+		if value.IsNil() {
+			return value
+		}
+		output := reflect.MakeSlice(value.Type(), value.Len(), value.Len())
+		for k := 0; k < value.Len(); k++ {
+			output.Index(k).Set(this.renderValue(value.Index(k), references))
+		}
+		return output
+	case reflect.Map:
+		if value.IsNil() {
+			return value
+		}
+		output := reflect.MakeMapWithSize(value.Type(), value.Len())
+		for _, key := range value.MapKeys() {
+			output.SetMapIndex(key, this.renderValue(value.MapIndex(key), references))
+		}
+		return output
+	}
+	return value
+}
+
+func (this *Template) render(text string, references *[]string) string {
+	if !strings.Contains(text, "{{") {
+		return text
+	}
+	var secrets map[string]string
+	var secretsErr error
+	return templateReferencePattern.ReplaceAllStringFunc(text, func(reference string) string {
+		match := templateReferencePattern.FindStringSubmatch(reference)
+		namespace, key := match[1], match[2]
+		unresolved := func(reason string) string {
+			*references = appendUnique(*references, reference+" ("+reason+")")
+			return reference
+		}
+		switch namespace {
+		case "config":
+			if this.config == nil {
+				return unresolved("there is no config")
+			}
+			value, err := this.config.GetStringByKey(key)
+			if err != nil {
+				return unresolved("config.yml has no key " + key)
+			}
+			return value
+		case "env":
+			value, ok := os.LookupEnv(key)
+			if !ok {
+				return unresolved("environment variable " + key + " is not set")
+			}
+			return value
+		case "param":
+			value, ok := this.params[key]
+			if !ok {
+				return unresolved("the command has no param " + key)
+			}
+			if this.quoted {
+				return shellQuote(value)
+			}
+			return value
+		case "var":
+			value, ok := this.vars[key]
+			if !ok && !this.anyVars {
+				return unresolved("the run has no variable " + key)
+			}
+			return value
+		case "secret":
+			if secrets == nil && secretsErr == nil {
+				secrets, secretsErr = this.readSecrets()
+			}
+			if secretsErr != nil {
+				return unresolved(secretsErr.Error())
+			}
+			value, ok := secrets[key]
+			if !ok {
+				return unresolved("there is no secret " + key)
+			}
+			if this.masked {
+				return maskedSecret
+			}
+			return value
+		case "now":
+			switch key {
+			case "timestamp":
+				return strconv.FormatInt(this.now.Unix(), 10)
+			case "date":
+				return this.now.Format("2006-01-02")
+			case "time":
+				return this.now.Format("15:04:05")
+			case "datetime":
+				return this.now.Format("2006-01-02 15:04:05")
+			case "iso":
+				return this.now.Format(time.RFC3339)
+			}
+			return unresolved("now has no " + key)
+		}
+		return unresolved("unknown namespace " + namespace)
+	})
+}
+
+func (this *Template) readSecrets() (map[string]string, error) {
+	path := defaultSecretsFile
+	if this.config != nil {
+		if value, err := this.config.GetStringByKey("secrets file"); err == nil && value != "" {
+			path = value
+		}
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read secrets: %s", err)
+	}
+	secrets := map[string]string{}
+	err = yaml.Unmarshal(data, secrets)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read secrets: %s", err)
+	}
+	return secrets, nil
+}
+
+// shellQuote writes value between single quotes, inside of which a shell takes every character as it is but the quote itself
+func shellQuote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
+
+func appendUnique(list []string, item string) []string {
+	for _, v := range list {
+		if v == item {
+			return list
+		}
+	}
+	return append(list, item)
+}
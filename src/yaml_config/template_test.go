@@ -0,0 +1,220 @@
+package yaml_config
+
+import (
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// testTemplate resolves config keys, a secrets file with a token and a fixed time
+func testTemplate(t *testing.T) *Template {
+	secrets := filepath.Join(t.TempDir(), "secrets.yml")
+	if err := ioutil.WriteFile(secrets, []byte("token: s3cret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	config, err := NewConfig(map[string]string{"go root": "/usr/go", "host": "example.com", "secrets file": secrets})
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := NewTemplate(config)
+	template.now = time.Date(2024, 3, 9, 7, 5, 3, 0, time.UTC)
+	return template
+}
+
+func TestTemplateRender(t *testing.T) {
+	t.Setenv("TEMPLATE_TEST_USER", "alice")
+	template := testTemplate(t).WithParams(map[string]string{"branch": "main", "empty": ""}).WithVars(map[string]string{"item": "a.txt"})
+	tests := []struct {
+		text string
+		want string
+	}{
+		{text: "no references", want: "no references"},
+		{text: "{{config.host}}:{{ config.go root }}", want: "example.com:/usr/go"},
+		{text: "hello {{env.TEMPLATE_TEST_USER}}", want: "hello alice"},
+		{text: "git checkout {{param.branch}}{{param.empty}}", want: "git checkout main"},
+		{text: "cat {{var.item}}", want: "cat a.txt"},
+		{text: "Bearer {{secret.token}}", want: "Bearer s3cret"},
+		{text: "{{now.timestamp}}", want: "1709967903"},
+		{text: "{{now.date}} {{now.time}}", want: "2024-03-09 07:05:03"},
+		{text: "{{now.datetime}}|{{now.iso}}", want: "2024-03-09 07:05:03|2024-03-09T07:05:03Z"},
+		{text: "{not a reference} {{}}", want: "{not a reference} {{}}"},
+	}
+	for _, test := range tests {
+		got, err := template.Render(test.text)
+		if err != nil {
+			t.Errorf("Render(%q): %s", test.text, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("Render(%q) = %q, want %q", test.text, got, test.want)
+		}
+	}
+}
+
+func TestTemplateMasked(t *testing.T) {
+	got, err := testTemplate(t).Masked().Render("Bearer {{secret.token}} for {{config.host}}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "Bearer ****** for example.com" {
+		t.Errorf("got %q", got)
+	}
+}
+
+func TestTemplateQuoted(t *testing.T) {
+	template := testTemplate(t).WithParams(map[string]string{"text": "a'b; rm -rf ~ $(x)", "empty": ""}).Quoted()
+	got, err := template.Render("echo {{param.text}} {{param.empty}} {{config.host}}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// only params are quoted, config.yml and secrets are written by whoever runs the server
+	if want := `echo 'a'\''b; rm -rf ~ $(x)' '' example.com`; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+func TestTemplateUnresolved(t *testing.T) {
+	tests := []struct {
+		name     string
+		template func(*Template) *Template
+		text     string
+		want     []string
+		// wantText is the rendered text when it is not text itself
+		wantText string
+	}{
+		{
+			name: "every reference of every namespace is listed",
+			text: "{{config.missing}} {{env.TEMPLATE_TEST_NOT_SET}} {{param.branch}} {{secret.nope}} {{var.item}} {{now.week}} {{other.x}}",
+			want: []string{
+				"{{config.missing}} (config.yml has no key missing)",
+				"{{env.TEMPLATE_TEST_NOT_SET}} (environment variable TEMPLATE_TEST_NOT_SET is not set)",
+				"{{param.branch}} (the command has no param branch)",
+				"{{secret.nope}} (there is no secret nope)",
+				"{{var.item}} (the run has no variable item)",
+				"{{now.week}} (now has no week)",
+				"{{other.x}} (unknown namespace other)",
+			},
+		},
+		{
+			name: "a reference used twice is listed once",
+			text: "{{param.a}} and {{param.a}} then {{param.b}}",
+			want: []string{"{{param.a}} (the command has no param a)", "{{param.b}} (the command has no param b)"},
+		},
+		{
+			name:     "declared params count as given",
+			template: func(template *Template) *Template { return template.WithDeclaredParams(stringParams("a")) },
+			text:     "{{param.a}} {{param.b}}",
+			want:     []string{"{{param.b}} (the command has no param b)"},
+			wantText: " {{param.b}}",
+		},
+		{
+			name:     "any vars leaves variables to the run",
+			template: func(template *Template) *Template { return template.AnyVars() },
+			text:     "{{var.item}} {{var.other}}",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			template := testTemplate(t)
+			if test.template != nil {
+				template = test.template(template)
+			}
+			got, err := template.Render(test.text)
+			var unresolved *UnresolvedError
+			if len(test.want) == 0 {
+				if err != nil {
+					t.Fatalf("got %s", err)
+				}
+				return
+			}
+			if !errors.As(err, &unresolved) {
+				t.Fatalf("got %v, want an UnresolvedError", err)
+			}
+			if !reflect.DeepEqual(unresolved.References, test.want) {
+				t.Errorf("got references\n%q\nwant\n%q", unresolved.References, test.want)
+			}
+			// the references that cannot be resolved stay as they are
+			wantText := test.text
+			if test.wantText != "" {
+				wantText = test.wantText
+			}
+			if got != wantText {
+				t.Errorf("got text %q, want %q", got, wantText)
+			}
+		})
+	}
+}
+
+func TestTemplateMissingSecretsFile(t *testing.T) {
+	config, _ := NewConfig(map[string]string{"secrets file": filepath.Join(t.TempDir(), "missing.yml")})
+	_, err := NewTemplate(config).Render("{{secret.a}} {{secret.b}}")
+	var unresolved *UnresolvedError
+	if !errors.As(err, &unresolved) || len(unresolved.References) != 2 {
+		t.Fatalf("got %v, want both secrets unresolved", err)
+	}
+}
+
+func TestTemplateRenderValue(t *testing.T) {
+	type nested struct {
+		Url     string
+		Headers map[string]string
+		Args    []string
+		Count   int
+		Next    *nested
+		Any     interface{}
+		private string
+	}
+	value := nested{
+		Url:     "https://{{config.host}}/{{param.path}}",
+		Headers: map[string]string{"Authorization": "Bearer {{secret.token}}", "X-Bad": "{{env.TEMPLATE_TEST_NOT_SET}}"},
+		Args:    []string{"{{param.path}}", "{{param.missing}}"},
+		Count:   3,
+		Next:    &nested{Url: "{{config.missing}}"},
+		Any:     "{{config.host}}",
+		private: "{{param.path}}",
+	}
+	original := value
+	originalHeaders := map[string]string{}
+	for k, v := range value.Headers {
+		originalHeaders[k] = v
+	}
+	err := testTemplate(t).WithParams(map[string]string{"path": "api"}).RenderValue(&value)
+	var unresolved *UnresolvedError
+	if !errors.As(err, &unresolved) {
+		t.Fatalf("got %v, want an UnresolvedError", err)
+	}
+	// fields, map values, slices and pointers are all rendered, every failure of all of them is listed
+	want := map[string]bool{
+		"{{env.TEMPLATE_TEST_NOT_SET}} (environment variable TEMPLATE_TEST_NOT_SET is not set)": true,
+		"{{param.missing}} (the command has no param missing)":                                  true,
+		"{{config.missing}} (config.yml has no key missing)":                                    true,
+	}
+	if len(unresolved.References) != len(want) {
+		t.Errorf("got %q", unresolved.References)
+	}
+	for _, reference := range unresolved.References {
+		if !want[reference] {
+			t.Errorf("unexpected reference %q", reference)
+		}
+	}
+	if value.Url != "https://example.com/api" || value.Headers["Authorization"] != "Bearer s3cret" ||
+		value.Args[0] != "api" || value.Any != "example.com" || value.Count != 3 {
+		t.Errorf("got %+v", value)
+	}
+	if value.private != "{{param.path}}" {
+		t.Errorf("an unexported field has been rendered")
+	}
+	// the value it has been copied from keeps its references
+	if !reflect.DeepEqual(original.Headers, originalHeaders) || original.Args[0] != "{{param.path}}" || original.Next.Url != "{{config.missing}}" {
+		t.Errorf("the original has been changed: %+v", original)
+	}
+}
+
+func TestTemplateRenderValueNeedsAPointer(t *testing.T) {
+	if err := NewTemplate(nil).RenderValue("text"); err == nil {
+		t.Error("rendering a value that is not a pointer returned no error")
+	}
+}
